@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"crypto/x509"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations/audit"
+)
+
+// newAuditEvent returns an event of type about the peer certificate cert,
+// nil when the peer didn't present one
+func newAuditEvent(eventType string, cert *x509.Certificate) audit.Event {
+	e := audit.Event{
+		Type:      eventType,
+		Direction: audit.DirectionOutgoing,
+		LocalID:   config.GetIdentity().String(),
+	}
+	if config.GetServerSideFlag() {
+		e.Direction = audit.DirectionIncoming
+	}
+
+	if cert != nil {
+		e.CertFingerprint = audit.Fingerprint(cert)
+		if id, err := identity.IDFromCert(cert); err == nil {
+			e.PeerID = id.String()
+		} else if len(cert.URIs) > 0 {
+			e.PeerID = cert.URIs[0].String()
+		}
+	}
+
+	return e
+}
+
+// recordAuthn records the authentication of the peer certificate chain,
+// skipped when the chain wasn't verified (mTLS disabled)
+func recordAuthn(certs []*x509.Certificate, err error, skipped bool) {
+	if !audit.Enabled() {
+		return
+	}
+
+	var leaf *x509.Certificate
+	if len(certs) > 0 {
+		leaf = certs[0]
+	}
+
+	e := newAuditEvent(audit.TypeAuthn, leaf)
+	switch {
+	case err != nil:
+		e.Result = audit.ResultFailure
+		e.Reason = err.Error()
+	case skipped:
+		e.Result = audit.Skipped
+	default:
+		e.Result = audit.ResultSuccess
+	}
+	audit.Record(e)
+}
+
+// recordAuthz records the authorization decision of eventType about the
+// peer certificate cert, with the authz rule allowing it
+func recordAuthz(eventType string, cert *x509.Certificate, decision, rule, reason string) {
+	if !audit.Enabled() {
+		return
+	}
+
+	e := newAuditEvent(eventType, cert)
+	e.Decision = decision
+	e.Rule = rule
+	e.Reason = reason
+	if decision != audit.Skipped {
+		e.Policy = config.GetAuthzPolicy()
+	}
+	audit.Record(e)
+}
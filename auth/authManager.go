@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"github.com/quicsec/quicsec/config"
 	"github.com/quicsec/quicsec/identity"
 	"github.com/quicsec/quicsec/operations"
+	"github.com/quicsec/quicsec/operations/audit"
 	"github.com/quicsec/quicsec/operations/log"
 	"github.com/quicsec/quicsec/spiffeid"
 )
@@ -33,7 +35,6 @@ func (fn verifyOption) apply(config *verifyConfig) {
 // in the bundle.
 func Verify(certs []*x509.Certificate, opts ...VerifyOption) (spiffeid.ID, [][]*x509.Certificate, error) {
 	authLogger := log.LoggerLgr.WithName(log.ConstConnManager)
-	myPool, err := identity.GetCertPool()
 
 	authLogger.V(log.DebugLevel).Info("verify X509-SVID chain using the X.509 bundle source")
 
@@ -46,6 +47,15 @@ func Verify(certs []*x509.Certificate, opts ...VerifyOption) (spiffeid.ID, [][]*
 		return spiffeid.ID{}, nil, nil
 	}
 
+	return verifyChain(certs, opts...)
+}
+
+// verifyChain verifies an X509-SVID chain using the X.509 bundle source,
+// whether mTLS is enabled or not
+func verifyChain(certs []*x509.Certificate, opts ...VerifyOption) (spiffeid.ID, [][]*x509.Certificate, error) {
+	authLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	myPool, err := identity.GetCertPool()
+
 	if err != nil {
 		authLogger.Error(err, "failed to get system cert pool")
 	}
@@ -99,12 +109,18 @@ func ParseAndVerify(rawCerts [][]byte) (spiffeid.ID, [][]*x509.Certificate, erro
 	for _, rawCert := range rawCerts {
 		cert, err := x509.ParseCertificate(rawCert)
 		if err != nil {
-			return spiffeid.ID{}, nil, fmt.Errorf("unable to parse certificate: %w", err)
+			err = fmt.Errorf("unable to parse certificate: %w", err)
+			recordAuthn(certs, err, false)
+			return spiffeid.ID{}, nil, err
 
 		}
 		certs = append(certs, cert)
 	}
-	return Verify(certs)
+
+	id, chains, err := Verify(certs)
+	recordAuthn(certs, err, !config.GetMtlsEnable())
+
+	return id, chains, err
 }
 
 // VerifyPeerCertificate returns a VerifyPeerCertificate callback for
@@ -137,37 +153,101 @@ func WrapVerifyPeerCertificate(wrapped func([][]byte, [][]*x509.Certificate) err
 	}
 }
 
+// WrapVerifyConnection returns a VerifyConnection callback for tls.Config.
+// VerifyPeerCertificate is not called when a session is resumed, so the
+// callback runs verifyPeer against the certificates restored from the session
+// ticket. This way resumption doesn't bypass the SPIFFE authentication and
+// authorization done during the full handshake.
+func WrapVerifyConnection(verifyPeer func([][]byte, [][]*x509.Certificate) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if !cs.DidResume {
+			return nil
+		}
+
+		if verifyPeer == nil {
+			return nil
+		}
+
+		var rawCerts [][]byte
+		for _, cert := range cs.PeerCertificates {
+			rawCerts = append(rawCerts, cert.Raw)
+		}
+
+		return verifyPeer(rawCerts, nil)
+	}
+}
+
 func CustomVerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 	authLogger := log.LoggerLgr.WithName(log.ConstAuthManager)
 	authLogger.V(log.DebugLevel).Info("verify identity of peer certificate")
 
 	if !config.GetMtlsEnable() {
 		authLogger.V(log.DebugLevel).Info("mtls disabled, skip identity verification")
+		var certLog *x509.Certificate
 		if len(rawCerts) > 0 {
-			certLog, err := x509.ParseCertificate(rawCerts[0])
-			if err == nil {
+			if cert, err := x509.ParseCertificate(rawCerts[0]); err == nil {
+				certLog = cert
 				for _, uri := range certLog.URIs {
 					authLogger.V(log.DebugLevel).Info("peer certificate", "URI:", uri.String())
 				}
 			}
 		}
+		recordAuthz(audit.TypeAuthz, certLog, audit.Skipped, "", "mtls disabled")
 		return nil
 	}
 
+	return authorizePeerCertificate(rawCerts)
+}
+
+// RequireAuthorizedPeerCertificate is a VerifyPeerCertificate callback for
+// tls.Config requiring a peer certificate verified against the CA bundle,
+// with a SPIFFE ID authorized by the authz rules, whether mTLS is enabled or
+// not.
+func RequireAuthorizedPeerCertificate(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	var certs []*x509.Certificate
+	for _, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			err = fmt.Errorf("unable to parse certificate: %w", err)
+			recordAuthn(certs, err, false)
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	_, _, err := verifyChain(certs)
+	recordAuthn(certs, err, false)
+	if err != nil {
+		return err
+	}
+
+	return authorizePeerCertificate(rawCerts)
+}
+
+// authorizePeerCertificate requires a peer certificate with a SPIFFE ID
+// authorized by the authz rules
+func authorizePeerCertificate(rawCerts [][]byte) error {
+	authLogger := log.LoggerLgr.WithName(log.ConstAuthManager)
+
 	if len(rawCerts) != 1 {
-		return fmt.Errorf("auth: required exactly one peer certificate")
+		err := fmt.Errorf("auth: required exactly one peer certificate")
+		recordAuthz(audit.TypeAuthz, nil, audit.DecisionDeny, "", err.Error())
+		return err
 	}
 
 	cert, err := x509.ParseCertificate(rawCerts[0])
 
 	if err != nil {
-		return fmt.Errorf("auth: failed to parse peer certificate: %v", err)
+		err = fmt.Errorf("auth: failed to parse peer certificate: %v", err)
+		recordAuthz(audit.TypeAuthz, nil, audit.DecisionDeny, "", err.Error())
+		return err
 	}
 
 	for _, uri := range cert.URIs {
-		rv := identity.VerifyIdentity(uri.String())
+		rule, rv := identity.MatchIdentity(uri.String())
 		if rv {
 			authLogger.Info("verify peer certificate", "authorized", "yes", "URI", uri.String())
+			recordAuthz(audit.TypeAuthz, cert, audit.DecisionAllow, rule, "")
 			if config.GetMetricsEnabled() {
 				if config.GetServerSideFlag() {
 					operations.AuthzConnectiontServerId.WithLabelValues(config.GetIdentity().String(), uri.String(), "authorized").Inc()
@@ -188,5 +268,7 @@ func CustomVerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Cer
 		}
 	}
 
+	recordAuthz(audit.TypeAuthz, cert, audit.DecisionDeny, "", "no authz rule matches the peer SPIFFE ID")
+
 	return fmt.Errorf("auth: No valid spiffe ID was found =(")
 }
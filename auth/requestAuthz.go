@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations/audit"
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/spiffeid"
+)
+
+// RequestAuthorizer authorizes a request (e.g. validating a bearer token)
+// after the SPIFFE authorization. peerID is zero when the peer didn't present
+// a SPIFFE certificate (mTLS disabled).
+type RequestAuthorizer func(r *http.Request, peerID spiffeid.ID) error
+
+var requestAuthorizer RequestAuthorizer
+var requestAuthorizerLock sync.RWMutex
+
+// SetRequestAuthorizer sets the authorizer called by AuthorizeRequest, nil
+// removes it
+func SetRequestAuthorizer(a RequestAuthorizer) {
+	requestAuthorizerLock.Lock()
+	defer requestAuthorizerLock.Unlock()
+
+	requestAuthorizer = a
+}
+
+// AuthorizeRequest runs the authorization pipeline for requests that
+// establish long lived sessions (e.g. WebTransport): the SPIFFE ID of the peer
+// is checked again against the current authz rules, as they may have changed
+// since the handshake, then the request authorizer is called. It returns the
+// SPIFFE ID of the peer.
+func AuthorizeRequest(r *http.Request) (spiffeid.ID, error) {
+	authLogger := log.LoggerLgr.WithName(log.ConstAuthManager)
+
+	var peerID spiffeid.ID
+	var peerCert *x509.Certificate
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		peerCert = r.TLS.PeerCertificates[0]
+		if id, err := identity.IDFromCert(peerCert); err == nil {
+			peerID = id
+		}
+	}
+
+	var rule string
+	if config.GetMtlsEnable() {
+		if peerID.IsZero() {
+			err := errors.New("auth: peer SPIFFE ID required")
+			recordAuthz(audit.TypeRequestAuthz, peerCert, audit.DecisionDeny, "", err.Error())
+			return peerID, err
+		}
+		var ok bool
+		if rule, ok = identity.MatchIdentity(peerID.String()); !ok {
+			authLogger.Info("verify request", "authorized", "no", "URI", peerID.String())
+			recordAuthz(audit.TypeRequestAuthz, peerCert, audit.DecisionDeny, "", "no authz rule matches the peer SPIFFE ID")
+			return peerID, fmt.Errorf("auth: %s is not authorized", peerID)
+		}
+	}
+
+	requestAuthorizerLock.RLock()
+	a := requestAuthorizer
+	requestAuthorizerLock.RUnlock()
+
+	if a != nil {
+		if err := a(r, peerID); err != nil {
+			authLogger.Info("verify request", "authorized", "no", "URI", peerID.String(), "reason", err.Error())
+			recordAuthz(audit.TypeRequestAuthz, peerCert, audit.DecisionDeny, rule, err.Error())
+			return peerID, err
+		}
+	}
+
+	decision := audit.DecisionAllow
+	if rule == "" && a == nil {
+		decision = audit.Skipped
+	}
+	recordAuthz(audit.TypeRequestAuthz, peerCert, decision, rule, "")
+
+	return peerID, nil
+}
@@ -0,0 +1,183 @@
+// quicsec-logsink is a fake log receiver to try the log shipping locally
+// without Loki or a syslog daemon: it accepts the Loki pushes and the RFC
+// 5424 syslog messages (udp, tcp with octet counting framing, unix datagram)
+// and prints each received entry on stdout, prefixed by its source.
+//
+// The Loki pushes can be answered with an error status (-loki-status) or
+// delayed (-loki-delay) to exercise the retries and the backpressure of the
+// shippers.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the output is shared by the receivers
+var (
+	out    sync.Mutex
+	output io.Writer = os.Stdout
+)
+
+func main() {
+	loki := flag.String("loki", "", "listen for the Loki pushes (e.g. 127.0.0.1:3100)")
+	lokiStatus := flag.Int("loki-status", http.StatusNoContent, "status of the Loki push responses")
+	lokiDelay := flag.Duration("loki-delay", 0, "delay of the Loki push responses")
+	syslogUDP := flag.String("syslog-udp", "", "listen for syslog over udp (e.g. 127.0.0.1:5514)")
+	syslogTCP := flag.String("syslog-tcp", "", "listen for syslog over tcp (e.g. 127.0.0.1:5514)")
+	syslogUnix := flag.String("syslog-unix", "", "listen for syslog on a unix datagram socket (e.g. ./syslog.sock)")
+
+	flag.Parse()
+
+	if *loki == "" && *syslogUDP == "" && *syslogTCP == "" && *syslogUnix == "" {
+		fmt.Fprintln(os.Stderr, "at least one of -loki, -syslog-udp, -syslog-tcp or -syslog-unix is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	errs := make(chan error)
+	if *loki != "" {
+		go func() { errs <- serveLoki(*loki, *lokiStatus, *lokiDelay) }()
+	}
+	if *syslogUDP != "" {
+		go func() { errs <- serveSyslogPacket("udp", *syslogUDP) }()
+	}
+	if *syslogTCP != "" {
+		go func() { errs <- serveSyslogTCP(*syslogTCP) }()
+	}
+	if *syslogUnix != "" {
+		os.Remove(*syslogUnix)
+		go func() { errs <- serveSyslogPacket("unixgram", *syslogUnix) }()
+	}
+
+	fmt.Fprintln(os.Stderr, <-errs)
+	os.Exit(1)
+}
+
+func printEntry(source, format string, a ...interface{}) {
+	out.Lock()
+	defer out.Unlock()
+
+	fmt.Fprintf(output, "%s %s\n", source, fmt.Sprintf(format, a...))
+}
+
+func serveLoki(addr string, status int, delay time.Duration) error {
+	return http.ListenAndServe(addr, lokiHandler(status, delay))
+}
+
+// lokiHandler prints the entries of the Loki pushes, answered with status
+// after delay
+func lokiHandler(status int, delay time.Duration) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/loki/api/v1/push", func(w http.ResponseWriter, r *http.Request) {
+		var push struct {
+			Streams []struct {
+				Stream map[string]string `json:"stream"`
+				Values [][2]string       `json:"values"`
+			} `json:"streams"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		time.Sleep(delay)
+		if status/100 != 2 {
+			printEntry("loki", "push of %d streams answered with %d", len(push.Streams), status)
+			http.Error(w, "rejected by quicsec-logsink", status)
+			return
+		}
+
+		tenant := r.Header.Get("X-Scope-OrgID")
+		for _, s := range push.Streams {
+			labels, _ := json.Marshal(s.Stream)
+			for _, v := range s.Values {
+				printEntry("loki", "tenant=%q %s %s %s", tenant, labels, v[0], v[1])
+			}
+		}
+		w.WriteHeader(status)
+	})
+
+	return mux
+}
+
+// serveSyslogPacket receives one message per datagram
+func serveSyslogPacket(network, addr string) error {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		printEntry("syslog/"+network, "%s", buf[:n])
+	}
+}
+
+// serveSyslogTCP receives the messages framed with octet counting (RFC
+// 6587)
+func serveSyslogTCP(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go serveSyslogConn(c)
+	}
+}
+
+// serveSyslogConn prints the messages of a connection until it is closed
+func serveSyslogConn(c net.Conn) {
+	defer c.Close()
+
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readFrame(r)
+		if err != nil {
+			if err != io.EOF {
+				printEntry("syslog/tcp", "connection error: %v", err)
+			}
+			return
+		}
+		printEntry("syslog/tcp", "%s", msg)
+	}
+}
+
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	length, err := r.ReadString(' ')
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("invalid frame length %q", length)
+	}
+
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
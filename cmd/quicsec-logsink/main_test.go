@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureOutput returns the buffer receiving the printed entries of the test
+func captureOutput(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	out.Lock()
+	prev := output
+	output = &buf
+	out.Unlock()
+
+	t.Cleanup(func() {
+		out.Lock()
+		output = prev
+		out.Unlock()
+	})
+	return &buf
+}
+
+func TestReadFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []string
+		wantErr bool
+	}{
+		{"one frame", "5 hello", []string{"hello"}, false},
+		{"two frames", "5 hello11 <14>1 a b c", []string{"hello", "<14>1 a b c"}, false},
+		{"spaces in the message", "11 hello world", []string{"hello world"}, false},
+		{"invalid length", "abc hello", nil, true},
+		{"zero length", "0 ", nil, true},
+		{"truncated frame", "10 hello", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(tt.data))
+			for _, want := range tt.want {
+				msg, err := readFrame(r)
+				if err != nil {
+					t.Fatalf("readFrame() error = %v", err)
+				}
+				if string(msg) != want {
+					t.Errorf("readFrame() = %q, want %q", msg, want)
+				}
+			}
+			_, err := readFrame(r)
+			if tt.wantErr && (err == nil || err == io.EOF) {
+				t.Errorf("readFrame() error = %v, want an invalid frame", err)
+			}
+			if !tt.wantErr && err != io.EOF {
+				t.Errorf("readFrame() at the end = %v, want %v", err, io.EOF)
+			}
+		})
+	}
+}
+
+func TestLokiHandler(t *testing.T) {
+	buf := captureOutput(t)
+
+	push := `{"streams": [
+		{"stream": {"component": "http", "level": "info"}, "values": [["1700000000000000000", "{\"msg\":\"a\"}"], ["1700000000000000001", "{\"msg\":\"b\"}"]]},
+		{"stream": {"component": "config", "level": "error"}, "values": [["1700000000000000002", "{\"msg\":\"c\"}"]]}
+	]}`
+
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantStatus int
+		wantLines  []string
+	}{
+		{"accepted", http.StatusNoContent, push, http.StatusNoContent, []string{
+			`loki tenant="team-a" {"component":"http","level":"info"} 1700000000000000000 {"msg":"a"}`,
+			`loki tenant="team-a" {"component":"http","level":"info"} 1700000000000000001 {"msg":"b"}`,
+			`loki tenant="team-a" {"component":"config","level":"error"} 1700000000000000002 {"msg":"c"}`,
+		}},
+		{"rejected", http.StatusTooManyRequests, push, http.StatusTooManyRequests, []string{
+			"loki push of 2 streams answered with 429",
+		}},
+		{"invalid push", http.StatusNoContent, "{", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+
+			r := httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", strings.NewReader(tt.body))
+			r.Header.Set("X-Scope-OrgID", "team-a")
+			w := httptest.NewRecorder()
+			lokiHandler(tt.status, 0).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			var lines []string
+			if s := strings.TrimSpace(buf.String()); s != "" {
+				lines = strings.Split(s, "\n")
+			}
+			if strings.Join(lines, "\n") != strings.Join(tt.wantLines, "\n") {
+				t.Errorf("printed\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(tt.wantLines, "\n"))
+			}
+		})
+	}
+}
+
+func TestLokiHandlerDelay(t *testing.T) {
+	captureOutput(t)
+
+	start := time.Now()
+	r := httptest.NewRequest(http.MethodPost, "/loki/api/v1/push", strings.NewReader(`{"streams": []}`))
+	lokiHandler(http.StatusNoContent, 50*time.Millisecond).ServeHTTP(httptest.NewRecorder(), r)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("answered after %s, want the delay", elapsed)
+	}
+}
+
+func TestServeSyslogConn(t *testing.T) {
+	buf := captureOutput(t)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		serveSyslogConn(server)
+		close(done)
+	}()
+
+	client.Write([]byte("12 <134>1 first13 <131>1 second"))
+	client.Write([]byte("x invalid"))
+	client.Close()
+	<-done
+
+	want := "syslog/tcp <134>1 first\nsyslog/tcp <131>1 second\nsyslog/tcp connection error: invalid frame length \"x \"\n"
+	if buf.String() != want {
+		t.Errorf("printed %q, want %q", buf.String(), want)
+	}
+}
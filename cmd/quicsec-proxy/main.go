@@ -0,0 +1,156 @@
+// quicsec-proxy runs QuicSec as a sidecar, so applications not written in Go
+// can join the mesh without linking the library:
+//
+//   - inbound mode terminates HTTP/3 with SPIFFE mTLS and forwards the
+//     requests to the application listening on a local plaintext HTTP port
+//   - outbound mode accepts plaintext HTTP from the application and sends the
+//     requests to the upstreams over HTTP/3 with SPIFFE mTLS
+//   - tcp-inbound and tcp-outbound modes do the same for raw TCP
+//     connections, each of them tunneled over a QUIC stream
+//
+// The proxy is configured like any QuicSec application (QUICSEC_* env vars
+// and core config), so the access logs and metrics are the same.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/conn"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations"
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+// peerIDHeader carries the SPIFFE ID of the downstream verified by the
+// inbound proxy to the application
+const peerIDHeader = "X-Quicsec-Peer-Id"
+
+const (
+	modeInbound  = "inbound"
+	modeOutbound = "outbound"
+
+	modeTcpInbound  = "tcp-inbound"
+	modeTcpOutbound = "tcp-outbound"
+)
+
+func main() {
+	mode := flag.String("mode", "", "proxy mode: inbound, outbound, tcp-inbound or tcp-outbound")
+	bind := flag.String("bind", "", "bind to (e.g. 0.0.0.0:8443 for inbound, 127.0.0.1:15001 for outbound)")
+	target := flag.String("target", "", "inbound: local application (e.g. 127.0.0.1:8080); outbound: fixed upstream (e.g. bookstore:8443), the request host is used when empty; tcp-outbound: remote tcp-inbound proxy (required)")
+
+	flag.Parse()
+
+	config.LoadConfig()
+	proxyLogger := log.LoggerLgr.WithName(log.ConstProxy)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		proxyLogger.Info("stopping proxy", "mode", *mode, "signal", sig.String())
+		shutdown()
+		os.Exit(0)
+	}()
+
+	var err error
+	switch *mode {
+	case modeInbound:
+		if *bind == "" || *target == "" {
+			usage("inbound mode requires -bind and -target")
+		}
+		proxyLogger.Info("starting inbound proxy", "bind", *bind, "target", *target)
+		err = conn.ListenAndServe(*bind, newInboundProxy(*target))
+	case modeOutbound:
+		if *bind == "" {
+			usage("outbound mode requires -bind")
+		}
+		proxyLogger.Info("starting outbound proxy", "bind", *bind, "target", *target)
+		err = http.ListenAndServe(*bind, newOutboundProxy(*target))
+	case modeTcpInbound:
+		if *bind == "" || *target == "" {
+			usage("tcp-inbound mode requires -bind and -target")
+		}
+		proxyLogger.Info("starting tcp inbound proxy", "bind", *bind, "target", *target)
+		err = conn.ListenAndServeTunnel(*bind, *target)
+	case modeTcpOutbound:
+		if *bind == "" || *target == "" {
+			usage("tcp-outbound mode requires -bind and -target")
+		}
+		proxyLogger.Info("starting tcp outbound proxy", "bind", *bind, "target", *target)
+		err = conn.ListenAndForwardTunnel(*bind, *target)
+	default:
+		usage(fmt.Sprintf("invalid mode %q", *mode))
+	}
+
+	if err != nil {
+		proxyLogger.Error(err, "proxy stopped", "mode", *mode)
+		shutdown()
+		os.Exit(1)
+	}
+}
+
+// shutdown sends the log entries and the spans not exported yet
+func shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	operations.Shutdown(ctx)
+}
+
+func usage(msg string) {
+	fmt.Fprintf(os.Stderr, "quicsec-proxy: %s\n\n", msg)
+	flag.Usage()
+	os.Exit(2)
+}
+
+// newInboundProxy forwards the requests received over HTTP/3 to the local
+// application. The verified SPIFFE ID of the downstream is sent in the
+// X-Quicsec-Peer-Id header, replacing any value set by the downstream.
+func newInboundProxy(target string) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: target})
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+
+		r.Header.Del(peerIDHeader)
+		if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+			if id, err := identity.IDFromCert(r.TLS.PeerCertificates[0]); err == nil {
+				r.Header.Set(peerIDHeader, id.String())
+			}
+		}
+	}
+
+	return proxy
+}
+
+// newOutboundProxy sends the requests received from the local application to
+// the upstreams using conn.RoundTripper, so they get the same name
+// resolution, mTLS, connection pool, access logs and metrics as a QuicSec
+// client. The redirects are given back to the application and the upstream
+// request is canceled when the application disconnects. The upstream is
+// either the fixed target or the host of the request (absolute URL or Host
+// header).
+func newOutboundProxy(target string) http.Handler {
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "https"
+			if target != "" {
+				r.URL.Host = target
+			} else if r.URL.Host == "" {
+				r.URL.Host = r.Host
+			}
+			r.Host = ""
+		},
+		Transport: conn.RoundTripper{},
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestInboundProxyPeerID(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// all the values, to notice the ones set by the downstream
+		w.Header().Set("Received-Peer-Id", strings.Join(r.Header.Values(peerIDHeader), ","))
+	}))
+	defer app.Close()
+	proxy := newInboundProxy(app.Listener.Addr().String())
+
+	spiffeCert := &x509.Certificate{URIs: []*url.URL{{Scheme: "spiffe", Host: "quicsec.test", Path: "/client"}}}
+	tests := []struct {
+		name string
+		// nil when the downstream didn't present a certificate
+		cert *x509.Certificate
+		want string
+	}{
+		{"verified peer", spiffeCert, "spiffe://quicsec.test/client"},
+		{"no client certificate", nil, ""},
+		{"certificate without SPIFFE ID", &x509.Certificate{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "https://bookstore/books", nil)
+			r.Header.Add(peerIDHeader, "spiffe://quicsec.test/admin")
+			r.Header.Add(peerIDHeader, "spiffe://quicsec.test/other")
+			r.TLS = &tls.ConnectionState{}
+			if tt.cert != nil {
+				r.TLS.PeerCertificates = []*x509.Certificate{tt.cert}
+			}
+
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, want %d", w.Code, http.StatusOK)
+			}
+			if got := w.Header().Get("Received-Peer-Id"); got != tt.want {
+				t.Errorf("application got %s %q, want %q", peerIDHeader, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+)
+
+func GetAdminConfig() AdminConfigs {
+	return globalConfig.Admin
+}
+
+// ParseAdminAddress returns the network ("unix" or "tcp") and the address of
+// the admin API listener
+func ParseAdminAddress(address string) (string, string, error) {
+	if path, ok := strings.CutPrefix(address, "unix:"); ok {
+		if path == "" {
+			return "", "", fmt.Errorf("empty unix socket path")
+		}
+		return "unix", path, nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", err
+	}
+	// the captures expose the traffic secrets, the API must stay local
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return "", "", fmt.Errorf("host %q is not a loopback address", host)
+	}
+
+	return "tcp", address, nil
+}
+
+// validateAdminConfig rejects the non local addresses, a loopback address
+// without token, an empty capture path or the qlog directory as capture
+// path and a key log window that isn't bounded
+func validateAdminConfig(c AdminConfigs, debug QuicDebugConfigs) error {
+	if !c.Enable {
+		return nil
+	}
+	network, _, err := ParseAdminAddress(c.Address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", c.Address, err)
+	}
+	// any local user can connect to a loopback address
+	if network == "tcp" && c.Token == "" {
+		return fmt.Errorf("token required on the loopback address %q", c.Address)
+	}
+	if c.CapturePath == "" {
+		return fmt.Errorf("capture_path required")
+	}
+	// the captures are not removed by the qlog retention policy
+	if debug.QlogEnableFlag && filepath.Clean(c.CapturePath) == filepath.Clean(debug.QlogDirPath) {
+		return fmt.Errorf("capture_path must not be the qlog directory")
+	}
+	if c.MaxKeyLogWindow <= 0 {
+		return fmt.Errorf("max_keylog_window must be greater than zero")
+	}
+
+	return nil
+}
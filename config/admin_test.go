@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateAdminConfig(t *testing.T) {
+	qlog := QuicDebugConfigs{QlogEnableFlag: true, QlogDirPath: "./qlog/"}
+
+	tests := []struct {
+		name    string
+		c       AdminConfigs
+		debug   QuicDebugConfigs
+		wantErr bool
+	}{
+		{"disabled", AdminConfigs{Address: "0.0.0.0:9901"}, qlog, false},
+		{"unix socket", AdminConfigs{Enable: true, Address: "unix:./admin.sock", CapturePath: "./captures/", MaxKeyLogWindow: time.Minute}, qlog, false},
+		{"loopback with token", AdminConfigs{Enable: true, Address: "127.0.0.1:9901", Token: "t", CapturePath: "./captures/", MaxKeyLogWindow: time.Minute}, qlog, false},
+		{"loopback without token", AdminConfigs{Enable: true, Address: "127.0.0.1:9901", CapturePath: "./captures/", MaxKeyLogWindow: time.Minute}, qlog, true},
+		{"localhost without token", AdminConfigs{Enable: true, Address: "localhost:9901", CapturePath: "./captures/", MaxKeyLogWindow: time.Minute}, qlog, true},
+		{"not loopback", AdminConfigs{Enable: true, Address: "0.0.0.0:9901", Token: "t", CapturePath: "./captures/", MaxKeyLogWindow: time.Minute}, qlog, true},
+		{"empty unix path", AdminConfigs{Enable: true, Address: "unix:", CapturePath: "./captures/", MaxKeyLogWindow: time.Minute}, qlog, true},
+		{"no capture path", AdminConfigs{Enable: true, Address: "unix:./admin.sock", MaxKeyLogWindow: time.Minute}, qlog, true},
+		{"qlog directory", AdminConfigs{Enable: true, Address: "unix:./admin.sock", CapturePath: "qlog", MaxKeyLogWindow: time.Minute}, qlog, true},
+		{"qlog directory with qlog disabled", AdminConfigs{Enable: true, Address: "unix:./admin.sock", CapturePath: "qlog", MaxKeyLogWindow: time.Minute}, QuicDebugConfigs{QlogDirPath: "./qlog/"}, false},
+		{"unbounded key log window", AdminConfigs{Enable: true, Address: "unix:./admin.sock", CapturePath: "./captures/"}, qlog, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateAdminConfig(tt.c, tt.debug)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateAdminConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/quicsec/quicsec/operations/audit"
+)
+
+func GetAuditConfig() AuditConfigs {
+	return globalConfig.Audit
+}
+
+// validateAuditConfig requires the path of the enabled audit log
+func validateAuditConfig(c AuditConfigs) error {
+	if c.Enable && c.Path == "" {
+		return fmt.Errorf("path required by the audit log")
+	}
+
+	return nil
+}
+
+// recordConfigReload records the reload of the core config file in the
+// audit log, with the authz rules and the mTLS flag now applied
+func recordConfigReload(path string) {
+	mtls := GetMtlsEnable()
+
+	audit.Record(audit.Event{
+		Type:        audit.TypeConfigReload,
+		ConfigPath:  path,
+		Policy:      GetAuthzPolicy(),
+		AuthzRules:  GetLastAuthRules(),
+		MtlsEnabled: &mtls,
+	})
+}
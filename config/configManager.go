@@ -5,11 +5,15 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/go-logr/logr"
+	"github.com/quicsec/quicsec/operations/audit"
 	"github.com/quicsec/quicsec/operations/log"
 	"github.com/quicsec/quicsec/spiffeid"
 	"github.com/spf13/viper"
@@ -17,53 +21,264 @@ import (
 
 var onlyOnce sync.Once
 
+// reloads of the core config, see GetConfigGeneration
+var configGeneration uint64
+
 const envVarPrefix string = "QUICSEC_"
 
 type Config struct {
-	Log      LogConfigs
-	HTTP     HttpConfigs
-	Quic     QuicConfigs
-	Metrics  MetricsConfigs
-	Certs    CertificatesConfigs
-	Security SecurityConfigs
-	Local    LocalConfigs
+	Log          LogConfigs
+	HTTP         HttpConfigs
+	Quic         QuicConfigs
+	Metrics      MetricsConfigs
+	Tracing      TracingConfigs
+	Admin        AdminConfigs
+	Audit        AuditConfigs
+	Certs        CertificatesConfigs
+	Security     SecurityConfigs
+	DNS          DnsConfigs
+	Pool         PoolConfigs
+	Connect      ConnectConfigs
+	WebTransport WebTransportConfigs
+	Datagrams    DatagramsConfigs
+	Local        LocalConfigs
 }
 
 // opsManager - logs
 type LogConfigs struct {
 	LogOutputFileFlag       bool
 	LogAccessOutputFileFlag bool
-	Debug                   bool   `mapstructure:"debug"`
-	Path                    string `mapstructure:"path"`
+	Debug                   bool          `mapstructure:"debug"`
+	Path                    string        `mapstructure:"path"`
+	Loki                    LokiConfigs   `mapstructure:"loki"`
+	Syslog                  SyslogConfigs `mapstructure:"syslog"`
+}
+
+// opsManager - log shipping, batching and backpressure of a shipper
+type LogShipperConfigs struct {
+	BatchSize    int           `mapstructure:"batch_size"`
+	BatchWait    time.Duration `mapstructure:"batch_wait"`
+	QueueSize    int           `mapstructure:"queue_size"`
+	BlockTimeout time.Duration `mapstructure:"block_timeout"`
+}
+
+// LokiConfigs - push API of Loki
+type LokiConfigs struct {
+	Enable   bool   `mapstructure:"enable"`
+	URL      string `mapstructure:"url"`
+	TenantID string `mapstructure:"tenant_id"`
+	// static stream labels: "name=value,name2=value2"
+	Labels            string        `mapstructure:"labels"`
+	Timeout           time.Duration `mapstructure:"timeout"`
+	MaxRetries        int           `mapstructure:"max_retries"`
+	LogShipperConfigs `mapstructure:",squash"`
+}
+
+// SyslogConfigs - RFC 5424 over udp, tcp or unix
+type SyslogConfigs struct {
+	Enable            bool   `mapstructure:"enable"`
+	Network           string `mapstructure:"network"`
+	Address           string `mapstructure:"address"`
+	Facility          string `mapstructure:"facility"`
+	AppName           string `mapstructure:"app_name"`
+	LogShipperConfigs `mapstructure:",squash"`
 }
 
 type HttpConfigs struct {
 	Access AccessConfigs `mapstructure:"access"`
+	TCP    TcpConfigs    `mapstructure:"tcp"`
+}
+
+// TcpConfigs - TCP (HTTP/2 and HTTP/1.1) fallback listener of the servers
+type TcpConfigs struct {
+	Enable      bool `mapstructure:"enable"`
+	RequireMtls bool `mapstructure:"require_mtls"`
 }
 
 type AccessConfigs struct {
 	Path string `mapstructure:"path"`
 }
 
-// opsManager - shared secret dump
+// connManager - QUIC transport
 type QuicConfigs struct {
-	Debug QuicDebugConfigs `mapstructure:"debug"`
-}
-
-// opsManager - shared secret dump
+	Debug                          QuicDebugConfigs `mapstructure:"debug"`
+	IdleTimeout                    time.Duration    `mapstructure:"idle_timeout"`
+	HandshakeTimeout               time.Duration    `mapstructure:"handshake_timeout"`
+	KeepAlivePeriod                time.Duration    `mapstructure:"keep_alive_period"`
+	MaxIncomingStreams             int64            `mapstructure:"max_incoming_streams"`
+	MaxIncomingUniStreams          int64            `mapstructure:"max_incoming_uni_streams"`
+	InitialStreamReceiveWindow     uint64           `mapstructure:"initial_stream_receive_window"`
+	MaxStreamReceiveWindow         uint64           `mapstructure:"max_stream_receive_window"`
+	InitialConnectionReceiveWindow uint64           `mapstructure:"initial_connection_receive_window"`
+	MaxConnectionReceiveWindow     uint64           `mapstructure:"max_connection_receive_window"`
+	Versions                       []string         `mapstructure:"versions"`
+	EnableDatagrams                bool             `mapstructure:"enable_datagrams"`
+	DisablePathMTUDiscovery        bool             `mapstructure:"disable_path_mtu_discovery"`
+	SessionResumption              bool             `mapstructure:"session_resumption"`
+	SessionCacheSize               int              `mapstructure:"session_cache_size"`
+	Enable0RTT                     bool             `mapstructure:"enable_0rtt"`
+	EarlyDataMethods               []string         `mapstructure:"early_data_methods"`
+}
+
+// opsManager - shared secret dump and qlog
 type QuicDebugConfigs struct {
 	SecretFilePathEnableFlag bool
-	QlogEnableFlag           bool
+	QlogEnableFlag           bool   `mapstructure:"qlog_enable"`
 	SecretFilePath           string `mapstructure:"secret_path"`
 	QlogDirPath              string `mapstructure:"qlog_path"`
+
+	// key log rotation (0 is unlimited), backups kept as secret_path.N
+	SecretMaxSize    int64         `mapstructure:"secret_max_size"`
+	SecretMaxAge     time.Duration `mapstructure:"secret_max_age"`
+	SecretMaxBackups int           `mapstructure:"secret_max_backups"`
+
+	// production mode, the key log requires secret_i_understand
+	Production bool `mapstructure:"production"`
+	// required to write the key log in production mode
+	SecretIUnderstand bool `mapstructure:"secret_i_understand"`
+	// SPIFFE IDs of the peers whose secrets are logged, all when empty
+	SecretPeers []string `mapstructure:"secret_peers"`
+
+	// qlog retention in QlogDirPath, 0 is unlimited
+	QlogMaxFiles int           `mapstructure:"qlog_max_files"`
+	QlogMaxBytes int64         `mapstructure:"qlog_max_bytes"`
+	QlogMaxAge   time.Duration `mapstructure:"qlog_max_age"`
+
+	// ratio of the connections traced, and the traces kept by the mode
+	QlogSampleRate   float64       `mapstructure:"qlog_sample_rate"`
+	QlogSampleMode   string        `mapstructure:"qlog_sample_mode"`
+	QlogRttThreshold time.Duration `mapstructure:"qlog_rtt_threshold"`
+
+	// gzip the qlog files (.qlog.gz)
+	QlogCompress bool `mapstructure:"qlog_compress"`
 }
 
 // Operations Manager
 // opsManager - metrics
 type MetricsConfigs struct {
 	BindEnableFlag bool
-	Enable         bool `mapstructure:"enable"`
-	BindPort       int  `mapstructure:"bind_port"`
+	Enable         bool               `mapstructure:"enable"`
+	BindPort       int                `mapstructure:"bind_port"`
+	BindAddress    string             `mapstructure:"bind_address"`
+	Path           string             `mapstructure:"path"`
+	TLS            MetricsTlsConfigs  `mapstructure:"tls"`
+	Otlp           MetricsOtlpConfigs `mapstructure:"otlp"`
+
+	// distinct path labels of the HTTP metrics by identity pair, 0 is unlimited
+	MaxPathLabels int `mapstructure:"max_path_labels"`
+
+	// path label templates loaded from the core config
+	Routes []MetricsRoute `mapstructure:"-"`
+}
+
+// opsManager - TLS of the metrics endpoint, with the workload identity
+type MetricsTlsConfigs struct {
+	Enable      bool `mapstructure:"enable"`
+	RequireMtls bool `mapstructure:"require_mtls"`
+}
+
+// opsManager - metrics pushed to an OTLP collector
+type MetricsOtlpConfigs struct {
+	Enable      bool          `mapstructure:"enable"`
+	Endpoint    string        `mapstructure:"endpoint"`
+	Insecure    bool          `mapstructure:"insecure"`
+	Interval    time.Duration `mapstructure:"interval"`
+	ServiceName string        `mapstructure:"service_name"`
+}
+
+// opsManager - local admin API (qlog and keylog captures)
+type AdminConfigs struct {
+	Enable bool `mapstructure:"enable"`
+	// unix:<path> or a loopback host:port
+	Address string `mapstructure:"address"`
+	// bearer token of the requests, required on a loopback address
+	Token           string        `mapstructure:"token"`
+	CapturePath     string        `mapstructure:"capture_path"`
+	MaxKeyLogWindow time.Duration `mapstructure:"max_keylog_window"`
+}
+
+// opsManager - security audit log (JSON lines)
+type AuditConfigs struct {
+	Enable bool   `mapstructure:"enable"`
+	Path   string `mapstructure:"path"`
+}
+
+// opsManager - distributed tracing
+type TracingConfigs struct {
+	Enable      bool    `mapstructure:"enable"`
+	Exporter    string  `mapstructure:"exporter"`
+	Endpoint    string  `mapstructure:"endpoint"`
+	Insecure    bool    `mapstructure:"insecure"`
+	Path        string  `mapstructure:"path"`
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+	ServiceName string  `mapstructure:"service_name"`
+}
+
+// Connection Manager
+// connManager - name resolution
+type DnsConfigs struct {
+	HostsPath   string        `mapstructure:"hosts_path"`
+	MinTTL      time.Duration `mapstructure:"min_ttl"`
+	MaxTTL      time.Duration `mapstructure:"max_ttl"`
+	NegativeTTL time.Duration `mapstructure:"negative_ttl"`
+	StaleTTL    time.Duration `mapstructure:"stale_ttl"`
+
+	// static upstreams loaded from the core config and the hosts file
+	Upstreams map[string][]UpstreamEndpoint `mapstructure:"-"`
+}
+
+// UpstreamEndpoint is a static endpoint for an upstream name. Endpoints
+// with a lower priority are tried first, the weight is used to shuffle
+// endpoints that share the same priority.
+type UpstreamEndpoint struct {
+	Address  string
+	Priority int
+	Weight   int
+}
+
+// connManager - client connection pool
+type PoolConfigs struct {
+	MaxConnsPerEndpoint int           `mapstructure:"max_conns_per_endpoint"`
+	IdleTimeout         time.Duration `mapstructure:"idle_timeout"`
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+}
+
+// connManager - CONNECT and CONNECT-UDP proxy
+type ConnectConfigs struct {
+	Enable bool `mapstructure:"enable"`
+
+	// allowed targets by peer SPIFFE ID loaded from the core config
+	Allow map[string][]ConnectTarget `mapstructure:"-"`
+}
+
+// ConnectTarget is a target allowed for CONNECT and CONNECT-UDP. The host is
+// either "*", a "*.domain" wildcard, a CIDR or an exact name/IP, the port
+// is either "*", a port number or a range ("8000-8100").
+type ConnectTarget struct {
+	Host string
+	Port string
+}
+
+// connManager - WebTransport sessions
+type WebTransportConfigs struct {
+	Enable         bool     `mapstructure:"enable"`
+	AllowedOrigins []string `mapstructure:"allowed_origins"`
+}
+
+// connManager - datagrams
+type DatagramsConfigs struct {
+	// datagrams per second by peer identity and direction, 0 is unlimited
+	RateLimit float64 `mapstructure:"rate_limit"`
+	Burst     int     `mapstructure:"burst"`
+
+	// limits by peer SPIFFE ID loaded from the core config
+	Limits map[string]DatagramLimit `mapstructure:"-"`
+}
+
+// DatagramLimit is the rate limit of the datagrams of a peer identity
+type DatagramLimit struct {
+	RateLimit float64
+	Burst     int
 }
 
 // Identity Manager
@@ -86,6 +301,8 @@ type MtlsConfig struct {
 
 type AuthzConfigs struct {
 	SpiffeID []string
+	// server_instance_key of the qm_service_conf entry applied
+	Policy string
 }
 
 // LocalConfigs
@@ -123,6 +340,12 @@ var globalConfig = Config{
 	},
 }
 
+// GetConfigGeneration returns the number of reloads of the core config, the
+// state built with an older generation (e.g. the pooled connections) is stale
+func GetConfigGeneration() uint64 {
+	return atomic.LoadUint64(&configGeneration)
+}
+
 func GetPathCertFile() string {
 	return globalConfig.Certs.CertPath
 }
@@ -139,8 +362,25 @@ func GetLastAuthRules() []string {
 	return globalConfig.Security.Mtls.Authz.SpiffeID
 }
 
-func GetPrometheusHTTPConfig() (bool, int) {
-	return globalConfig.Metrics.BindEnableFlag, globalConfig.Metrics.BindPort
+// GetPrometheusHTTPConfig returns whether the metrics endpoint is enabled
+// and its address ("bind_address:bind_port")
+func GetPrometheusHTTPConfig() (bool, string) {
+	return globalConfig.Metrics.BindEnableFlag, net.JoinHostPort(globalConfig.Metrics.BindAddress, strconv.Itoa(globalConfig.Metrics.BindPort))
+}
+
+func GetMetricsPath() string {
+	return globalConfig.Metrics.Path
+}
+
+func GetMetricsTlsConfig() MetricsTlsConfigs {
+	return globalConfig.Metrics.TLS
+}
+
+// GetDnsCacheTTLs returns the TTL clamps (min and max) applied to the DNS
+// records, the TTL of failed lookups and how long an expired entry can be
+// served while it is refreshed in background.
+func GetDnsCacheTTLs() (time.Duration, time.Duration, time.Duration, time.Duration) {
+	return globalConfig.DNS.MinTTL, globalConfig.DNS.MaxTTL, globalConfig.DNS.NegativeTTL, globalConfig.DNS.StaleTTL
 }
 
 func GetLogFileConfig() (bool, string) {
@@ -167,12 +407,23 @@ func SetLastAuthRules(spiffeURI []string) {
 	globalConfig.Security.Mtls.Authz.SpiffeID = spiffeURI
 }
 
+// GetAuthzPolicy returns the server_instance_key of the qm_service_conf
+// entry matching the addresses of this instance
+func GetAuthzPolicy() string {
+	return globalConfig.Security.Mtls.Authz.Policy
+}
+
+func SetAuthzPolicy(policy string) {
+	globalConfig.Security.Mtls.Authz.Policy = policy
+}
+
 func GetIdentity() spiffeid.ID {
 	return globalConfig.Local.Identity
 }
 
 func SetIdentity(id spiffeid.ID) {
 	globalConfig.Local.Identity = id
+	log.SetIdentity(id.String())
 }
 
 func GetServerSideFlag() bool {
@@ -183,27 +434,167 @@ func SetServerSideFlag(f bool) {
 	globalConfig.Local.ServerSideFlag = f
 }
 
+func GetWebTransportConfig() WebTransportConfigs {
+	return globalConfig.WebTransport
+}
+
+func GetTcpConfig() TcpConfigs {
+	return globalConfig.HTTP.TCP
+}
+
 func GetMetricsEnabled() bool {
 	return globalConfig.Metrics.Enable
 }
 
+func GetMetricsOtlpConfig() MetricsOtlpConfigs {
+	return globalConfig.Metrics.Otlp
+}
+
+// validateMetricsConfig rejects a metrics path that isn't absolute or
+// shadows the health endpoints, and mTLS without TLS
+func validateMetricsConfig(c MetricsConfigs) error {
+	if !strings.HasPrefix(c.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if c.Path == "/healthz" || c.Path == "/readyz" {
+		return fmt.Errorf("path %s is reserved", c.Path)
+	}
+	if c.TLS.RequireMtls && !c.TLS.Enable {
+		return fmt.Errorf("tls.require_mtls requires tls.enable")
+	}
+	if c.MaxPathLabels < 0 {
+		return fmt.Errorf("max_path_labels must not be negative")
+	}
+
+	return nil
+}
+
+// validateMetricsOtlpConfig rejects an OTLP exporter without collector or
+// push interval
+func validateMetricsOtlpConfig(c MetricsOtlpConfigs) error {
+	if !c.Enable {
+		return nil
+	}
+	if c.Endpoint == "" {
+		return fmt.Errorf("otlp.endpoint required")
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("otlp.interval must be greater than zero")
+	}
+
+	return nil
+}
+
 func (c Config) ShowConfig() {
 	fmt.Printf("Init configuration\n")
 
 	fmt.Printf("LogVerbose:%t\n", c.Log.Debug)
 	fmt.Printf("LogOutputFile:%s\n", c.Log.Path)
+	fmt.Printf("LogLokiEnable:%t\n", c.Log.Loki.Enable)
+	fmt.Printf("LogLokiURL:%s\n", c.Log.Loki.URL)
+	fmt.Printf("LogLokiTenantID:%s\n", c.Log.Loki.TenantID)
+	fmt.Printf("LogLokiLabels:%s\n", c.Log.Loki.Labels)
+	fmt.Printf("LogLokiTimeout:%s\n", c.Log.Loki.Timeout)
+	fmt.Printf("LogLokiMaxRetries:%d\n", c.Log.Loki.MaxRetries)
+	fmt.Printf("LogLokiBatchSize:%d\n", c.Log.Loki.BatchSize)
+	fmt.Printf("LogLokiBatchWait:%s\n", c.Log.Loki.BatchWait)
+	fmt.Printf("LogLokiQueueSize:%d\n", c.Log.Loki.QueueSize)
+	fmt.Printf("LogLokiBlockTimeout:%s\n", c.Log.Loki.BlockTimeout)
+	fmt.Printf("LogSyslogEnable:%t\n", c.Log.Syslog.Enable)
+	fmt.Printf("LogSyslogNetwork:%s\n", c.Log.Syslog.Network)
+	fmt.Printf("LogSyslogAddress:%s\n", c.Log.Syslog.Address)
+	fmt.Printf("LogSyslogFacility:%s\n", c.Log.Syslog.Facility)
+	fmt.Printf("LogSyslogAppName:%s\n", c.Log.Syslog.AppName)
+	fmt.Printf("LogSyslogBatchSize:%d\n", c.Log.Syslog.BatchSize)
+	fmt.Printf("LogSyslogBatchWait:%s\n", c.Log.Syslog.BatchWait)
+	fmt.Printf("LogSyslogQueueSize:%d\n", c.Log.Syslog.QueueSize)
+	fmt.Printf("LogSyslogBlockTimeout:%s\n", c.Log.Syslog.BlockTimeout)
 	fmt.Printf("LogAccessOutputFile:%s\n", c.HTTP.Access.Path)
+	fmt.Printf("HttpTcpEnable:%t\n", c.HTTP.TCP.Enable)
+	fmt.Printf("HttpTcpRequireMtls:%t\n", c.HTTP.TCP.RequireMtls)
 
 	fmt.Printf("sharedSecretFilePath:%s\n", c.Quic.Debug.SecretFilePath)
+	fmt.Printf("sharedSecretMaxSize:%d\n", c.Quic.Debug.SecretMaxSize)
+	fmt.Printf("sharedSecretMaxAge:%s\n", c.Quic.Debug.SecretMaxAge)
+	fmt.Printf("sharedSecretMaxBackups:%d\n", c.Quic.Debug.SecretMaxBackups)
+	fmt.Printf("production:%t\n", c.Quic.Debug.Production)
+	fmt.Printf("sharedSecretIUnderstand:%t\n", c.Quic.Debug.SecretIUnderstand)
+	fmt.Printf("sharedSecretPeers:%s\n", strings.Join(c.Quic.Debug.SecretPeers, ","))
+	fmt.Printf("qlogEnable:%t\n", c.Quic.Debug.QlogEnableFlag)
 	fmt.Printf("qlogDirPath:%s\n", c.Quic.Debug.QlogDirPath)
+	fmt.Printf("qlogMaxFiles:%d\n", c.Quic.Debug.QlogMaxFiles)
+	fmt.Printf("qlogMaxBytes:%d\n", c.Quic.Debug.QlogMaxBytes)
+	fmt.Printf("qlogMaxAge:%s\n", c.Quic.Debug.QlogMaxAge)
+	fmt.Printf("qlogSampleRate:%g\n", c.Quic.Debug.QlogSampleRate)
+	fmt.Printf("qlogSampleMode:%s\n", c.Quic.Debug.QlogSampleMode)
+	fmt.Printf("qlogRttThreshold:%s\n", c.Quic.Debug.QlogRttThreshold)
+	fmt.Printf("qlogCompress:%t\n", c.Quic.Debug.QlogCompress)
+
+	fmt.Printf("QuicIdleTimeout:%s\n", c.Quic.IdleTimeout)
+	fmt.Printf("QuicHandshakeTimeout:%s\n", c.Quic.HandshakeTimeout)
+	fmt.Printf("QuicKeepAlivePeriod:%s\n", c.Quic.KeepAlivePeriod)
+	fmt.Printf("QuicMaxIncomingStreams:%d\n", c.Quic.MaxIncomingStreams)
+	fmt.Printf("QuicMaxIncomingUniStreams:%d\n", c.Quic.MaxIncomingUniStreams)
+	fmt.Printf("QuicInitialStreamReceiveWindow:%d\n", c.Quic.InitialStreamReceiveWindow)
+	fmt.Printf("QuicMaxStreamReceiveWindow:%d\n", c.Quic.MaxStreamReceiveWindow)
+	fmt.Printf("QuicInitialConnectionReceiveWindow:%d\n", c.Quic.InitialConnectionReceiveWindow)
+	fmt.Printf("QuicMaxConnectionReceiveWindow:%d\n", c.Quic.MaxConnectionReceiveWindow)
+	fmt.Printf("QuicVersions:%s\n", strings.Join(c.Quic.Versions, ","))
+	fmt.Printf("QuicEnableDatagrams:%t\n", c.Quic.EnableDatagrams)
+	fmt.Printf("QuicDisablePathMTUDiscovery:%t\n", c.Quic.DisablePathMTUDiscovery)
+	fmt.Printf("QuicSessionResumption:%t\n", c.Quic.SessionResumption)
+	fmt.Printf("QuicSessionCacheSize:%d\n", c.Quic.SessionCacheSize)
+	fmt.Printf("QuicEnable0RTT:%t\n", c.Quic.Enable0RTT)
+	fmt.Printf("QuicEarlyDataMethods:%s\n", strings.Join(c.Quic.EarlyDataMethods, ","))
 
 	fmt.Printf("MetricsEnable:%t\n", c.Metrics.Enable)
 	fmt.Printf("BindPort:%d\n", c.Metrics.BindPort)
+	fmt.Printf("BindAddress:%s\n", c.Metrics.BindAddress)
+	fmt.Printf("MetricsPath:%s\n", c.Metrics.Path)
+	fmt.Printf("MetricsTlsEnable:%t\n", c.Metrics.TLS.Enable)
+	fmt.Printf("MetricsTlsRequireMtls:%t\n", c.Metrics.TLS.RequireMtls)
+	fmt.Printf("MetricsMaxPathLabels:%d\n", c.Metrics.MaxPathLabels)
+	fmt.Printf("MetricsOtlpEnable:%t\n", c.Metrics.Otlp.Enable)
+	fmt.Printf("MetricsOtlpEndpoint:%s\n", c.Metrics.Otlp.Endpoint)
+	fmt.Printf("MetricsOtlpInsecure:%t\n", c.Metrics.Otlp.Insecure)
+	fmt.Printf("MetricsOtlpInterval:%s\n", c.Metrics.Otlp.Interval)
+	fmt.Printf("MetricsOtlpServiceName:%s\n", c.Metrics.Otlp.ServiceName)
+
+	fmt.Printf("TracingEnable:%t\n", c.Tracing.Enable)
+	fmt.Printf("TracingExporter:%s\n", c.Tracing.Exporter)
+	fmt.Printf("TracingEndpoint:%s\n", c.Tracing.Endpoint)
+	fmt.Printf("TracingInsecure:%t\n", c.Tracing.Insecure)
+	fmt.Printf("TracingPath:%s\n", c.Tracing.Path)
+	fmt.Printf("TracingSampleRatio:%g\n", c.Tracing.SampleRatio)
+	fmt.Printf("TracingServiceName:%s\n", c.Tracing.ServiceName)
+	fmt.Printf("AdminEnable:%t\n", c.Admin.Enable)
+	fmt.Printf("AdminAddress:%s\n", c.Admin.Address)
+	fmt.Printf("AdminToken:%t\n", c.Admin.Token != "")
+	fmt.Printf("AdminCapturePath:%s\n", c.Admin.CapturePath)
+	fmt.Printf("AdminMaxKeyLogWindow:%s\n", c.Admin.MaxKeyLogWindow)
+	fmt.Printf("AuditEnable:%t\n", c.Audit.Enable)
+	fmt.Printf("AuditPath:%s\n", c.Audit.Path)
 
 	fmt.Printf("CAPath:%s\n", c.Certs.CaPath)
 	fmt.Printf("KeyPath:%s\n", c.Certs.KeyPath)
 	fmt.Printf("CertPath:%s\n", c.Certs.CertPath)
 
+	fmt.Printf("DnsHostsPath:%s\n", c.DNS.HostsPath)
+	fmt.Printf("DnsMinTTL:%s\n", c.DNS.MinTTL)
+	fmt.Printf("DnsMaxTTL:%s\n", c.DNS.MaxTTL)
+	fmt.Printf("DnsNegativeTTL:%s\n", c.DNS.NegativeTTL)
+	fmt.Printf("DnsStaleTTL:%s\n", c.DNS.StaleTTL)
+
+	fmt.Printf("PoolMaxConnsPerEndpoint:%d\n", c.Pool.MaxConnsPerEndpoint)
+	fmt.Printf("PoolIdleTimeout:%s\n", c.Pool.IdleTimeout)
+	fmt.Printf("PoolHealthCheckInterval:%s\n", c.Pool.HealthCheckInterval)
+
+	fmt.Printf("ConnectEnable:%t\n", c.Connect.Enable)
+	fmt.Printf("WebTransportEnable:%t\n", c.WebTransport.Enable)
+	fmt.Printf("WebTransportAllowedOrigins:%s\n", strings.Join(c.WebTransport.AllowedOrigins, ","))
+	fmt.Printf("DatagramsRateLimit:%g\n", c.Datagrams.RateLimit)
+	fmt.Printf("DatagramsBurst:%d\n", c.Datagrams.Burst)
+
 	fmt.Printf("MtlsEnable:%t\n", c.Security.Mtls.Enable)
 	fmt.Printf("InsecureSkipVerify:%t\n", c.Security.Mtls.InsecSkipVerify)
 	fmt.Printf("Authz:\n")
@@ -246,17 +637,105 @@ func LoadConfig() Config {
 		viper.SetConfigType("json")     // Look for specific type
 
 		// defaults
-		viper.SetDefault("log.debug", true)                        // QUICSEC_LOG_DEBUG
-		viper.SetDefault("log.path", "")                           // QUICSEC_LOG_PATH
-		viper.SetDefault("http.access.path", "")                   // QUICSEC_HTTP_ACCESS_PATH
-		viper.SetDefault("quic.debug.secret_path", "")             // QUICSEC_QUIC_DEBUG_SECRET_PATH
-		viper.SetDefault("quic.debug.qlog_path", "./qlog/")        // QUICSEC_QUIC_DEBUG_QLOG_PATH
-		viper.SetDefault("metrics.enable", true)                   // QUICSEC_METRICS_ENABLE
-		viper.SetDefault("metrics.bind_port", 8080)                // QUICSEC_METRICS_BIND_PORT
-		viper.SetDefault("certs.ca_path", "certs/ca.pem")          // QUICSEC_CERTS_CA_PATH
-		viper.SetDefault("certs.key_path", "certs/cert.key")       // QUICSEC_CERTS_KEY_PATH
-		viper.SetDefault("certs.cert_path", "certs/cert.pem")      // QUICSEC_CERTS_CERT_PATH
-		viper.SetDefault("security.mtls.insec_skip_verify", false) // QUICSEC_SECURITY_MTLS_INSEC_SKIP_VERIFY
+		viper.SetDefault("log.debug", true)                             // QUICSEC_LOG_DEBUG
+		viper.SetDefault("log.path", "")                                // QUICSEC_LOG_PATH
+		viper.SetDefault("log.loki.enable", false)                      // QUICSEC_LOG_LOKI_ENABLE
+		viper.SetDefault("log.loki.url", "http://localhost:3100")       // QUICSEC_LOG_LOKI_URL
+		viper.SetDefault("log.loki.tenant_id", "")                      // QUICSEC_LOG_LOKI_TENANT_ID
+		viper.SetDefault("log.loki.labels", "service=quicsec")          // QUICSEC_LOG_LOKI_LABELS
+		viper.SetDefault("log.loki.timeout", "10s")                     // QUICSEC_LOG_LOKI_TIMEOUT
+		viper.SetDefault("log.loki.max_retries", 3)                     // QUICSEC_LOG_LOKI_MAX_RETRIES
+		viper.SetDefault("log.loki.batch_size", 1000)                   // QUICSEC_LOG_LOKI_BATCH_SIZE
+		viper.SetDefault("log.loki.batch_wait", "1s")                   // QUICSEC_LOG_LOKI_BATCH_WAIT
+		viper.SetDefault("log.loki.queue_size", 10000)                  // QUICSEC_LOG_LOKI_QUEUE_SIZE
+		viper.SetDefault("log.loki.block_timeout", "0s")                // QUICSEC_LOG_LOKI_BLOCK_TIMEOUT
+		viper.SetDefault("log.syslog.enable", false)                    // QUICSEC_LOG_SYSLOG_ENABLE
+		viper.SetDefault("log.syslog.network", "udp")                   // QUICSEC_LOG_SYSLOG_NETWORK
+		viper.SetDefault("log.syslog.address", "localhost:514")         // QUICSEC_LOG_SYSLOG_ADDRESS
+		viper.SetDefault("log.syslog.facility", "local0")               // QUICSEC_LOG_SYSLOG_FACILITY
+		viper.SetDefault("log.syslog.app_name", "quicsec")              // QUICSEC_LOG_SYSLOG_APP_NAME
+		viper.SetDefault("log.syslog.batch_size", 100)                  // QUICSEC_LOG_SYSLOG_BATCH_SIZE
+		viper.SetDefault("log.syslog.batch_wait", "1s")                 // QUICSEC_LOG_SYSLOG_BATCH_WAIT
+		viper.SetDefault("log.syslog.queue_size", 10000)                // QUICSEC_LOG_SYSLOG_QUEUE_SIZE
+		viper.SetDefault("log.syslog.block_timeout", "0s")              // QUICSEC_LOG_SYSLOG_BLOCK_TIMEOUT
+		viper.SetDefault("http.access.path", "")                        // QUICSEC_HTTP_ACCESS_PATH
+		viper.SetDefault("http.tcp.enable", true)                       // QUICSEC_HTTP_TCP_ENABLE
+		viper.SetDefault("http.tcp.require_mtls", false)                // QUICSEC_HTTP_TCP_REQUIRE_MTLS
+		viper.SetDefault("quic.debug.secret_path", "")                  // QUICSEC_QUIC_DEBUG_SECRET_PATH
+		viper.SetDefault("quic.debug.secret_max_size", 2<<20)           // QUICSEC_QUIC_DEBUG_SECRET_MAX_SIZE
+		viper.SetDefault("quic.debug.secret_max_age", "24h")            // QUICSEC_QUIC_DEBUG_SECRET_MAX_AGE
+		viper.SetDefault("quic.debug.secret_max_backups", 1)            // QUICSEC_QUIC_DEBUG_SECRET_MAX_BACKUPS
+		viper.SetDefault("quic.debug.production", true)                 // QUICSEC_QUIC_DEBUG_PRODUCTION
+		viper.SetDefault("quic.debug.secret_i_understand", false)       // QUICSEC_QUIC_DEBUG_SECRET_I_UNDERSTAND
+		viper.SetDefault("quic.debug.secret_peers", []string{})         // QUICSEC_QUIC_DEBUG_SECRET_PEERS
+		viper.SetDefault("quic.debug.qlog_enable", false)               // QUICSEC_QUIC_DEBUG_QLOG_ENABLE
+		viper.SetDefault("quic.debug.qlog_path", "./qlog/")             // QUICSEC_QUIC_DEBUG_QLOG_PATH
+		viper.SetDefault("quic.debug.qlog_max_files", 100)              // QUICSEC_QUIC_DEBUG_QLOG_MAX_FILES
+		viper.SetDefault("quic.debug.qlog_max_bytes", 256<<20)          // QUICSEC_QUIC_DEBUG_QLOG_MAX_BYTES
+		viper.SetDefault("quic.debug.qlog_max_age", "168h")             // QUICSEC_QUIC_DEBUG_QLOG_MAX_AGE
+		viper.SetDefault("quic.debug.qlog_sample_rate", 1)              // QUICSEC_QUIC_DEBUG_QLOG_SAMPLE_RATE
+		viper.SetDefault("quic.debug.qlog_sample_mode", QlogSampleAll)  // QUICSEC_QUIC_DEBUG_QLOG_SAMPLE_MODE
+		viper.SetDefault("quic.debug.qlog_rtt_threshold", "0s")         // QUICSEC_QUIC_DEBUG_QLOG_RTT_THRESHOLD
+		viper.SetDefault("quic.debug.qlog_compress", false)             // QUICSEC_QUIC_DEBUG_QLOG_COMPRESS
+		viper.SetDefault("metrics.enable", true)                        // QUICSEC_METRICS_ENABLE
+		viper.SetDefault("metrics.bind_port", 8080)                     // QUICSEC_METRICS_BIND_PORT
+		viper.SetDefault("metrics.bind_address", "0.0.0.0")             // QUICSEC_METRICS_BIND_ADDRESS
+		viper.SetDefault("metrics.path", "/metrics")                    // QUICSEC_METRICS_PATH
+		viper.SetDefault("metrics.tls.enable", false)                   // QUICSEC_METRICS_TLS_ENABLE
+		viper.SetDefault("metrics.tls.require_mtls", false)             // QUICSEC_METRICS_TLS_REQUIRE_MTLS
+		viper.SetDefault("metrics.max_path_labels", 100)                // QUICSEC_METRICS_MAX_PATH_LABELS
+		viper.SetDefault("metrics.otlp.enable", false)                  // QUICSEC_METRICS_OTLP_ENABLE
+		viper.SetDefault("metrics.otlp.endpoint", "localhost:4318")     // QUICSEC_METRICS_OTLP_ENDPOINT
+		viper.SetDefault("metrics.otlp.insecure", true)                 // QUICSEC_METRICS_OTLP_INSECURE
+		viper.SetDefault("metrics.otlp.interval", "30s")                // QUICSEC_METRICS_OTLP_INTERVAL
+		viper.SetDefault("metrics.otlp.service_name", "quicsec")        // QUICSEC_METRICS_OTLP_SERVICE_NAME
+		viper.SetDefault("tracing.enable", false)                       // QUICSEC_TRACING_ENABLE
+		viper.SetDefault("tracing.exporter", TracingExporterOtlp)       // QUICSEC_TRACING_EXPORTER
+		viper.SetDefault("tracing.endpoint", "localhost:4318")          // QUICSEC_TRACING_ENDPOINT
+		viper.SetDefault("tracing.insecure", true)                      // QUICSEC_TRACING_INSECURE
+		viper.SetDefault("tracing.path", "./traces.json")               // QUICSEC_TRACING_PATH
+		viper.SetDefault("tracing.sample_ratio", 1)                     // QUICSEC_TRACING_SAMPLE_RATIO
+		viper.SetDefault("tracing.service_name", "quicsec")             // QUICSEC_TRACING_SERVICE_NAME
+		viper.SetDefault("admin.enable", false)                         // QUICSEC_ADMIN_ENABLE
+		viper.SetDefault("admin.address", "unix:./quicsec-admin.sock")  // QUICSEC_ADMIN_ADDRESS
+		viper.SetDefault("admin.token", "")                             // QUICSEC_ADMIN_TOKEN
+		viper.SetDefault("admin.capture_path", "./captures/")           // QUICSEC_ADMIN_CAPTURE_PATH
+		viper.SetDefault("admin.max_keylog_window", "10m")              // QUICSEC_ADMIN_MAX_KEYLOG_WINDOW
+		viper.SetDefault("audit.enable", false)                         // QUICSEC_AUDIT_ENABLE
+		viper.SetDefault("audit.path", "./audit.log")                   // QUICSEC_AUDIT_PATH
+		viper.SetDefault("certs.ca_path", "certs/ca.pem")               // QUICSEC_CERTS_CA_PATH
+		viper.SetDefault("certs.key_path", "certs/cert.key")            // QUICSEC_CERTS_KEY_PATH
+		viper.SetDefault("certs.cert_path", "certs/cert.pem")           // QUICSEC_CERTS_CERT_PATH
+		viper.SetDefault("security.mtls.insec_skip_verify", false)      // QUICSEC_SECURITY_MTLS_INSEC_SKIP_VERIFY
+		viper.SetDefault("quic.idle_timeout", "0s")                     // QUICSEC_QUIC_IDLE_TIMEOUT
+		viper.SetDefault("quic.handshake_timeout", "0s")                // QUICSEC_QUIC_HANDSHAKE_TIMEOUT
+		viper.SetDefault("quic.keep_alive_period", "0s")                // QUICSEC_QUIC_KEEP_ALIVE_PERIOD
+		viper.SetDefault("quic.max_incoming_streams", 0)                // QUICSEC_QUIC_MAX_INCOMING_STREAMS
+		viper.SetDefault("quic.max_incoming_uni_streams", 0)            // QUICSEC_QUIC_MAX_INCOMING_UNI_STREAMS
+		viper.SetDefault("quic.initial_stream_receive_window", 0)       // QUICSEC_QUIC_INITIAL_STREAM_RECEIVE_WINDOW
+		viper.SetDefault("quic.max_stream_receive_window", 0)           // QUICSEC_QUIC_MAX_STREAM_RECEIVE_WINDOW
+		viper.SetDefault("quic.initial_connection_receive_window", 0)   // QUICSEC_QUIC_INITIAL_CONNECTION_RECEIVE_WINDOW
+		viper.SetDefault("quic.max_connection_receive_window", 0)       // QUICSEC_QUIC_MAX_CONNECTION_RECEIVE_WINDOW
+		viper.SetDefault("quic.versions", []string{})                   // QUICSEC_QUIC_VERSIONS
+		viper.SetDefault("quic.enable_datagrams", false)                // QUICSEC_QUIC_ENABLE_DATAGRAMS
+		viper.SetDefault("quic.disable_path_mtu_discovery", false)      // QUICSEC_QUIC_DISABLE_PATH_MTU_DISCOVERY
+		viper.SetDefault("quic.session_resumption", false)              // QUICSEC_QUIC_SESSION_RESUMPTION
+		viper.SetDefault("quic.session_cache_size", 128)                // QUICSEC_QUIC_SESSION_CACHE_SIZE
+		viper.SetDefault("quic.enable_0rtt", false)                     // QUICSEC_QUIC_ENABLE_0RTT
+		viper.SetDefault("quic.early_data_methods", "GET,HEAD,OPTIONS") // QUICSEC_QUIC_EARLY_DATA_METHODS
+		viper.SetDefault("dns.hosts_path", "")                          // QUICSEC_DNS_HOSTS_PATH
+		viper.SetDefault("dns.min_ttl", "5s")                           // QUICSEC_DNS_MIN_TTL
+		viper.SetDefault("dns.max_ttl", "5m")                           // QUICSEC_DNS_MAX_TTL
+		viper.SetDefault("dns.negative_ttl", "5s")                      // QUICSEC_DNS_NEGATIVE_TTL
+		viper.SetDefault("dns.stale_ttl", "30s")                        // QUICSEC_DNS_STALE_TTL
+		viper.SetDefault("pool.max_conns_per_endpoint", 1)              // QUICSEC_POOL_MAX_CONNS_PER_ENDPOINT
+		viper.SetDefault("pool.idle_timeout", "90s")                    // QUICSEC_POOL_IDLE_TIMEOUT
+		viper.SetDefault("pool.health_check_interval", "10s")           // QUICSEC_POOL_HEALTH_CHECK_INTERVAL
+		viper.SetDefault("connect.enable", false)                       // QUICSEC_CONNECT_ENABLE
+		viper.SetDefault("webtransport.enable", false)                  // QUICSEC_WEBTRANSPORT_ENABLE
+		viper.SetDefault("webtransport.allowed_origins", []string{})    // QUICSEC_WEBTRANSPORT_ALLOWED_ORIGINS
+		viper.SetDefault("datagrams.rate_limit", 0)                     // QUICSEC_DATAGRAMS_RATE_LIMIT
+		viper.SetDefault("datagrams.burst", 0)                          // QUICSEC_DATAGRAMS_BURST
 
 		if err := viper.ReadInConfig(); err != nil {
 			fmt.Println("config: error reading config file: " + err.Error())
@@ -265,6 +744,12 @@ func LoadConfig() Config {
 			viper.WatchConfig()
 			viper.OnConfigChange(func(e fsnotify.Event) {
 				loadSecurityConfig()
+				loadUpstreamsConfig()
+				loadConnectConfig()
+				loadDatagramsConfig()
+				loadMetricsRoutesConfig()
+				atomic.AddUint64(&configGeneration, 1)
+				recordConfigReload(e.Name)
 				confLogger.V(log.DebugLevel).Info("Security config has changed...")
 				// globalConfig.ShowConfig()
 			})
@@ -281,7 +766,55 @@ func LoadConfig() Config {
 		}
 
 		if err := viper.Unmarshal(&globalConfig); err != nil {
-			fmt.Println("config: unable to decode into struct: " + err.Error())
+			panic("config: unable to decode into struct: " + err.Error())
+		}
+
+		if err := validateQuicConfig(globalConfig.Quic); err != nil {
+			panic("config: invalid quic configuration: " + err.Error())
+		}
+
+		if err := validateDnsConfig(globalConfig.DNS); err != nil {
+			panic("config: invalid dns configuration: " + err.Error())
+		}
+
+		if err := validatePoolConfig(globalConfig.Pool); err != nil {
+			panic("config: invalid pool configuration: " + err.Error())
+		}
+
+		if err := validateMetricsConfig(globalConfig.Metrics); err != nil {
+			panic("config: invalid metrics configuration: " + err.Error())
+		}
+
+		if err := validateMetricsOtlpConfig(globalConfig.Metrics.Otlp); err != nil {
+			panic("config: invalid metrics configuration: " + err.Error())
+		}
+
+		if err := validateQlogConfig(globalConfig.Quic.Debug); err != nil {
+			panic("config: invalid qlog configuration: " + err.Error())
+		}
+
+		if err := validateKeyLogConfig(globalConfig.Quic.Debug); err != nil {
+			panic("config: invalid key log configuration: " + err.Error())
+		}
+
+		if err := validateTracingConfig(globalConfig.Tracing); err != nil {
+			panic("config: invalid tracing configuration: " + err.Error())
+		}
+
+		if err := validateAdminConfig(globalConfig.Admin, globalConfig.Quic.Debug); err != nil {
+			panic("config: invalid admin configuration: " + err.Error())
+		}
+
+		if err := validateLogShippingConfig(globalConfig.Log); err != nil {
+			panic("config: invalid log configuration: " + err.Error())
+		}
+
+		if err := validateAuditConfig(globalConfig.Audit); err != nil {
+			panic("config: invalid audit configuration: " + err.Error())
+		}
+
+		if err := validateDatagramLimit(DatagramLimit{globalConfig.Datagrams.RateLimit, globalConfig.Datagrams.Burst}); err != nil {
+			panic("config: invalid datagrams configuration: " + err.Error())
 		}
 
 		// log into file
@@ -291,14 +824,38 @@ func LoadConfig() Config {
 			globalConfig.Log.LogOutputFileFlag = false
 		}
 
+		if err := log.InitShipping(logShippingOptions(globalConfig.Log)); err != nil {
+			panic("config: invalid log configuration: " + err.Error())
+		}
+
 		log.InitLoggerLogr(globalConfig.Log.Debug, globalConfig.Log.Path)
 
 		log.InitLoggerRequest(globalConfig.Log.Debug, globalConfig.HTTP.Access.Path)
 
 		confLogger = log.LoggerLgr.WithName(log.ConstConfigManager)
+
+		// security audit log
+		if globalConfig.Audit.Enable {
+			if err := audit.Init(globalConfig.Audit.Path); err != nil {
+				confLogger.Error(err, "failed to open the audit log, the security events are not recorded")
+			}
+		}
+
 		confLogger.V(log.DebugLevel).Info("all environment variables loaded")
 		confLogger.V(log.DebugLevel).Info("core config", "path", configCorePath)
 
+		// static upstreams (after the env vars are bound)
+		loadUpstreamsConfig()
+
+		// CONNECT allow list (after the env vars are bound)
+		loadConnectConfig()
+
+		// datagram limits by identity (after the env vars are bound)
+		loadDatagramsConfig()
+
+		// path label templates of the HTTP metrics
+		loadMetricsRoutesConfig()
+
 		// pre shared secret
 		if globalConfig.Quic.Debug.SecretFilePath != "" {
 			globalConfig.Quic.Debug.SecretFilePathEnableFlag = true
@@ -346,6 +903,7 @@ func loadSecurityConfig() {
 						panic("failed to parse server_instance_key as an IP adrress format")
 					}
 					if matchIP(kIp, localIPs) {
+						SetAuthzPolicy(serverInstanceKey)
 						if policies, exists := c["policy"].(map[string]interface{}); exists {
 							for key, policyVal := range policies {
 								policyDetails := policyVal.(map[string]interface{})
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/spf13/viper"
+)
+
+var connectLock sync.RWMutex
+
+func GetConnectEnable() bool {
+	return globalConfig.Connect.Enable
+}
+
+// GetConnectAllowList returns the targets that the peer identified by the
+// SPIFFE ID peerID is allowed to reach with CONNECT and CONNECT-UDP.
+func GetConnectAllowList(peerID string) []ConnectTarget {
+	connectLock.RLock()
+	defer connectLock.RUnlock()
+
+	return globalConfig.Connect.Allow[peerID]
+}
+
+// loadConnectConfig (re)loads the CONNECT allow list from the "connect"
+// section of the core config. The SPIFFE IDs are not used as keys because
+// viper splits the keys on dots:
+//
+//	"connect": {
+//	    "allow": [
+//	        {"spiffe_id": "spiffe://foo.bar/app", "targets": ["*.foo.bar:443", "10.0.0.0/8:53"]}
+//	    ]
+//	}
+func loadConnectConfig() {
+	confLogger := log.LoggerLgr.WithName(log.ConstConfigManager)
+	allow := make(map[string][]ConnectTarget)
+
+	if viper.IsSet("connect.allow") {
+		if err := parseConnectAllow(viper.Get("connect.allow"), allow); err != nil {
+			// a broken allow list denies everything instead of allowing it partially
+			confLogger.Error(err, "failed to parse the connect allow list")
+			allow = make(map[string][]ConnectTarget)
+		}
+	}
+
+	connectLock.Lock()
+	globalConfig.Connect.Allow = allow
+	connectLock.Unlock()
+
+	confLogger.V(log.DebugLevel).Info("connect allow list loaded", "ids", len(allow))
+}
+
+func parseConnectAllow(raw interface{}, allow map[string][]ConnectTarget) error {
+	rules, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected type for 'connect.allow': %T", raw)
+	}
+
+	for _, rawRule := range rules {
+		rule, ok := rawRule.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected type for connect rule: %T", rawRule)
+		}
+
+		id, _ := rule["spiffe_id"].(string)
+		if !strings.HasPrefix(id, "spiffe://") {
+			return fmt.Errorf("connect rule with invalid spiffe_id %q", id)
+		}
+
+		targets, ok := rule["targets"].([]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected type for the targets of %s: %T", id, rule["targets"])
+		}
+
+		for _, rawTarget := range targets {
+			target, ok := rawTarget.(string)
+			if !ok {
+				return fmt.Errorf("unexpected type for a target of %s: %T", id, rawTarget)
+			}
+
+			t, err := parseConnectTarget(target)
+			if err != nil {
+				return fmt.Errorf("invalid target %q for %s: %v", target, id, err)
+			}
+			allow[id] = append(allow[id], t)
+		}
+	}
+
+	return nil
+}
+
+func parseConnectTarget(target string) (ConnectTarget, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return ConnectTarget{}, err
+	}
+
+	if port != "*" {
+		if _, _, ok := parsePortRange(port); !ok {
+			return ConnectTarget{}, fmt.Errorf("invalid port %q", port)
+		}
+	}
+
+	if strings.Contains(host, "/") {
+		if _, _, err := net.ParseCIDR(host); err != nil {
+			return ConnectTarget{}, err
+		}
+	} else if host == "" || (strings.Contains(host, "*") && host != "*" && !strings.HasPrefix(host, "*.")) {
+		return ConnectTarget{}, fmt.Errorf("invalid host %q", host)
+	}
+
+	return ConnectTarget{Host: strings.ToLower(host), Port: port}, nil
+}
+
+// MatchPort reports whether the target allows the port number port
+func (t ConnectTarget) MatchPort(port string) bool {
+	if t.Port == "*" {
+		return true
+	}
+
+	lo, hi, ok := parsePortRange(t.Port)
+	if !ok {
+		return false
+	}
+	p, ok := parsePort(port)
+	return ok && p >= lo && p <= hi
+}
+
+// parsePortRange parses a port number or a range of ports ("8000-8100")
+func parsePortRange(s string) (int, int, bool) {
+	loStr, hiStr, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hiStr = loStr
+	}
+
+	lo, ok := parsePort(loStr)
+	if !ok {
+		return 0, 0, false
+	}
+	hi, ok := parsePort(hiStr)
+	if !ok || hi < lo {
+		return 0, 0, false
+	}
+
+	return lo, hi, true
+}
+
+func parsePort(s string) (int, bool) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, false
+	}
+	return p, true
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseConnectTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		want    ConnectTarget
+		wantErr bool
+	}{
+		{"exact host and port", "API.example.org:443", ConnectTarget{Host: "api.example.org", Port: "443"}, false},
+		{"any host", "*:443", ConnectTarget{Host: "*", Port: "443"}, false},
+		{"domain wildcard", "*.example.org:443", ConnectTarget{Host: "*.example.org", Port: "443"}, false},
+		{"any port", "db.example.org:*", ConnectTarget{Host: "db.example.org", Port: "*"}, false},
+		{"port range", "db.example.org:5432-5439", ConnectTarget{Host: "db.example.org", Port: "5432-5439"}, false},
+		{"single port range", "db.example.org:5432-5432", ConnectTarget{Host: "db.example.org", Port: "5432-5432"}, false},
+		{"cidr", "10.0.0.0/8:53", ConnectTarget{Host: "10.0.0.0/8", Port: "53"}, false},
+		{"ipv6", "[2001:db8::1]:443", ConnectTarget{Host: "2001:db8::1", Port: "443"}, false},
+		{"no port", "api.example.org", ConnectTarget{}, true},
+		{"empty host", ":443", ConnectTarget{}, true},
+		{"port zero", "api.example.org:0", ConnectTarget{}, true},
+		{"port out of range", "api.example.org:65536", ConnectTarget{}, true},
+		{"port name", "api.example.org:https", ConnectTarget{}, true},
+		{"reversed range", "db.example.org:5439-5432", ConnectTarget{}, true},
+		{"open range", "db.example.org:5432-", ConnectTarget{}, true},
+		{"range out of bounds", "db.example.org:60000-70000", ConnectTarget{}, true},
+		{"wildcard inside the host", "api*.example.org:443", ConnectTarget{}, true},
+		{"invalid cidr", "10.0.0.0/33:53", ConnectTarget{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConnectTarget(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConnectTarget(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseConnectTarget(%q) = %+v, want %+v", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectTargetMatchPort(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		port   string
+		want   bool
+	}{
+		{"any port", "*", "8080", true},
+		{"same port", "443", "443", true},
+		{"other port", "443", "8443", false},
+		{"range start", "5432-5439", "5432", true},
+		{"range end", "5432-5439", "5439", true},
+		{"below the range", "5432-5439", "5431", false},
+		{"above the range", "5432-5439", "5440", false},
+		{"invalid port", "5432-5439", "postgres", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := ConnectTarget{Host: "*", Port: tt.target}
+			if got := target.MatchPort(tt.port); got != tt.want {
+				t.Errorf("MatchPort(%q) with port %q = %t, want %t", tt.port, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseConnectAllow(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     interface{}
+		wantIDs int
+		wantErr bool
+	}{
+		{"rules", []interface{}{
+			map[string]interface{}{"spiffe_id": "spiffe://foo.bar/app", "targets": []interface{}{"*.foo.bar:443", "10.0.0.0/8:53"}},
+			map[string]interface{}{"spiffe_id": "spiffe://foo.bar/db", "targets": []interface{}{"db.foo.bar:5432-5439"}},
+		}, 2, false},
+		{"not a list", map[string]interface{}{}, 0, true},
+		{"invalid spiffe id", []interface{}{
+			map[string]interface{}{"spiffe_id": "foo.bar/app", "targets": []interface{}{"*:443"}},
+		}, 0, true},
+		{"invalid target", []interface{}{
+			map[string]interface{}{"spiffe_id": "spiffe://foo.bar/app", "targets": []interface{}{"*.foo.bar"}},
+		}, 0, true},
+		{"targets not a list", []interface{}{
+			map[string]interface{}{"spiffe_id": "spiffe://foo.bar/app", "targets": "*:443"},
+		}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allow := make(map[string][]ConnectTarget)
+			err := parseConnectAllow(tt.raw, allow)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConnectAllow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(allow) != tt.wantIDs {
+				t.Errorf("parseConnectAllow() loaded %d ids, want %d", len(allow), tt.wantIDs)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/spf13/viper"
+)
+
+var datagramsLock sync.RWMutex
+
+// GetDatagramLimit returns the rate limit of the datagrams of the peer
+// identified by the SPIFFE ID peerID: its own limit from the core config,
+// the default limit otherwise.
+func GetDatagramLimit(peerID string) DatagramLimit {
+	datagramsLock.RLock()
+	defer datagramsLock.RUnlock()
+
+	if l, ok := globalConfig.Datagrams.Limits[peerID]; ok {
+		return l
+	}
+
+	return DatagramLimit{
+		RateLimit: globalConfig.Datagrams.RateLimit,
+		Burst:     globalConfig.Datagrams.Burst,
+	}
+}
+
+// validateDatagramLimit rejects the negative limits and the limits that
+// would drop every datagram
+func validateDatagramLimit(l DatagramLimit) error {
+	if l.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must not be negative")
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst must not be negative")
+	}
+	if l.RateLimit > 0 && l.Burst == 0 {
+		return fmt.Errorf("burst must be greater than zero when rate_limit is set")
+	}
+
+	return nil
+}
+
+// loadDatagramsConfig (re)loads the datagram limits by SPIFFE ID from the
+// "datagrams" section of the core config:
+//
+//	"datagrams": {
+//	    "rate_limit": 100,
+//	    "burst": 20,
+//	    "limits": [
+//	        {"spiffe_id": "spiffe://foo.bar/telemetry", "rate_limit": 1000, "burst": 200}
+//	    ]
+//	}
+func loadDatagramsConfig() {
+	confLogger := log.LoggerLgr.WithName(log.ConstConfigManager)
+	limits := make(map[string]DatagramLimit)
+
+	if viper.IsSet("datagrams.limits") {
+		if err := parseDatagramLimits(viper.Get("datagrams.limits"), limits); err != nil {
+			// the identities fall back to the default limit
+			confLogger.Error(err, "failed to parse the datagram limits")
+			limits = make(map[string]DatagramLimit)
+		}
+	}
+
+	datagramsLock.Lock()
+	globalConfig.Datagrams.Limits = limits
+	datagramsLock.Unlock()
+
+	confLogger.V(log.DebugLevel).Info("datagram limits loaded", "ids", len(limits))
+}
+
+func parseDatagramLimits(raw interface{}, limits map[string]DatagramLimit) error {
+	rules, ok := raw.([]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected type for 'datagrams.limits': %T", raw)
+	}
+
+	for _, rawRule := range rules {
+		rule, ok := rawRule.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected type for datagram limit: %T", rawRule)
+		}
+
+		id, _ := rule["spiffe_id"].(string)
+		if !strings.HasPrefix(id, "spiffe://") {
+			return fmt.Errorf("datagram limit with invalid spiffe_id %q", id)
+		}
+
+		rateLimit, ok := rule["rate_limit"].(float64)
+		if !ok {
+			return fmt.Errorf("unexpected type for the rate_limit of %s: %T", id, rule["rate_limit"])
+		}
+		burst, ok := rule["burst"].(float64)
+		if !ok {
+			return fmt.Errorf("unexpected type for the burst of %s: %T", id, rule["burst"])
+		}
+
+		l := DatagramLimit{RateLimit: rateLimit, Burst: int(burst)}
+		if err := validateDatagramLimit(l); err != nil {
+			return fmt.Errorf("invalid datagram limit for %s: %v", id, err)
+		}
+		limits[id] = l
+	}
+
+	return nil
+}
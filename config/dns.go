@@ -0,0 +1,16 @@
+package config
+
+import "fmt"
+
+// validateDnsConfig rejects TTL clamps that can't be applied, a max TTL of
+// zero disables the upper clamp
+func validateDnsConfig(c DnsConfigs) error {
+	if c.MinTTL < 0 || c.MaxTTL < 0 || c.NegativeTTL < 0 || c.StaleTTL < 0 {
+		return fmt.Errorf("min_ttl, max_ttl, negative_ttl and stale_ttl can't be negative")
+	}
+	if c.MaxTTL > 0 && c.MinTTL > c.MaxTTL {
+		return fmt.Errorf("min_ttl (%s) must not be greater than max_ttl (%s)", c.MinTTL, c.MaxTTL)
+	}
+
+	return nil
+}
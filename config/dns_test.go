@@ -0,0 +1,30 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateDnsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       DnsConfigs
+		wantErr bool
+	}{
+		{"defaults", DnsConfigs{MinTTL: 5 * time.Second, MaxTTL: 5 * time.Minute, NegativeTTL: 5 * time.Second, StaleTTL: 30 * time.Second}, false},
+		{"min equal to max", DnsConfigs{MinTTL: time.Minute, MaxTTL: time.Minute}, false},
+		{"no max", DnsConfigs{MinTTL: time.Hour}, false},
+		{"min greater than max", DnsConfigs{MinTTL: 10 * time.Minute, MaxTTL: 5 * time.Minute}, true},
+		{"negative min", DnsConfigs{MinTTL: -time.Second}, true},
+		{"negative stale", DnsConfigs{StaleTTL: -time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDnsConfig(tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateDnsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
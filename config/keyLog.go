@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GetKeyLogPeers returns the SPIFFE IDs of the peers whose TLS secrets are
+// logged, nil when the secrets of all the connections are logged
+func GetKeyLogPeers() []string {
+	if len(globalConfig.Quic.Debug.SecretPeers) == 0 {
+		return nil
+	}
+	return globalConfig.Quic.Debug.SecretPeers
+}
+
+// IsKeyLogPeer reports whether the TLS secrets of the connections with
+// peerID are logged
+func IsKeyLogPeer(peerID string) bool {
+	peers := GetKeyLogPeers()
+	if peers == nil {
+		return true
+	}
+
+	for _, p := range peers {
+		if p == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckKeyLogAllowed returns an error when the TLS traffic secrets must not
+// be written, e.g. by the key log windows of the admin API
+func CheckKeyLogAllowed() error {
+	return checkKeyLogGuard(globalConfig.Quic.Debug)
+}
+
+// checkKeyLogGuard requires secret_i_understand to write the TLS traffic
+// secrets in production mode
+func checkKeyLogGuard(c QuicDebugConfigs) error {
+	if c.Production && !c.SecretIUnderstand {
+		return fmt.Errorf("the key log writes the TLS traffic secrets, set secret_i_understand to enable it in production mode")
+	}
+	return nil
+}
+
+// validateKeyLogConfig rejects a key log in production mode without
+// secret_i_understand, negative rotation limits and peers that aren't SPIFFE
+// IDs
+func validateKeyLogConfig(c QuicDebugConfigs) error {
+	if c.SecretFilePath != "" {
+		if err := checkKeyLogGuard(c); err != nil {
+			return err
+		}
+	}
+
+	if c.SecretMaxSize < 0 || c.SecretMaxAge < 0 || c.SecretMaxBackups < 0 {
+		return fmt.Errorf("secret rotation limits must not be negative")
+	}
+
+	for _, p := range c.SecretPeers {
+		if !strings.HasPrefix(p, "spiffe://") {
+			return fmt.Errorf("invalid secret_peers entry %q", p)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package config
+
+import "testing"
+
+func TestValidateKeyLogConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       QuicDebugConfigs
+		wantErr bool
+	}{
+		{"no key log in production", QuicDebugConfigs{Production: true}, false},
+		{"production", QuicDebugConfigs{SecretFilePath: "keys.txt", Production: true}, true},
+		{"production with secret_i_understand", QuicDebugConfigs{SecretFilePath: "keys.txt", Production: true, SecretIUnderstand: true}, false},
+		{"not production", QuicDebugConfigs{SecretFilePath: "keys.txt"}, false},
+		{"negative max size", QuicDebugConfigs{SecretFilePath: "keys.txt", SecretMaxSize: -1}, true},
+		{"negative max backups", QuicDebugConfigs{SecretFilePath: "keys.txt", SecretMaxBackups: -1}, true},
+		{"peers", QuicDebugConfigs{SecretFilePath: "keys.txt", SecretPeers: []string{"spiffe://test.org/client"}}, false},
+		{"invalid peer", QuicDebugConfigs{SecretFilePath: "keys.txt", SecretPeers: []string{"client"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateKeyLogConfig(tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateKeyLogConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckKeyLogGuard(t *testing.T) {
+	tests := []struct {
+		name    string
+		c       QuicDebugConfigs
+		wantErr bool
+	}{
+		{"not production", QuicDebugConfigs{}, false},
+		{"production", QuicDebugConfigs{Production: true}, true},
+		{"production with secret_i_understand", QuicDebugConfigs{Production: true, SecretIUnderstand: true}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkKeyLogGuard(tt.c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkKeyLogGuard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
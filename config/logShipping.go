@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+// Loki label names
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// parseLokiLabels parses the static labels of the Loki streams
+// ("name=value,name2=value2")
+func parseLokiLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !lokiLabelName.MatchString(name) {
+			return nil, fmt.Errorf("invalid loki label %q", pair)
+		}
+		switch name {
+		case "component", "level", "identity":
+			return nil, fmt.Errorf("loki label %q is set by quicsec", name)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+
+	return labels, nil
+}
+
+func validateLogShipperConfig(c LogShipperConfigs) error {
+	if c.BatchSize <= 0 || c.QueueSize <= 0 {
+		return fmt.Errorf("batch_size and queue_size must be positive")
+	}
+	if c.BatchWait <= 0 {
+		return fmt.Errorf("batch_wait must be positive")
+	}
+	if c.BlockTimeout < 0 {
+		return fmt.Errorf("block_timeout must not be negative")
+	}
+	return nil
+}
+
+// validateLogShippingConfig validates the enabled log shippers
+func validateLogShippingConfig(c LogConfigs) error {
+	if c.Loki.Enable {
+		if err := validateLogShipperConfig(c.Loki.LogShipperConfigs); err != nil {
+			return fmt.Errorf("loki: %w", err)
+		}
+		if _, err := parseLokiLabels(c.Loki.Labels); err != nil {
+			return err
+		}
+		if c.Loki.URL == "" {
+			return fmt.Errorf("loki: url required")
+		}
+		if c.Loki.MaxRetries < 0 || c.Loki.Timeout < 0 {
+			return fmt.Errorf("loki: max_retries and timeout must not be negative")
+		}
+	}
+
+	if c.Syslog.Enable {
+		if err := validateLogShipperConfig(c.Syslog.LogShipperConfigs); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+		switch c.Syslog.Network {
+		case "udp", "tcp", "unix":
+		default:
+			return fmt.Errorf("syslog: unknown network %q", c.Syslog.Network)
+		}
+		if c.Syslog.Address == "" {
+			return fmt.Errorf("syslog: address required")
+		}
+		if _, err := log.ParseSyslogFacility(c.Syslog.Facility); err != nil {
+			return fmt.Errorf("syslog: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func newShipperOptions(c LogShipperConfigs) log.ShipperOptions {
+	return log.ShipperOptions{
+		BatchSize:    c.BatchSize,
+		BatchWait:    c.BatchWait,
+		QueueSize:    c.QueueSize,
+		BlockTimeout: c.BlockTimeout,
+	}
+}
+
+// logShippingOptions returns the options of the enabled log shippers, the
+// config must be valid
+func logShippingOptions(c LogConfigs) log.ShippingOptions {
+	var opts log.ShippingOptions
+
+	if c.Loki.Enable {
+		labels, _ := parseLokiLabels(c.Loki.Labels)
+		opts.Loki = &log.LokiOptions{
+			ShipperOptions: newShipperOptions(c.Loki.LogShipperConfigs),
+			URL:            c.Loki.URL,
+			TenantID:       c.Loki.TenantID,
+			Labels:         labels,
+			Timeout:        c.Loki.Timeout,
+			MaxRetries:     c.Loki.MaxRetries,
+		}
+	}
+
+	if c.Syslog.Enable {
+		facility, _ := log.ParseSyslogFacility(c.Syslog.Facility)
+		opts.Syslog = &log.SyslogOptions{
+			ShipperOptions: newShipperOptions(c.Syslog.LogShipperConfigs),
+			Network:        c.Syslog.Network,
+			Address:        c.Syslog.Address,
+			Facility:       facility,
+			AppName:        c.Syslog.AppName,
+		}
+	}
+
+	return opts
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/spf13/viper"
+)
+
+var metricsRoutesLock sync.RWMutex
+
+// MetricsRoute is a path template of the HTTP metrics, e.g. /books/{id}:
+// a {name} segment matches any path segment
+type MetricsRoute struct {
+	Template string
+	Segments []string
+}
+
+// Match reports whether the path segments match the template
+func (r MetricsRoute) Match(segments []string) bool {
+	if len(segments) != len(r.Segments) {
+		return false
+	}
+
+	for i, s := range r.Segments {
+		if isRouteParam(s) {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if s != segments[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isRouteParam(segment string) bool {
+	return len(segment) > 2 && strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+func GetMetricsMaxPathLabels() int {
+	return globalConfig.Metrics.MaxPathLabels
+}
+
+// GetMetricsRoutes returns the path templates of the HTTP metrics, in the
+// order of the core config, nil when the core config has no routes
+func GetMetricsRoutes() []MetricsRoute {
+	metricsRoutesLock.RLock()
+	defer metricsRoutesLock.RUnlock()
+
+	return globalConfig.Metrics.Routes
+}
+
+// loadMetricsRoutesConfig (re)loads the path templates of the HTTP metrics
+// from the "metrics" section of the core config:
+//
+//	"metrics": {
+//	    "routes": ["/books/{id}", "/authors/{id}/books"]
+//	}
+func loadMetricsRoutesConfig() {
+	confLogger := log.LoggerLgr.WithName(log.ConstConfigManager)
+	var routes []MetricsRoute
+
+	if viper.IsSet("metrics.routes") {
+		var err error
+		if routes, err = parseMetricsRoutes(viper.Get("metrics.routes")); err != nil {
+			// the paths go to the overflow label instead of unbounded labels
+			confLogger.Error(err, "failed to parse the metrics routes")
+			routes = []MetricsRoute{}
+		}
+	}
+
+	metricsRoutesLock.Lock()
+	globalConfig.Metrics.Routes = routes
+	metricsRoutesLock.Unlock()
+
+	confLogger.V(log.DebugLevel).Info("metrics routes loaded", "routes", len(routes))
+}
+
+func parseMetricsRoutes(raw interface{}) ([]MetricsRoute, error) {
+	templates, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected type for 'metrics.routes': %T", raw)
+	}
+
+	routes := []MetricsRoute{}
+	for _, rawTemplate := range templates {
+		template, ok := rawTemplate.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected type for a metrics route: %T", rawTemplate)
+		}
+		if !strings.HasPrefix(template, "/") {
+			return nil, fmt.Errorf("metrics route %q must start with /", template)
+		}
+
+		segments := strings.Split(template, "/")[1:]
+		for _, s := range segments {
+			if strings.ContainsAny(s, "{}") && !isRouteParam(s) {
+				return nil, fmt.Errorf("invalid segment %q in metrics route %q", s, template)
+			}
+		}
+		routes = append(routes, MetricsRoute{Template: template, Segments: segments})
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,23 @@
+package config
+
+import "fmt"
+
+func GetPoolConfig() PoolConfigs {
+	return globalConfig.Pool
+}
+
+// validatePoolConfig rejects client connection pool values that would
+// disable the pool maintenance or the reuse of connections
+func validatePoolConfig(c PoolConfigs) error {
+	if c.MaxConnsPerEndpoint <= 0 {
+		return fmt.Errorf("max_conns_per_endpoint must be greater than zero")
+	}
+	if c.IdleTimeout <= 0 {
+		return fmt.Errorf("idle_timeout must be greater than zero")
+	}
+	if c.HealthCheckInterval <= 0 {
+		return fmt.Errorf("health_check_interval must be greater than zero")
+	}
+
+	return nil
+}
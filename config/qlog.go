@@ -0,0 +1,38 @@
+package config
+
+import "fmt"
+
+// qlog sampling modes
+const (
+	// QlogSampleAll keeps the qlog of every sampled connection
+	QlogSampleAll = "all"
+	// QlogSampleAnomalies only keeps the qlog of the sampled connections that
+	// failed or whose RTT exceeded the threshold
+	QlogSampleAnomalies = "anomalies"
+)
+
+func GetQlogConfig() QuicDebugConfigs {
+	return globalConfig.Quic.Debug
+}
+
+// validateQlogConfig rejects unknown sampling modes, sampling rates out of
+// the [0, 1] range and negative retention limits
+func validateQlogConfig(c QuicDebugConfigs) error {
+	switch c.QlogSampleMode {
+	case QlogSampleAll, QlogSampleAnomalies:
+	default:
+		return fmt.Errorf("unknown qlog_sample_mode %q", c.QlogSampleMode)
+	}
+
+	if c.QlogSampleRate < 0 || c.QlogSampleRate > 1 {
+		return fmt.Errorf("qlog_sample_rate must be between 0 and 1")
+	}
+	if c.QlogRttThreshold < 0 {
+		return fmt.Errorf("qlog_rtt_threshold must not be negative")
+	}
+	if c.QlogMaxFiles < 0 || c.QlogMaxBytes < 0 || c.QlogMaxAge < 0 {
+		return fmt.Errorf("qlog retention limits must not be negative")
+	}
+
+	return nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// maxStreamCount is the maximum number of streams allowed by RFC 9000
+const maxStreamCount = 1 << 60
+
+var quicVersions = map[string]quic.VersionNumber{
+	"v1":       quic.Version1,
+	"v2":       quic.Version2,
+	"draft-29": quic.VersionDraft29,
+}
+
+func GetQuicConfig() QuicConfigs {
+	return globalConfig.Quic
+}
+
+// GetQuicVersions returns the QUIC versions allowed by configuration. An
+// empty list means all the versions supported by quic-go.
+func GetQuicVersions() []quic.VersionNumber {
+	var versions []quic.VersionNumber
+	for _, v := range globalConfig.Quic.Versions {
+		versions = append(versions, quicVersions[v])
+	}
+	return versions
+}
+
+// IsEarlyDataMethod reports whether requests with method can be sent or
+// served using 0-RTT data (replayable by an attacker)
+func IsEarlyDataMethod(method string) bool {
+	for _, m := range globalConfig.Quic.EarlyDataMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// validateQuicConfig rejects QUIC transport values that quic-go would
+// either refuse when dialing/listening or silently replace
+func validateQuicConfig(c QuicConfigs) error {
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"idle_timeout", c.IdleTimeout},
+		{"handshake_timeout", c.HandshakeTimeout},
+		{"keep_alive_period", c.KeepAlivePeriod},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return fmt.Errorf("%s must not be negative", d.name)
+		}
+	}
+
+	if c.IdleTimeout > 0 && c.KeepAlivePeriod >= c.IdleTimeout {
+		return fmt.Errorf("keep_alive_period (%s) must be lower than idle_timeout (%s)", c.KeepAlivePeriod, c.IdleTimeout)
+	}
+
+	if c.MaxIncomingStreams > maxStreamCount {
+		return fmt.Errorf("max_incoming_streams must not be greater than 2^60")
+	}
+	if c.MaxIncomingUniStreams > maxStreamCount {
+		return fmt.Errorf("max_incoming_uni_streams must not be greater than 2^60")
+	}
+
+	if c.MaxStreamReceiveWindow > 0 && c.InitialStreamReceiveWindow > c.MaxStreamReceiveWindow {
+		return fmt.Errorf("initial_stream_receive_window (%d) must not be greater than max_stream_receive_window (%d)",
+			c.InitialStreamReceiveWindow, c.MaxStreamReceiveWindow)
+	}
+	if c.MaxConnectionReceiveWindow > 0 && c.InitialConnectionReceiveWindow > c.MaxConnectionReceiveWindow {
+		return fmt.Errorf("initial_connection_receive_window (%d) must not be greater than max_connection_receive_window (%d)",
+			c.InitialConnectionReceiveWindow, c.MaxConnectionReceiveWindow)
+	}
+
+	if c.Enable0RTT && !c.SessionResumption {
+		return fmt.Errorf("enable_0rtt requires session_resumption")
+	}
+	if c.SessionResumption && c.SessionCacheSize <= 0 {
+		return fmt.Errorf("session_cache_size must be greater than zero")
+	}
+	for _, m := range c.EarlyDataMethods {
+		if m != strings.ToUpper(m) || strings.TrimSpace(m) == "" {
+			return fmt.Errorf("invalid early data method %q (methods are case-sensitive)", m)
+		}
+	}
+
+	for _, v := range c.Versions {
+		if _, ok := quicVersions[v]; !ok {
+			return fmt.Errorf("unknown version %q (supported: v1, v2, draft-29)", v)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidateQuicConfig(t *testing.T) {
+	valid := func() QuicConfigs {
+		return QuicConfigs{
+			IdleTimeout:                    30 * time.Second,
+			HandshakeTimeout:               10 * time.Second,
+			KeepAlivePeriod:                15 * time.Second,
+			MaxIncomingStreams:             100,
+			MaxIncomingUniStreams:          100,
+			InitialStreamReceiveWindow:     512 << 10,
+			MaxStreamReceiveWindow:         6 << 20,
+			InitialConnectionReceiveWindow: 512 << 10,
+			MaxConnectionReceiveWindow:     15 << 20,
+			Versions:                       []string{"v1", "v2"},
+			SessionResumption:              true,
+			SessionCacheSize:               100,
+			Enable0RTT:                     true,
+			EarlyDataMethods:               []string{"GET", "HEAD"},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func(*QuicConfigs)
+		wantErr bool
+	}{
+		{"valid", func(c *QuicConfigs) {}, false},
+		{"defaults", func(c *QuicConfigs) { *c = QuicConfigs{} }, false},
+		{"negative idle timeout", func(c *QuicConfigs) { c.IdleTimeout = -time.Second }, true},
+		{"negative handshake timeout", func(c *QuicConfigs) { c.HandshakeTimeout = -time.Second }, true},
+		{"negative keep-alive", func(c *QuicConfigs) { c.KeepAlivePeriod = -time.Second }, true},
+		{"keep-alive equal to the idle timeout", func(c *QuicConfigs) { c.KeepAlivePeriod = c.IdleTimeout }, true},
+		{"keep-alive above the idle timeout", func(c *QuicConfigs) { c.KeepAlivePeriod = time.Minute }, true},
+		{"keep-alive with the default idle timeout", func(c *QuicConfigs) { c.IdleTimeout = 0; c.KeepAlivePeriod = time.Minute }, false},
+		{"no incoming streams", func(c *QuicConfigs) { c.MaxIncomingStreams = -1; c.MaxIncomingUniStreams = -1 }, false},
+		{"too many streams", func(c *QuicConfigs) { c.MaxIncomingStreams = maxStreamCount + 1 }, true},
+		{"too many uni streams", func(c *QuicConfigs) { c.MaxIncomingUniStreams = maxStreamCount + 1 }, true},
+		{"stream window above its max", func(c *QuicConfigs) { c.InitialStreamReceiveWindow = c.MaxStreamReceiveWindow + 1 }, true},
+		{"stream window without max", func(c *QuicConfigs) { c.MaxStreamReceiveWindow = 0 }, false},
+		{"connection window above its max", func(c *QuicConfigs) { c.InitialConnectionReceiveWindow = c.MaxConnectionReceiveWindow + 1 }, true},
+		{"0-RTT without resumption", func(c *QuicConfigs) { c.SessionResumption = false }, true},
+		{"resumption without 0-RTT", func(c *QuicConfigs) { c.Enable0RTT = false }, false},
+		{"empty session cache", func(c *QuicConfigs) { c.SessionCacheSize = 0 }, true},
+		{"lowercase early data method", func(c *QuicConfigs) { c.EarlyDataMethods = []string{"get"} }, true},
+		{"blank early data method", func(c *QuicConfigs) { c.EarlyDataMethods = []string{" "} }, true},
+		{"draft-29", func(c *QuicConfigs) { c.Versions = []string{"draft-29"} }, false},
+		{"unknown version", func(c *QuicConfigs) { c.Versions = []string{"v1", "v3"} }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := valid()
+			tt.change(&c)
+			err := validateQuicConfig(c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateQuicConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
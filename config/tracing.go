@@ -0,0 +1,36 @@
+package config
+
+import "fmt"
+
+// tracing exporters
+const (
+	TracingExporterOtlp = "otlp"
+	TracingExporterFile = "file"
+)
+
+func GetTracingConfig() TracingConfigs {
+	return globalConfig.Tracing
+}
+
+// validateTracingConfig rejects unknown exporters and sampling ratios out of
+// the [0, 1] range
+func validateTracingConfig(c TracingConfigs) error {
+	switch c.Exporter {
+	case TracingExporterOtlp:
+		if c.Endpoint == "" {
+			return fmt.Errorf("endpoint required by the %s exporter", c.Exporter)
+		}
+	case TracingExporterFile:
+		if c.Path == "" {
+			return fmt.Errorf("path required by the %s exporter", c.Exporter)
+		}
+	default:
+		return fmt.Errorf("unknown exporter %q", c.Exporter)
+	}
+
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample_ratio must be between 0 and 1")
+	}
+
+	return nil
+}
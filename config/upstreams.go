@@ -0,0 +1,273 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultUpstreamPriority = 1
+	defaultUpstreamWeight   = 1
+)
+
+var upstreamsLock sync.RWMutex
+
+// watcher of the hosts file, replaced when dns.hosts_path changes
+var (
+	hostsWatchLock sync.Mutex
+	hostsWatcher   *fsnotify.Watcher
+	hostsWatched   string
+)
+
+// GetUpstreams returns the static endpoints configured for name, either in
+// the "upstreams" section of the core config or in the hosts file.
+func GetUpstreams(name string) []UpstreamEndpoint {
+	upstreamsLock.RLock()
+	defer upstreamsLock.RUnlock()
+
+	return globalConfig.DNS.Upstreams[strings.ToLower(name)]
+}
+
+// loadUpstreamsConfig (re)loads the static upstreams. It is called during
+// the first load and every time viper notifies a change in the core config.
+func loadUpstreamsConfig() {
+	confLogger := log.LoggerLgr.WithName(log.ConstConfigManager)
+	upstreams := make(map[string][]UpstreamEndpoint)
+
+	// a source with errors is ignored entirely instead of loaded partially
+	if viper.IsSet("upstreams") {
+		fromConfig := make(map[string][]UpstreamEndpoint)
+		if err := parseUpstreamsConfig(viper.Get("upstreams"), fromConfig); err != nil {
+			confLogger.Error(err, "failed to parse the upstreams config")
+		} else {
+			mergeUpstreams(upstreams, fromConfig)
+		}
+	}
+
+	hostsPath := viper.GetString("dns.hosts_path")
+	watchUpstreamsFile(hostsPath)
+	if hostsPath != "" {
+		fromFile := make(map[string][]UpstreamEndpoint)
+		if err := parseUpstreamsFile(hostsPath, fromFile); err != nil {
+			confLogger.Error(err, "failed to parse the upstreams hosts file", "path", hostsPath)
+		} else {
+			mergeUpstreams(upstreams, fromFile)
+		}
+	}
+
+	upstreamsLock.Lock()
+	globalConfig.DNS.Upstreams = upstreams
+	upstreamsLock.Unlock()
+
+	confLogger.V(log.DebugLevel).Info("static upstreams loaded", "names", len(upstreams))
+}
+
+// watchUpstreamsFile reloads the static upstreams every time the hosts file
+// at path is written or replaced. The directory is watched, so the editors
+// and the ConfigMap mounts replacing the file (or its symlink) are noticed.
+func watchUpstreamsFile(path string) {
+	hostsWatchLock.Lock()
+	defer hostsWatchLock.Unlock()
+
+	if path == hostsWatched {
+		return
+	}
+	if hostsWatcher != nil {
+		hostsWatcher.Close()
+		hostsWatcher = nil
+	}
+	hostsWatched = path
+	if path == "" {
+		return
+	}
+
+	confLogger := log.LoggerLgr.WithName(log.ConstConfigManager)
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		confLogger.Error(err, "failed to watch the upstreams hosts file", "path", path)
+		return
+	}
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		confLogger.Error(err, "failed to watch the upstreams hosts file", "path", path)
+		watcher.Close()
+		return
+	}
+	hostsWatcher = watcher
+
+	go func() {
+		realPath, _ := filepath.EvalSymlinks(path)
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				currentPath, _ := filepath.EvalSymlinks(path)
+				written := filepath.Clean(e.Name) == path && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+				replaced := currentPath != "" && currentPath != realPath
+				if written || replaced {
+					realPath = currentPath
+					confLogger.V(log.DebugLevel).Info("upstreams hosts file has changed", "path", path)
+					loadUpstreamsConfig()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				confLogger.Error(err, "upstreams hosts file watcher failed", "path", path)
+			}
+		}
+	}()
+}
+
+func mergeUpstreams(dst, src map[string][]UpstreamEndpoint) {
+	for name, eps := range src {
+		dst[name] = append(dst[name], eps...)
+	}
+}
+
+// parseUpstreamsConfig parses the "upstreams" section of the core config.
+// Each endpoint is either an "address:port" string or an object with the
+// "address", "priority" and "weight" keys:
+//
+//	"upstreams": {
+//	    "bookstore": ["10.0.0.5:14001", {"address": "10.0.0.6:14001", "priority": 2}]
+//	}
+func parseUpstreamsConfig(raw interface{}, upstreams map[string][]UpstreamEndpoint) error {
+	names, ok := raw.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("unexpected type for 'upstreams': %T", raw)
+	}
+
+	for name, rawEps := range names {
+		eps, ok := rawEps.([]interface{})
+		if !ok {
+			return fmt.Errorf("unexpected type for upstream %s: %T", name, rawEps)
+		}
+
+		for _, rawEp := range eps {
+			ep := UpstreamEndpoint{
+				Priority: defaultUpstreamPriority,
+				Weight:   defaultUpstreamWeight,
+			}
+
+			switch v := rawEp.(type) {
+			case string:
+				ep.Address = v
+			case map[string]interface{}:
+				ep.Address, _ = v["address"].(string)
+				if rawPriority, ok := v["priority"]; ok {
+					p, ok := rawPriority.(float64)
+					if !ok || p != float64(int(p)) {
+						return fmt.Errorf("upstream %s endpoint %s has an invalid priority %v", name, ep.Address, rawPriority)
+					}
+					ep.Priority = int(p)
+				}
+				if rawWeight, ok := v["weight"]; ok {
+					w, ok := rawWeight.(float64)
+					if !ok || w != float64(int(w)) {
+						return fmt.Errorf("upstream %s endpoint %s has an invalid weight %v", name, ep.Address, rawWeight)
+					}
+					ep.Weight = int(w)
+				}
+			default:
+				return fmt.Errorf("unexpected type for upstream %s endpoint: %T", name, rawEp)
+			}
+
+			if ep.Address == "" {
+				return fmt.Errorf("upstream %s has an endpoint without address", name)
+			}
+			if ep.Weight <= 0 {
+				return fmt.Errorf("upstream %s endpoint %s has an invalid weight %d", name, ep.Address, ep.Weight)
+			}
+
+			key := strings.ToLower(name)
+			upstreams[key] = append(upstreams[key], ep)
+		}
+	}
+
+	return nil
+}
+
+// parseUpstreamsFile parses an /etc/hosts style file. Each line has an
+// address (optionally with port), one or more names and the optional
+// "priority=N" and "weight=N" attributes. Everything after '#' is ignored:
+//
+//	10.0.0.5:14001  bookstore bookstore.local  priority=1 weight=10
+func parseUpstreamsFile(path string, upstreams map[string][]UpstreamEndpoint) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return fmt.Errorf("%s:%d: expected an address followed by at least one name", path, lineNum)
+		}
+
+		ep := UpstreamEndpoint{
+			Address:  fields[0],
+			Priority: defaultUpstreamPriority,
+			Weight:   defaultUpstreamWeight,
+		}
+
+		var names []string
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				names = append(names, strings.ToLower(field))
+				continue
+			}
+
+			val, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return fmt.Errorf("%s:%d: invalid value for %s: %v", path, lineNum, kv[0], err)
+			}
+
+			switch kv[0] {
+			case "priority":
+				ep.Priority = val
+			case "weight":
+				if val <= 0 {
+					return fmt.Errorf("%s:%d: weight must be greater than zero", path, lineNum)
+				}
+				ep.Weight = val
+			default:
+				return fmt.Errorf("%s:%d: unknown attribute %s", path, lineNum, kv[0])
+			}
+		}
+
+		if len(names) == 0 {
+			return fmt.Errorf("%s:%d: expected at least one name", path, lineNum)
+		}
+
+		for _, name := range names {
+			upstreams[name] = append(upstreams[name], ep)
+		}
+	}
+
+	return scanner.Err()
+}
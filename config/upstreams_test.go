@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseUpstreamsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string][]UpstreamEndpoint
+		wantErr bool
+	}{
+		{"defaults", "10.0.0.5:14001 bookstore\n", map[string][]UpstreamEndpoint{
+			"bookstore": {{Address: "10.0.0.5:14001", Priority: 1, Weight: 1}},
+		}, false},
+		{"attributes and names", "10.0.0.5 Bookstore bookstore.local priority=2 weight=10\n", map[string][]UpstreamEndpoint{
+			"bookstore":       {{Address: "10.0.0.5", Priority: 2, Weight: 10}},
+			"bookstore.local": {{Address: "10.0.0.5", Priority: 2, Weight: 10}},
+		}, false},
+		{"comments and blank lines", "# static upstreams\n\n   \n10.0.0.5:14001 bookstore # weight=10\n#10.0.0.6:14001 bookstore\n", map[string][]UpstreamEndpoint{
+			"bookstore": {{Address: "10.0.0.5:14001", Priority: 1, Weight: 1}},
+		}, false},
+		{"duplicate hosts", "10.0.0.5:14001 bookstore weight=10\n10.0.0.6:14001 BOOKSTORE priority=2\n", map[string][]UpstreamEndpoint{
+			"bookstore": {
+				{Address: "10.0.0.5:14001", Priority: 1, Weight: 10},
+				{Address: "10.0.0.6:14001", Priority: 2, Weight: 1},
+			},
+		}, false},
+		{"empty file", "", map[string][]UpstreamEndpoint{}, false},
+		{"no name", "10.0.0.5:14001\n", nil, true},
+		{"only attributes", "10.0.0.5:14001 priority=1\n", nil, true},
+		{"bad priority", "10.0.0.5:14001 bookstore priority=high\n", nil, true},
+		{"bad weight", "10.0.0.5:14001 bookstore weight=1.5\n", nil, true},
+		{"zero weight", "10.0.0.5:14001 bookstore weight=0\n", nil, true},
+		{"negative weight", "10.0.0.5:14001 bookstore weight=-1\n", nil, true},
+		{"unknown attribute", "10.0.0.5:14001 bookstore zone=a\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "hosts")
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string][]UpstreamEndpoint)
+			err := parseUpstreamsFile(path, got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUpstreamsFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUpstreamsFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseUpstreamsConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		want    map[string][]UpstreamEndpoint
+		wantErr bool
+	}{
+		{"address", `{"bookstore": ["10.0.0.5:14001"]}`, map[string][]UpstreamEndpoint{
+			"bookstore": {{Address: "10.0.0.5:14001", Priority: 1, Weight: 1}},
+		}, false},
+		{"object", `{"Bookstore": [{"address": "10.0.0.6:14001", "priority": 2, "weight": 10}]}`, map[string][]UpstreamEndpoint{
+			"bookstore": {{Address: "10.0.0.6:14001", Priority: 2, Weight: 10}},
+		}, false},
+		{"mixed", `{"bookstore": ["10.0.0.5:14001", {"address": "10.0.0.6:14001", "priority": 2}]}`, map[string][]UpstreamEndpoint{
+			"bookstore": {
+				{Address: "10.0.0.5:14001", Priority: 1, Weight: 1},
+				{Address: "10.0.0.6:14001", Priority: 2, Weight: 1},
+			},
+		}, false},
+		{"duplicate hosts", `{"bookstore": ["10.0.0.5:14001"], "BOOKSTORE": ["10.0.0.5:14001"]}`, map[string][]UpstreamEndpoint{
+			"bookstore": {
+				{Address: "10.0.0.5:14001", Priority: 1, Weight: 1},
+				{Address: "10.0.0.5:14001", Priority: 1, Weight: 1},
+			},
+		}, false},
+		{"not an object", `["10.0.0.5:14001"]`, nil, true},
+		{"endpoints not a list", `{"bookstore": "10.0.0.5:14001"}`, nil, true},
+		{"endpoint not an address", `{"bookstore": [14001]}`, nil, true},
+		{"no address", `{"bookstore": [{"priority": 2}]}`, nil, true},
+		{"bad priority", `{"bookstore": [{"address": "10.0.0.5:14001", "priority": "high"}]}`, nil, true},
+		{"fractional priority", `{"bookstore": [{"address": "10.0.0.5:14001", "priority": 1.5}]}`, nil, true},
+		{"bad weight", `{"bookstore": [{"address": "10.0.0.5:14001", "weight": "10"}]}`, nil, true},
+		{"zero weight", `{"bookstore": [{"address": "10.0.0.5:14001", "weight": 0}]}`, nil, true},
+		{"negative weight", `{"bookstore": [{"address": "10.0.0.5:14001", "weight": -1}]}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw interface{}
+			if err := json.Unmarshal([]byte(tt.raw), &raw); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[string][]UpstreamEndpoint)
+			err := parseUpstreamsConfig(raw, got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseUpstreamsConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseUpstreamsConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
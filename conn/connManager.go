@@ -1,46 +1,59 @@
 package conn
 
 import (
-	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/quicsec/quicsec/auth"
 	"github.com/quicsec/quicsec/config"
 	"github.com/quicsec/quicsec/identity"
 	"github.com/quicsec/quicsec/operations/httplog"
 	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/operations/tracing"
 
 	ops "github.com/quicsec/quicsec/operations"
 )
 
-var roundTripper *http3.RoundTripper
-var once sync.Once
-
-func getRoundTripper() *http3.RoundTripper {
-	once.Do(func() {
-		roundTripper = &http3.RoundTripper{}
-	})
-
-	return roundTripper
+// defaultClientMaxIdleTimeout is the client idle timeout used when
+// quic.idle_timeout is not configured
+const defaultClientMaxIdleTimeout = 500 * time.Millisecond
+
+// newQuicConfig builds the quic.Config from the QUIC transport configuration
+func newQuicConfig(tracer logging.Tracer) *quic.Config {
+	c := config.GetQuicConfig()
+
+	return &quic.Config{
+		Tracer:                         tracer,
+		Versions:                       config.GetQuicVersions(),
+		MaxIdleTimeout:                 c.IdleTimeout,
+		HandshakeIdleTimeout:           c.HandshakeTimeout,
+		KeepAlivePeriod:                c.KeepAlivePeriod,
+		MaxIncomingStreams:             c.MaxIncomingStreams,
+		MaxIncomingUniStreams:          c.MaxIncomingUniStreams,
+		InitialStreamReceiveWindow:     c.InitialStreamReceiveWindow,
+		MaxStreamReceiveWindow:         c.MaxStreamReceiveWindow,
+		InitialConnectionReceiveWindow: c.InitialConnectionReceiveWindow,
+		MaxConnectionReceiveWindow:     c.MaxConnectionReceiveWindow,
+		EnableDatagrams:                c.EnableDatagrams,
+		DisablePathMTUDiscovery:        c.DisablePathMTUDiscovery,
+	}
 }
 
-func ListenAndServe(addr string, handler http.Handler) error {
-	// Load certs
-	var err error
-
-	// init logger, preshared dump and tracers (metrics and qlog)
-	keyLog, opsTracer := ops.OperationsInit()
+// newServerTLSConfig builds the TLS config of the servers: SPIFFE
+// authentication and authorization of the clients, with the identity
+// certificate loaded on every handshake
+func newServerTLSConfig(keyLog io.Writer) *tls.Config {
 	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
-	config.SetServerSideFlag(true)
-	connLogger.Info("ListenAndServe() initialization")
 
 	tlsConfig := &tls.Config{
 		KeyLogWriter:       keyLog,
@@ -55,6 +68,113 @@ func ListenAndServe(addr string, handler http.Handler) error {
 
 	tlsConfig.ClientAuth = tls.RequestClientCert
 	tlsConfig.VerifyPeerCertificate = auth.WrapVerifyPeerCertificate(auth.CustomVerifyPeerCertificate)
+	tlsConfig.VerifyConnection = auth.WrapVerifyConnection(tlsConfig.VerifyPeerCertificate)
+	tlsConfig.SessionTicketsDisabled = !config.GetQuicConfig().SessionResumption
+
+	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+		cert, err := identity.GetCert()
+
+		if err != nil {
+			//operations.ProbeError(operations.ConstIdentityManager, err)
+			return nil, err
+		}
+
+		return cert, nil
+	}
+	serverKeyLogPerPeer(tlsConfig)
+
+	return tlsConfig
+}
+
+// newClientTLSConfig builds the TLS config of the clients for the
+// application protocol nextProto: the identity certificate and the SPIFFE
+// authentication and authorization of the server
+func newClientTLSConfig(keyLog io.Writer, nextProto string) (*tls.Config, error) {
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	identityLogger := log.LoggerLgr.WithName(log.ConstIdentityManager)
+
+	idCert, err := identity.GetCert()
+
+	if err != nil {
+		identityLogger.Error(err, "failed to fetch identity for client")
+		return nil, err
+	}
+
+	identityLogger.V(log.DebugLevel).Info("identity successfully obtained for the client")
+
+	certs := make([]tls.Certificate, 1)
+	certs[0] = *idCert
+
+	tlsConfig := &tls.Config{
+		Certificates:       certs,
+		InsecureSkipVerify: config.GetInsecureSkipVerify(),
+		KeyLogWriter:       keyLog,
+		NextProtos:         []string{nextProto},
+	}
+
+	if config.GetMtlsEnable() {
+		connLogger.V(log.DebugLevel).Info("mTLS enabled by configuration during start")
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = auth.WrapVerifyPeerCertificate(auth.CustomVerifyPeerCertificate)
+	} else {
+		connLogger.V(log.DebugLevel).Info("mTLS disabled by configuration during start")
+		if !config.GetInsecureSkipVerify() {
+			// even if mTLS is disable, we need to validate if the
+			// cert from the server cert is valid agains the CA pool
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyPeerCertificate = auth.WrapVerifyPeerCertificate(nil)
+		}
+	}
+
+	if config.GetQuicConfig().SessionResumption {
+		tlsConfig.ClientSessionCache = getClientSessionCache()
+		tlsConfig.VerifyConnection = auth.WrapVerifyConnection(tlsConfig.VerifyPeerCertificate)
+	}
+
+	return tlsConfig, nil
+}
+
+// newTCPServerTLSConfig builds the TLS config of the TCP listener from the
+// TLS config of the QUIC listener: HTTP/2 and HTTP/1.1, the clients have to
+// present an authorized SPIFFE certificate when http.tcp.require_mtls is set
+func newTCPServerTLSConfig(tlsConfig *tls.Config) *tls.Config {
+	tcpConfig := tlsConfig.Clone()
+	tcpConfig.NextProtos = []string{"h2", "http/1.1"}
+
+	if config.GetTcpConfig().RequireMtls {
+		log.LoggerLgr.WithName(log.ConstConnManager).V(log.DebugLevel).Info("mTLS required on the TCP listener by configuration during start")
+		tcpConfig.ClientAuth = tls.RequireAnyClientCert
+		tcpConfig.VerifyPeerCertificate = auth.RequireAuthorizedPeerCertificate
+		tcpConfig.VerifyConnection = auth.WrapVerifyConnection(tcpConfig.VerifyPeerCertificate)
+	}
+	serverKeyLogPerPeer(tcpConfig)
+
+	return tcpConfig
+}
+
+// serverMiddleware wraps the handlers of the QUIC and TCP listeners with the
+// access logs and the HTTP metrics
+func serverMiddleware(handler http.Handler) http.Handler {
+	return httplog.WrapHandlerWithLogging(handler)
+}
+
+func ListenAndServe(addr string, handler http.Handler) error {
+	// Load certs
+	var err error
+
+	// init logger, preshared dump and tracers (metrics and qlog)
+	keyLog, opsTracer := ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(true)
+	connLogger.Info("ListenAndServe() initialization")
+
+	// the workload is not ready (/readyz) until the listeners are serving
+	readinessCheck := "listener " + addr
+	ops.SetReadinessCheck(readinessCheck, func() error { return errListenerNotReady })
+	defer ops.SetReadinessCheck(readinessCheck, func() error { return errListenerClosed })
+
+	tlsConfig := newServerTLSConfig(keyLog)
 
 	connLogger.V(log.DebugLevel).Info("try to bind address for tcp/udp", "addr", addr)
 
@@ -71,66 +191,93 @@ func ListenAndServe(addr string, handler http.Handler) error {
 	}
 	defer udpConn.Close()
 
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
-	if err != nil {
-		connLogger.Error(err, "failed to resolve the address of TCP end point")
-		return err
-	}
-	tcpConn, err := net.ListenTCP("tcp", tcpAddr)
-	if err != nil {
-		connLogger.Error(err, "failed to Listen at TCP address")
-		return err
-	}
-	defer tcpConn.Close()
+	var tlsConn net.Listener
+	if config.GetTcpConfig().Enable {
+		tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+		if err != nil {
+			connLogger.Error(err, "failed to resolve the address of TCP end point")
+			return err
+		}
+		tcpConn, err := net.ListenTCP("tcp", tcpAddr)
+		if err != nil {
+			connLogger.Error(err, "failed to Listen at TCP address")
+			return err
+		}
+		defer tcpConn.Close()
 
-	tlsConn := tls.NewListener(tcpConn, tlsConfig)
-	defer tlsConn.Close()
+		tlsConn = tls.NewListener(tcpConn, newTCPServerTLSConfig(tlsConfig))
+		defer tlsConn.Close()
+	} else {
+		connLogger.V(log.DebugLevel).Info("TCP listener disabled by configuration during start")
+	}
 
 	if handler == nil {
 		handler = http.DefaultServeMux
 	}
 
-	quicConf := &quic.Config{
-		Tracer: opsTracer,
+	quicConf := newQuicConfig(opsTracer)
+	if config.GetQuicConfig().Enable0RTT {
+		connLogger.V(log.DebugLevel).Info("0-RTT enabled by configuration during start")
+		quicConf.Allow0RTT = func(net.Addr) bool { return true }
 	}
 
-	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
-
-		cert, err := identity.GetCert()
-
-		if err != nil {
-			//operations.ProbeError(operations.ConstIdentityManager, err)
-			return nil, err
-		}
-
-		return cert, nil
+	// the WebTransport server wraps the http3 server
+	wtServer := newWebTransportServer()
+	quicServer := new(http3.Server)
+	if wtServer != nil {
+		connLogger.V(log.DebugLevel).Info("WebTransport enabled by configuration during start")
+		quicServer = &wtServer.H3
+		quicConf.EnableDatagrams = true
 	}
 
 	// Start the servers
-	quicServer := &http3.Server{
-		TLSConfig:  tlsConfig,
-		Handler:    httplog.WrapHandlerWithLogging(handler),
-		QuicConfig: quicConf,
+	*quicServer = http3.Server{
+		TLSConfig:          tlsConfig,
+		Handler:            serverMiddleware(earlyDataHandler(connectHandler(webTransportHandler(wtServer, handler)))),
+		QuicConfig:         quicConf,
+		EnableDatagrams:    quicConf.EnableDatagrams,
+		AdditionalSettings: webTransportSettings(wtServer, connectSettings()),
 	}
 
+	// the TCP listener shares the middlewares of the QUIC listener, the
+	// clients are told to switch to HTTP/3 by the Alt-Svc header
 	httpServer := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handler: serverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			quicServer.SetQuicHeaders(w.Header())
 			handler.ServeHTTP(w, r)
-		}),
+		})),
+	}
+
+	quicListener, err := quic.ListenEarly(udpConn, http3.ConfigureTLSConfig(tlsConfig), quicConf)
+	if err != nil {
+		connLogger.Error(err, "failed to Listen for QUIC connections")
+		return err
 	}
 
+	ops.SetReadinessCheck(readinessCheck, func() error { return nil })
+
 	hErr := make(chan error)
 	qErr := make(chan error)
+	if tlsConn != nil {
+		go func() {
+			hErr <- httpServer.Serve(tlsConn)
+		}()
+	}
 	go func() {
-		hErr <- httpServer.Serve(tlsConn)
-	}()
-	go func() {
-		qErr <- quicServer.Serve(udpConn)
+		if wtServer != nil {
+			qErr <- serveWebTransport(wtServer, earlyDataListener{EarlyListener: quicListener})
+			return
+		}
+		qErr <- quicServer.ServeListener(earlyDataListener{EarlyListener: quicListener})
 	}()
 
 	select {
 	case err := <-hErr:
+		if wtServer != nil {
+			quicListener.Close()
+			wtServer.Close()
+			return err
+		}
 		quicServer.Close()
 		return err
 	case err := <-qErr:
@@ -140,9 +287,25 @@ func ListenAndServe(addr string, handler http.Handler) error {
 }
 
 func Do(req *http.Request) (*http.Response, error) {
+	return roundTrip(req, false)
+}
+
+// RoundTripper sends the requests like Do (name resolution, mTLS, connection
+// pool, access logs and metrics) as an http.RoundTripper: the context of the
+// request is kept, so the upstream request is canceled with it, and the
+// redirects are returned instead of followed (e.g. by a reverse proxy).
+type RoundTripper struct{}
+
+func (RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return roundTrip(req, true)
+}
+
+// roundTrip sends req to the endpoints of its host until one of them
+// answers. Without keepContext, req is sent by an http.Client (redirects
+// followed) with a context detached from the one of req.
+func roundTrip(req *http.Request, keepContext bool) (*http.Response, error) {
 	start := time.Now()
 	var err error
-	var client *http.Client
 
 	// init logger, preshared dump and tracers (metrics and qlog)
 	keyLog, opsTracer := ops.OperationsInit()
@@ -153,52 +316,28 @@ func Do(req *http.Request) (*http.Response, error) {
 
 	connLogger.Info("client.Do() initialization")
 
-	idCert, err := identity.GetCert()
-
+	tlsConfig, err := newClientTLSConfig(keyLog, http3.NextProtoH3)
 	if err != nil {
-		identityLogger.Error(err, "failed to fetch identity for client")
 		return nil, err
 	}
 
-	identityLogger.V(log.DebugLevel).Info("identity successfully obtained for the client")
-
-	certs := make([]tls.Certificate, 1)
-	certs[0] = *idCert
-
-	tlsConfig := &tls.Config{
-		Certificates:       certs,
-		InsecureSkipVerify: config.GetInsecureSkipVerify(),
-		KeyLogWriter:       keyLog,
-		NextProtos:         []string{http3.NextProtoH3},
+	quicConf := newQuicConfig(opsTracer)
+	if quicConf.MaxIdleTimeout == 0 {
+		quicConf.MaxIdleTimeout = defaultClientMaxIdleTimeout
 	}
 
-	if config.GetMtlsEnable() {
-		connLogger.V(log.DebugLevel).Info("mTLS enabled by configuration during start")
-		tlsConfig.InsecureSkipVerify = true
-		tlsConfig.VerifyPeerCertificate = auth.WrapVerifyPeerCertificate(auth.CustomVerifyPeerCertificate)
-	} else {
-		connLogger.V(log.DebugLevel).Info("mTLS disabled by configuration during start")
-		if !config.GetInsecureSkipVerify() {
-			// even if mTLS is disable, we need to validate if the
-			// cert from the server cert is valid agains the CA pool
-			tlsConfig.InsecureSkipVerify = true
-			tlsConfig.VerifyPeerCertificate = auth.WrapVerifyPeerCertificate(nil)
-		}
-	}
-
-	quicConf := &quic.Config{
-		Tracer:         opsTracer,
-		MaxIdleTimeout: 500 * time.Millisecond,
-	}
-
-	getRoundTripper().TLSClientConfig = tlsConfig
-	getRoundTripper().QuicConfig = quicConf
-
 	elapsed := time.Since(start).Seconds()
 	connLogger.Info("Connection setup time for requesting", "setup_time", elapsed)
 
 	start = time.Now()
-	epAddrs, err := GetAllEpAddresses(req.URL.Hostname())
+	ctx := tracing.RequestContext(req)
+	_, dnsSpan := tracing.Start(ctx, "dns.lookup", trace.SpanKindInternal, attribute.String("quicsec.dns.host", req.URL.Hostname()))
+	epAddrs, static := GetStaticEpAddresses(req.URL.Hostname(), req.URL.Port())
+	if static {
+		connLogger.V(log.DebugLevel).Info("static upstream found, skipping DNS lookup", "host", req.URL.Hostname())
+	} else {
+		epAddrs, err = GetAllEpAddresses(req.URL.Hostname())
+	}
 	elapsed = time.Since(start).Seconds()
 	connLogger.Info("DNS lookup time for requesting", "dns_lookup_time", elapsed)
 
@@ -208,37 +347,46 @@ func Do(req *http.Request) (*http.Response, error) {
 		hostAddr, err := GetEpAddress(req.URL.Hostname())
 
 		if err != nil {
+			tracing.End(dnsSpan, err)
 			return nil, fmt.Errorf("DNS resolution failed")
 		}
 
 		epAddrs = append(epAddrs, hostAddr+":"+req.URL.Port())
 	}
+	dnsSpan.SetAttributes(attribute.Bool("quicsec.dns.static", static), attribute.StringSlice("quicsec.dns.endpoints", epAddrs))
+	tracing.End(dnsSpan, nil)
 
 	var resp *http.Response
 
 	for _, ep := range epAddrs {
 		start = time.Now()
 
-		getRoundTripper().Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
-			conn, err := quic.DialAddrEarlyContext(context.Background(), ep, tlsConfig, quicConf)
-
-			if err != nil {
-				return nil, err
-			}
-			// return the QUIC connection
-			return conn, nil
+		var transport http.RoundTripper = poolRoundTripper{
+			endpoint: ep,
+			tlsConf:  tlsConfig,
+			quicConf: quicConf,
 		}
-		client = &http.Client{
-			Transport: httplog.LoggingRoundTripper{Base: getRoundTripper()},
+		if config.GetQuicConfig().Enable0RTT {
+			transport = earlyDataRoundTripper{Base: transport}
 		}
+		transport = httplog.LoggingRoundTripper{Base: transport, Transport: httplog.TransportH3}
 
 		identityLogger.V(log.DebugLevel).Info("send client request")
-		resp, err = client.Do(req.WithContext(context.Background()))
+		if keepContext {
+			resp, err = transport.RoundTrip(req.WithContext(ctx))
+		} else {
+			client := &http.Client{Transport: transport}
+			resp, err = client.Do(req.WithContext(tracing.Detach(ctx)))
+		}
 
 		if err != nil {
 			elapsed = time.Since(start).Seconds()
 			connLogger.Info("Trying address failed", "address", ep, "failed_req_time", elapsed)
 
+			// the request was canceled, the other endpoints are not tried
+			if keepContext && ctx.Err() != nil {
+				return nil, err
+			}
 			continue
 		}
 		elapsed = time.Since(start).Seconds()
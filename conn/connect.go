@@ -0,0 +1,337 @@
+package conn
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations/log"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+var errFlushNotSupported = errors.New("conn: response writer doesn't support flushing")
+
+const (
+	// HTTP/3 settings advertised when the CONNECT proxy is enabled
+	settingEnableConnectProtocol = 0x8  // RFC 9220
+	settingH3Datagram            = 0x33 // RFC 9297
+
+	protocolConnectUDP = "connect-udp"
+
+	// RFC 9298 default URI template: /.well-known/masque/udp/{target_host}/{target_port}/
+	connectUDPPathPrefix = "/.well-known/masque/udp/"
+
+	// max UDP payload proxied by CONNECT-UDP
+	connectUDPBufferSize = 1500
+
+	connectDialTimeout = 10 * time.Second
+)
+
+// connectSettings returns the HTTP/3 settings required by the CONNECT proxy
+func connectSettings() map[uint64]uint64 {
+	if !config.GetConnectEnable() {
+		return nil
+	}
+
+	return map[uint64]uint64{
+		settingEnableConnectProtocol: 1,
+		settingH3Datagram:            1,
+	}
+}
+
+// connectHandler makes the server an identity-aware forward proxy: it
+// serves CONNECT (TCP targets) and CONNECT-UDP (RFC 9298) requests to the
+// targets allowed for the peer SPIFFE ID in the "connect" section of the core
+// config. The other requests are served by handler.
+func connectHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || !config.GetConnectEnable() {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+		peerID := peerIDFromRequest(r)
+
+		protocol := "tcp"
+		target := r.Host
+		switch r.Proto {
+		case protocolConnectUDP:
+			protocol = "udp"
+			var ok bool
+			if target, ok = connectUDPTarget(r.URL); !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		case "":
+		default:
+			// other extended CONNECT protocols (e.g. websocket, webtransport)
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if !connectAllowed(peerID, target) {
+			connLogger.Info("connect target not allowed", "peer_id", peerID, "protocol", protocol, "target", target)
+			observeConnect(peerID, protocol, "forbidden", 0, 0)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		start := time.Now()
+		var sent, rcvd int64
+		var err error
+		if protocol == "udp" {
+			sent, rcvd, err = serveConnectUDP(w, r, target)
+		} else {
+			sent, rcvd, err = serveConnectTCP(w, r, target)
+		}
+
+		if err != nil {
+			connLogger.Error(err, "connect tunnel failed", "peer_id", peerID, "protocol", protocol, "target", target)
+			observeConnect(peerID, protocol, "failure", 0, 0)
+			return
+		}
+
+		observeConnect(peerID, protocol, "success", sent, rcvd)
+		connLogger.Info("connect tunnel closed", "peer_id", peerID, "protocol", protocol, "target", target,
+			"sent", sent, "rcvd", rcvd, "duration", time.Since(start).Seconds())
+	})
+}
+
+// serveConnectTCP tunnels the request stream (DATA frames) to a TCP
+// connection to target. It returns the bytes sent to and received from the
+// target.
+func serveConnectTCP(w http.ResponseWriter, r *http.Request, target string) (int64, int64, error) {
+	tcpConn, err := net.DialTimeout("tcp", target, connectDialTimeout)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return 0, 0, err
+	}
+	defer tcpConn.Close()
+
+	flusher, ok := rawResponseWriter(w).(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return 0, 0, errFlushNotSupported
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var sent int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sent, _ = io.Copy(tcpConn, r.Body)
+		tcpConn.(*net.TCPConn).CloseWrite()
+	}()
+
+	// the response (and the stream) ends when the target closes the connection
+	rcvd, _ := io.Copy(flushWriter{w: w, flusher: flusher}, tcpConn)
+	r.Body.Close()
+	<-done
+
+	return sent, rcvd, nil
+}
+
+// serveConnectUDP proxies the UDP payloads carried by the HTTP Datagrams of
+// the request stream (context ID 0) to target. The tunnel is closed when the
+// client closes the request stream.
+func serveConnectUDP(w http.ResponseWriter, r *http.Request, target string) (int64, int64, error) {
+	raw := rawResponseWriter(w)
+	flusher, ok := raw.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return 0, 0, errFlushNotSupported
+	}
+	hijacker, ok := raw.(http3.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return 0, 0, errDatagramsNotSupported
+	}
+	dconn, ok := hijacker.StreamCreator().(datagramConn)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return 0, 0, errDatagramsNotSupported
+	}
+	demux, err := getDatagramDemux(dconn)
+	if err != nil {
+		w.WriteHeader(http.StatusNotImplemented)
+		return 0, 0, err
+	}
+
+	udpConn, err := net.DialTimeout("udp", target, connectDialTimeout)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return 0, 0, err
+	}
+	defer udpConn.Close()
+
+	// the stream is closed by us once hijacked
+	str := r.Body.(http3.HTTPStreamer).HTTPStream()
+	defer str.Close()
+
+	datagrams := demux.register(str.StreamID())
+	defer demux.unregister(str.StreamID())
+
+	w.Header().Set("Capsule-Protocol", "?1")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var sent, rcvd int64
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for payload := range datagrams {
+			rd := bytes.NewReader(payload)
+			contextID, err := quicvarint.Read(rd)
+			if err != nil || contextID != 0 {
+				// unknown contexts are dropped
+				continue
+			}
+			n, _ := udpConn.Write(payload[len(payload)-rd.Len():])
+			sent += int64(n)
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, connectUDPBufferSize)
+		for {
+			n, err := udpConn.Read(buf)
+			if err != nil {
+				return
+			}
+			payload := quicvarint.Append(make([]byte, 0, n+1), 0)
+			if demux.send(str.StreamID(), append(payload, buf[:n]...)) == nil {
+				rcvd += int64(n)
+			}
+		}
+	}()
+
+	// capsules are ignored, the stream is read until the client closes it
+	io.Copy(io.Discard, r.Body)
+
+	demux.unregister(str.StreamID())
+	udpConn.Close()
+	wg.Wait()
+
+	return sent, rcvd, nil
+}
+
+// connectUDPTarget parses the target of the RFC 9298 default URI template
+func connectUDPTarget(u *url.URL) (string, bool) {
+	path := u.EscapedPath()
+	if !strings.HasPrefix(path, connectUDPPathPrefix) {
+		return "", false
+	}
+
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, connectUDPPathPrefix), "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+
+	// IPv6 addresses have the colons percent-encoded
+	host, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", false
+	}
+
+	return net.JoinHostPort(host, parts[1]), true
+}
+
+// connectAllowed checks target against the allow list of peerID. CIDRs only
+// match IP targets, names are matched as they are (not resolved).
+func connectAllowed(peerID, target string) bool {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+
+	for _, t := range config.GetConnectAllowList(peerID) {
+		if !t.MatchPort(port) {
+			continue
+		}
+
+		switch {
+		case t.Host == "*" || t.Host == host:
+			return true
+		case strings.HasPrefix(t.Host, "*."):
+			if strings.HasSuffix(host, t.Host[1:]) {
+				return true
+			}
+		case strings.Contains(t.Host, "/"):
+			if _, cidr, err := net.ParseCIDR(t.Host); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func peerIDFromRequest(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	id, err := identity.IDFromCert(r.TLS.PeerCertificates[0])
+	if err != nil {
+		return ""
+	}
+
+	return id.String()
+}
+
+func observeConnect(peerID, protocol, status string, sent, rcvd int64) {
+	if !config.GetMetricsEnabled() {
+		return
+	}
+
+	ops.ConnectTunnels.WithLabelValues(peerID, protocol, status).Inc()
+	if status == "success" {
+		ops.ConnectBytes.WithLabelValues(peerID, protocol, "sent").Add(float64(sent))
+		ops.ConnectBytes.WithLabelValues(peerID, protocol, "rcvd").Add(float64(rcvd))
+	}
+}
+
+// rawResponseWriter returns the response writer of the server under the
+// wrappers (e.g. access logs), to use its optional interfaces
+func rawResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return w
+		}
+		w = u.Unwrap()
+	}
+}
+
+// flushWriter flushes every write, so the tunneled data isn't delayed by the
+// response buffering
+type flushWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	f.flusher.Flush()
+	return n, err
+}
@@ -0,0 +1,133 @@
+package conn
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/quicsec/quicsec/internal/testenv"
+)
+
+func TestConnectAllowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		peerID string
+		target string
+		want   bool
+	}{
+		{"exact host and port", testenv.WorkloadID, "api.example.org:443", true},
+		{"exact host case", testenv.WorkloadID, "API.Example.org:443", true},
+		{"exact host other port", testenv.WorkloadID, "api.example.org:80", false},
+		{"other host", testenv.WorkloadID, "www.example.org:443", false},
+		{"wildcard subdomain", testenv.WorkloadID, "a.svc.test:443", true},
+		{"wildcard nested subdomain", testenv.WorkloadID, "a.b.svc.test:443", true},
+		{"wildcard domain itself", testenv.WorkloadID, "svc.test:443", false},
+		{"wildcard suffix without dot", testenv.WorkloadID, "evilsvc.test:443", false},
+		{"cidr", testenv.WorkloadID, "10.1.2.3:53", true},
+		{"outside the cidr", testenv.WorkloadID, "11.1.2.3:53", false},
+		{"name not matched by the cidr", testenv.WorkloadID, "dns.example.org:53", false},
+		{"port range start", testenv.WorkloadID, "db.example.org:5432", true},
+		{"port range end", testenv.WorkloadID, "db.example.org:5439", true},
+		{"below the port range", testenv.WorkloadID, "db.example.org:5431", false},
+		{"above the port range", testenv.WorkloadID, "db.example.org:5440", false},
+		{"any port", testenv.WorkloadID, "127.0.0.1:8080", true},
+		{"identity without rules", testenv.IntruderID, "api.example.org:443", false},
+		{"no identity", "", "api.example.org:443", false},
+		{"no port", testenv.WorkloadID, "api.example.org", false},
+		{"empty authority", testenv.WorkloadID, "", false},
+		{"unterminated ipv6", testenv.WorkloadID, "[::1:443", false},
+		{"too many colons", testenv.WorkloadID, "127.0.0.1:80:80", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectAllowed(tt.peerID, tt.target); got != tt.want {
+				t.Errorf("connectAllowed(%q, %q) = %t, want %t", tt.peerID, tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectHandler(t *testing.T) {
+	workloadCert := peerCert(t, testenv.WorkloadID)
+
+	// the target sends a greeting and closes the connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "hello")
+			c.Close()
+		}
+	}()
+
+	tests := []struct {
+		name       string
+		target     string
+		peer       *x509.Certificate
+		wantStatus int
+		wantBody   string
+	}{
+		{"allowed target", ln.Addr().String(), workloadCert, http.StatusOK, "hello"},
+		{"denied target", "www.example.org:443", workloadCert, http.StatusForbidden, ""},
+		{"identity without rules", ln.Addr().String(), peerCert(t, testenv.IntruderID), http.StatusForbidden, ""},
+		{"no peer certificate", ln.Addr().String(), nil, http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := false
+			handler := connectHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			}))
+
+			r := httptest.NewRequest(http.MethodConnect, "https://"+tt.target, strings.NewReader(""))
+			r.Host = tt.target
+			// http3 sets Proto to the :protocol of the extended CONNECT
+			r.Proto = ""
+			r.TLS = &tls.ConnectionState{}
+			if tt.peer != nil {
+				r.TLS.PeerCertificates = []*x509.Certificate{tt.peer}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Body.String(); got != tt.wantBody {
+				t.Errorf("body %q, want %q", got, tt.wantBody)
+			}
+			if served {
+				t.Error("CONNECT request passed to the next handler")
+			}
+		})
+	}
+}
+
+// peerCert returns a certificate of the SPIFFE ID id
+func peerCert(t *testing.T, id string) *x509.Certificate {
+	t.Helper()
+
+	cert, err := testEnv.NewCert(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf
+}
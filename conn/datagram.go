@@ -0,0 +1,402 @@
+package conn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+	"golang.org/x/time/rate"
+
+	"github.com/quicsec/quicsec/config"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+// datagramQueueLen is the number of datagrams queued by request stream,
+// the datagrams received when the queue is full are dropped (unreliable
+// delivery anyway)
+const datagramQueueLen = 128
+
+var errDatagramsNotSupported = errors.New("conn: datagrams not supported by the connection")
+
+// ErrDatagramRateLimited is returned when sending a datagram would exceed
+// the datagram rate limit of the peer identity
+var ErrDatagramRateLimited = errors.New("conn: datagram rate limit exceeded")
+
+var errDatagramsClosed = errors.New("conn: datagrams closed")
+
+// datagramConn is the part of quic.Connection used to send and receive
+// datagrams
+type datagramConn interface {
+	Context() context.Context
+	ConnectionState() quic.ConnectionState
+	SendMessage([]byte) error
+	ReceiveMessage() ([]byte, error)
+}
+
+// datagramDemux dispatches the HTTP Datagrams (RFC 9297) received on a QUIC
+// connection to the request streams they belong to. The QUIC datagrams start
+// with the quarter stream ID of the request stream.
+type datagramDemux struct {
+	conn   datagramConn
+	peerID string
+
+	mutex   sync.Mutex
+	streams map[quic.StreamID]chan []byte
+}
+
+var demuxes = struct {
+	sync.Mutex
+	m map[datagramConn]*datagramDemux
+}{m: make(map[datagramConn]*datagramDemux)}
+
+// getDatagramDemux returns the demultiplexer of conn, there is only one per
+// connection since every call to ReceiveMessage consumes a datagram
+func getDatagramDemux(conn datagramConn) (*datagramDemux, error) {
+	if !conn.ConnectionState().SupportsDatagrams {
+		return nil, errDatagramsNotSupported
+	}
+
+	demuxes.Lock()
+	defer demuxes.Unlock()
+
+	if d, ok := demuxes.m[conn]; ok {
+		return d, nil
+	}
+
+	d := &datagramDemux{
+		conn:    conn,
+		peerID:  peerIDFromState(conn.ConnectionState()),
+		streams: make(map[quic.StreamID]chan []byte),
+	}
+	demuxes.m[conn] = d
+	go d.run()
+
+	return d, nil
+}
+
+func (d *datagramDemux) run() {
+	defer func() {
+		demuxes.Lock()
+		delete(demuxes.m, d.conn)
+		demuxes.Unlock()
+
+		d.mutex.Lock()
+		for id, ch := range d.streams {
+			close(ch)
+			delete(d.streams, id)
+		}
+		d.mutex.Unlock()
+	}()
+
+	for {
+		msg, err := d.conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+
+		r := bytes.NewReader(msg)
+		quarterID, err := quicvarint.Read(r)
+		if err != nil {
+			continue
+		}
+
+		d.mutex.Lock()
+		if ch, ok := d.streams[quic.StreamID(quarterID*4)]; ok {
+			select {
+			case ch <- msg[len(msg)-r.Len():]:
+			default:
+				observeDroppedDatagram(d.peerID, "incoming", "queue_full")
+			}
+		}
+		d.mutex.Unlock()
+	}
+}
+
+// register returns the channel receiving the datagrams of the request
+// stream id, closed when unregistered or when the connection is closed
+func (d *datagramDemux) register(id quic.StreamID) <-chan []byte {
+	ch := make(chan []byte, datagramQueueLen)
+
+	d.mutex.Lock()
+	if d.conn.Context().Err() != nil {
+		close(ch)
+	} else {
+		d.streams[id] = ch
+	}
+	d.mutex.Unlock()
+
+	return ch
+}
+
+func (d *datagramDemux) unregister(id quic.StreamID) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if ch, ok := d.streams[id]; ok {
+		close(ch)
+		delete(d.streams, id)
+	}
+}
+
+// send sends payload as an HTTP Datagram of the request stream id
+func (d *datagramDemux) send(id quic.StreamID, payload []byte) error {
+	msg := make([]byte, 0, len(payload)+8)
+	msg = quicvarint.Append(msg, uint64(id/4))
+	msg = append(msg, payload...)
+
+	return d.conn.SendMessage(msg)
+}
+
+// Datagrams sends and receives unreliable messages on an authenticated
+// connection, either the HTTP Datagrams (RFC 9297) of a request stream or
+// the QUIC DATAGRAM frames of a connection returned by Listen or Dial. The
+// datagrams are rate limited by peer identity (see datagrams.rate_limit),
+// the datagrams exceeding the limit are dropped. Both peers need the QUIC
+// datagrams (quic.enable_datagrams).
+type Datagrams struct {
+	peerID  string
+	limiter *datagramLimiter
+	send    func([]byte) error
+	rcvd    <-chan []byte
+	done    <-chan struct{}
+	close   func() error
+}
+
+// RequestDatagrams answers the request with 200 and returns the HTTP
+// Datagrams of its stream. The stream is taken over by the returned
+// Datagrams and closed by Close.
+func RequestDatagrams(w http.ResponseWriter, r *http.Request) (*Datagrams, error) {
+	raw := rawResponseWriter(w)
+	flusher, ok := raw.(http.Flusher)
+	if !ok {
+		return nil, errFlushNotSupported
+	}
+	hijacker, ok := raw.(http3.Hijacker)
+	if !ok {
+		return nil, errDatagramsNotSupported
+	}
+	dconn, ok := hijacker.StreamCreator().(datagramConn)
+	if !ok {
+		return nil, errDatagramsNotSupported
+	}
+	demux, err := getDatagramDemux(dconn)
+	if err != nil {
+		return nil, err
+	}
+	streamer, ok := r.Body.(http3.HTTPStreamer)
+	if !ok {
+		return nil, errDatagramsNotSupported
+	}
+
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	str := streamer.HTTPStream()
+	d := newStreamDatagrams(demux, str.StreamID())
+	unregister := d.close
+	d.close = func() error {
+		unregister()
+		str.CancelRead(0)
+		return str.Close()
+	}
+
+	return d, nil
+}
+
+// newStreamDatagrams returns the HTTP Datagrams of the request stream id
+func newStreamDatagrams(demux *datagramDemux, id quic.StreamID) *Datagrams {
+	var once sync.Once
+
+	return &Datagrams{
+		peerID:  demux.peerID,
+		limiter: getDatagramLimiter(demux.peerID),
+		send:    func(b []byte) error { return demux.send(id, b) },
+		rcvd:    demux.register(id),
+		done:    demux.conn.Context().Done(),
+		close: func() error {
+			once.Do(func() { demux.unregister(id) })
+			return nil
+		},
+	}
+}
+
+var connDatagrams = struct {
+	sync.Mutex
+	m map[quic.Connection]*Datagrams
+}{m: make(map[quic.Connection]*Datagrams)}
+
+// ConnDatagrams returns the QUIC DATAGRAM frames of a connection returned by
+// Listen or Dial. The connections carrying HTTP/3 use RequestDatagrams
+// instead.
+func ConnDatagrams(qconn quic.Connection) (*Datagrams, error) {
+	if !qconn.ConnectionState().SupportsDatagrams {
+		return nil, errDatagramsNotSupported
+	}
+
+	connDatagrams.Lock()
+	defer connDatagrams.Unlock()
+
+	if d, ok := connDatagrams.m[qconn]; ok {
+		return d, nil
+	}
+
+	peerID := peerIDFromConn(qconn)
+	rcvd := make(chan []byte, datagramQueueLen)
+	d := &Datagrams{
+		peerID:  peerID,
+		limiter: getDatagramLimiter(peerID),
+		send:    qconn.SendMessage,
+		rcvd:    rcvd,
+		done:    qconn.Context().Done(),
+		close:   func() error { return nil },
+	}
+	connDatagrams.m[qconn] = d
+
+	// every call to ReceiveMessage consumes a datagram, only one reader per
+	// connection
+	go func() {
+		defer func() {
+			connDatagrams.Lock()
+			delete(connDatagrams.m, qconn)
+			connDatagrams.Unlock()
+			close(rcvd)
+		}()
+
+		for {
+			msg, err := qconn.ReceiveMessage()
+			if err != nil {
+				return
+			}
+
+			select {
+			case rcvd <- msg:
+			default:
+				observeDroppedDatagram(peerID, "incoming", "queue_full")
+			}
+		}
+	}()
+
+	return d, nil
+}
+
+// PeerID returns the SPIFFE ID of the peer, empty when the peer didn't
+// present a SPIFFE certificate
+func (d *Datagrams) PeerID() string {
+	return d.peerID
+}
+
+// Send sends b as a datagram. It fails with ErrDatagramRateLimited when
+// the peer identity exceeds its rate limit.
+func (d *Datagrams) Send(b []byte) error {
+	if !d.limiter.allow("outgoing") {
+		observeDroppedDatagram(d.peerID, "outgoing", "rate_limited")
+		return ErrDatagramRateLimited
+	}
+
+	if err := d.send(b); err != nil {
+		observeDroppedDatagram(d.peerID, "outgoing", "send_error")
+		return err
+	}
+
+	observeDatagram(d.peerID, "outgoing")
+	return nil
+}
+
+// Receive returns the next datagram received within the rate limit of the
+// peer identity
+func (d *Datagrams) Receive(ctx context.Context) ([]byte, error) {
+	for {
+		select {
+		case b, ok := <-d.rcvd:
+			if !ok {
+				return nil, errDatagramsClosed
+			}
+			if !d.limiter.allow("incoming") {
+				observeDroppedDatagram(d.peerID, "incoming", "rate_limited")
+				continue
+			}
+			observeDatagram(d.peerID, "incoming")
+			return b, nil
+		case <-d.done:
+			return nil, errDatagramsClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Close stops receiving the datagrams, the QUIC connection is kept
+func (d *Datagrams) Close() error {
+	return d.close()
+}
+
+// datagramLimiter is the rate limiter of the datagrams of a peer identity,
+// shared by all its connections and request streams
+type datagramLimiter struct {
+	peerID string
+
+	mutex sync.Mutex
+	limit config.DatagramLimit
+	in    *rate.Limiter
+	out   *rate.Limiter
+}
+
+var datagramLimiters = struct {
+	sync.Mutex
+	m map[string]*datagramLimiter
+}{m: make(map[string]*datagramLimiter)}
+
+func getDatagramLimiter(peerID string) *datagramLimiter {
+	datagramLimiters.Lock()
+	defer datagramLimiters.Unlock()
+
+	l, ok := datagramLimiters.m[peerID]
+	if !ok {
+		l = &datagramLimiter{peerID: peerID}
+		datagramLimiters.m[peerID] = l
+	}
+
+	return l
+}
+
+// allow consumes a token of the direction. The limit is read from the
+// config every time since it can be reloaded, the buckets start full again
+// when it changes.
+func (l *datagramLimiter) allow(direction string) bool {
+	limit := config.GetDatagramLimit(l.peerID)
+	if limit.RateLimit == 0 {
+		return true
+	}
+
+	l.mutex.Lock()
+	if l.in == nil || l.limit != limit {
+		l.limit = limit
+		l.in = rate.NewLimiter(rate.Limit(limit.RateLimit), limit.Burst)
+		l.out = rate.NewLimiter(rate.Limit(limit.RateLimit), limit.Burst)
+	}
+	lim := l.in
+	if direction == "outgoing" {
+		lim = l.out
+	}
+	l.mutex.Unlock()
+
+	return lim.Allow()
+}
+
+func observeDatagram(peerID, direction string) {
+	if config.GetMetricsEnabled() {
+		ops.Datagrams.WithLabelValues(peerID, direction).Inc()
+	}
+}
+
+func observeDroppedDatagram(peerID, direction, reason string) {
+	if config.GetMetricsEnabled() {
+		ops.DroppedDatagrams.WithLabelValues(peerID, direction, reason).Inc()
+	}
+}
@@ -0,0 +1,116 @@
+package conn
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/quicsec/quicsec/config"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+// datagramsPeerID has a datagram limit of 3 datagrams (burst) without
+// refill during the tests
+const datagramsPeerID = "spiffe://quicsec.test/datagrams"
+
+// setDatagramsLimit reloads the core config with a datagram limit of burst
+// datagrams for datagramsPeerID
+func setDatagramsLimit(t *testing.T, burst int) {
+	t.Helper()
+
+	err := testEnv.UpdateConfig(map[string]interface{}{
+		"datagrams": map[string]interface{}{
+			"limits": []interface{}{
+				map[string]interface{}{"spiffe_id": datagramsPeerID, "rate_limit": 0.001, "burst": burst},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for config.GetDatagramLimit(datagramsPeerID).Burst != burst {
+		if time.Now().After(deadline) {
+			t.Fatal("datagram limit not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestDatagrams returns the datagrams of datagramsPeerID receiving rcvd
+func newTestDatagrams(rcvd chan []byte) (*Datagrams, *[][]byte) {
+	var sent [][]byte
+	done := make(chan struct{})
+
+	return &Datagrams{
+		peerID:  datagramsPeerID,
+		limiter: getDatagramLimiter(datagramsPeerID),
+		send: func(b []byte) error {
+			sent = append(sent, b)
+			return nil
+		},
+		rcvd:  rcvd,
+		done:  done,
+		close: func() error { return nil },
+	}, &sent
+}
+
+func TestDatagramsRateLimit(t *testing.T) {
+	dropped := func(direction string) float64 {
+		return testutil.ToFloat64(ops.DroppedDatagrams.WithLabelValues(datagramsPeerID, direction, "rate_limited"))
+	}
+	droppedOut, droppedIn := dropped("outgoing"), dropped("incoming")
+	setDatagramsLimit(t, 3)
+
+	// the connections of the identity share its tokens
+	d1, sent1 := newTestDatagrams(nil)
+	d2, sent2 := newTestDatagrams(nil)
+	for i, d := range []*Datagrams{d1, d2, d1} {
+		if err := d.Send([]byte("ping")); err != nil {
+			t.Fatalf("datagram %d: %v", i, err)
+		}
+	}
+	if err := d2.Send([]byte("ping")); err != ErrDatagramRateLimited {
+		t.Fatalf("got error %v, want %v", err, ErrDatagramRateLimited)
+	}
+	if len(*sent1) != 2 || len(*sent2) != 1 {
+		t.Errorf("%d and %d datagrams sent, want 2 and 1", len(*sent1), len(*sent2))
+	}
+	if got := dropped("outgoing") - droppedOut; got != 1 {
+		t.Errorf("%g outgoing datagrams dropped, want 1", got)
+	}
+
+	// the incoming datagrams have their own tokens
+	rcvd := make(chan []byte, 5)
+	for _, msg := range []string{"a", "b", "c", "d", "e"} {
+		rcvd <- []byte(msg)
+	}
+	close(rcvd)
+	d, _ := newTestDatagrams(rcvd)
+	for _, want := range []string{"a", "b", "c"} {
+		if got, err := d.Receive(context.Background()); err != nil || string(got) != want {
+			t.Fatalf("got %q, %v, want %q", got, err, want)
+		}
+	}
+	if got, err := d.Receive(context.Background()); err != errDatagramsClosed {
+		t.Fatalf("got %q, %v, want the over-limit datagrams dropped", got, err)
+	}
+	if got := dropped("incoming") - droppedIn; got != 2 {
+		t.Errorf("%g incoming datagrams dropped, want 2", got)
+	}
+
+	// a new limit refills the buckets
+	setDatagramsLimit(t, 5)
+	for i := 0; i < 5; i++ {
+		if err := d1.Send([]byte("ping")); err != nil {
+			t.Fatalf("datagram %d after the reload: %v", i, err)
+		}
+	}
+	if err := d1.Send([]byte("ping")); err != ErrDatagramRateLimited {
+		t.Errorf("got error %v after the reload, want %v", err, ErrDatagramRateLimited)
+	}
+}
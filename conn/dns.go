@@ -6,27 +6,55 @@ import (
 	"regexp"
 	"sort"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/miekg/dns"
 	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations"
+)
+
+const (
+	cacheResultHit         = "hit"
+	cacheResultNegativeHit = "negative_hit"
+	cacheResultStale       = "stale"
+	cacheResultMiss        = "miss"
 )
 
+// rCache entries live for their TTL plus the stale TTL. Expired entries
+// are still served (stale-while-revalidate) until go-cache evicts them.
 var rCache *cache.Cache
-var rCacheLock sync.Mutex
+
+// rGroup deduplicates concurrent resolutions of the same name
+var rGroup singleflight.Group
 
 func init() {
 	rCache = cache.New(5*time.Minute, 10*time.Minute)
 }
 
+// cacheEntry is a resolution result. Failed resolutions are cached as well
+// (negative caching) with err set.
+type cacheEntry struct {
+	endpoints []string
+	err       error
+	expires   time.Time
+}
+
 func lookUp(domain string, dnsType uint16) (*dns.Msg, error) {
 	// Specify the HTTPS domain to lookup
 	fqdn := dns.Fqdn(domain)
 
 	// Create a DNS client
 	client := dns.Client{}
-	config, _ := dns.ClientConfigFromFile("/etc/resolv.conf")
+	resolvConf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil {
+		return nil, fmt.Errorf("error reading resolv.conf: %s", err.Error())
+	}
+	if len(resolvConf.Servers) == 0 {
+		return nil, fmt.Errorf("no DNS server configured in resolv.conf")
+	}
 
 	// Create a query
 	query := dns.Msg{}
@@ -34,7 +62,9 @@ func lookUp(domain string, dnsType uint16) (*dns.Msg, error) {
 	query.SetQuestion(fqdn, dnsType)
 
 	// Send the query to a DNS resolver
-	res, _, err := client.Exchange(&query, net.JoinHostPort(config.Servers[0], "53"))
+	start := time.Now()
+	res, _, err := client.Exchange(&query, net.JoinHostPort(resolvConf.Servers[0], resolvConf.Port))
+	observeDnsLookup(dnsType, start, err)
 
 	if err != nil {
 		return nil, fmt.Errorf("error querying DNS: %s", err.Error())
@@ -82,36 +112,61 @@ func parseHttpsRecord(dnsMsg *dns.Msg) (map[string]int, uint32) {
 	return upstreams, ttl
 }
 
-func parseARecord(dnsMsg *dns.Msg) string {
+func parseARecord(dnsMsg *dns.Msg) (string, uint32) {
 	var upstream string
+	var ttl uint32
 
-	if dnsMsg  != nil{
+	if dnsMsg != nil {
 		for _, answer := range dnsMsg.Answer {
 			if a, ok := answer.(*dns.A); ok {
 				upstream = a.A.String()
+				ttl = a.Header().Ttl
 			}
 		}
 	}
 
-	return upstream
+	return upstream, ttl
 }
 
+// GetAllEpAddresses returns the endpoints ("ip:port") advertised by the
+// HTTPS record of domain, ordered by priority
 func GetAllEpAddresses(domain string) ([]string, error) {
-	rCacheLock.Lock()
-	defer rCacheLock.Unlock()
+	return cachedLookup("HTTPS/"+domain, func() ([]string, uint32, error) {
+		return lookUpHttpsEndpoints(domain)
+	})
+}
+
+// GetEpAddress returns the address from the A record of domain
+func GetEpAddress(domain string) (string, error) {
+	eps, err := cachedLookup("A/"+domain, func() ([]string, uint32, error) {
+		msg, err := lookUp(domain, dns.TypeA)
+		if err != nil {
+			return nil, 0, err
+		}
 
-	if cachedData, ok := rCache.Get(domain); ok {
-		return cachedData.([]string), nil
+		addr, ttl := parseARecord(msg)
+		if addr == "" {
+			return nil, 0, fmt.Errorf("no A record found for %s", domain)
+		}
+
+		return []string{addr}, ttl, nil
+	})
+	if err != nil {
+		return "", err
 	}
 
+	return eps[0], nil
+}
+
+func lookUpHttpsEndpoints(domain string) ([]string, uint32, error) {
 	msg, err := lookUp(domain, dns.TypeHTTPS)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	ups, ttl := parseHttpsRecord(msg)
 	if len(ups) <= 0 {
-		return nil, fmt.Errorf("failed to  parse HTTPS record");
+		return nil, 0, fmt.Errorf("failed to  parse HTTPS record")
 	}
 
 	var priorities []int
@@ -130,17 +185,104 @@ func GetAllEpAddresses(domain string) ([]string, error) {
 			}
 		}
 	}
-	rCache.Set(domain, endpoints, time.Duration(ttl)*time.Second)
 
-	return endpoints, nil
+	return endpoints, ttl, nil
+}
+
+// cachedLookup serves key from the cache. Fresh entries (positive or
+// negative) are returned as is. Expired positive entries are returned while a
+// background refresh runs. Misses and expired negative entries are resolved
+// synchronously, with concurrent resolutions of the same key deduplicated.
+func cachedLookup(key string, resolve func() ([]string, uint32, error)) ([]string, error) {
+	if cached, ok := rCache.Get(key); ok {
+		entry := cached.(*cacheEntry)
+
+		if time.Now().Before(entry.expires) {
+			if entry.err != nil {
+				observeDnsCache(cacheResultNegativeHit)
+			} else {
+				observeDnsCache(cacheResultHit)
+			}
+			return entry.endpoints, entry.err
+		}
+
+		if entry.err == nil {
+			observeDnsCache(cacheResultStale)
+			rGroup.DoChan(key, func() (interface{}, error) {
+				return resolveAndStore(key, resolve, true)
+			})
+			return entry.endpoints, nil
+		}
+	}
+
+	observeDnsCache(cacheResultMiss)
+	v, err, _ := rGroup.Do(key, func() (interface{}, error) {
+		return resolveAndStore(key, resolve, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]string), nil
 }
 
-func GetEpAddress(domain string)(string, error) {
-	msg, err := lookUp(domain, dns.TypeA)
+// resolveAndStore resolves key and caches the result. A failed refresh of an
+// expired positive entry isn't cached: the entry is still served until the
+// end of its stale window.
+func resolveAndStore(key string, resolve func() ([]string, uint32, error), refresh bool) (interface{}, error) {
+	minTTL, maxTTL, negativeTTL, staleTTL := config.GetDnsCacheTTLs()
 
+	endpoints, ttl, err := resolve()
+	if err != nil && refresh {
+		return nil, err
+	}
+
+	entry := &cacheEntry{
+		endpoints: endpoints,
+		err:       err,
+	}
+
+	var lifetime time.Duration
 	if err != nil {
-		return "", err
+		lifetime = negativeTTL
+		// a negative entry is never served stale
+		staleTTL = 0
+	} else {
+		lifetime = clampTTL(time.Duration(ttl)*time.Second, minTTL, maxTTL)
 	}
 
-	return parseARecord(msg), nil
-}
\ No newline at end of file
+	if lifetime > 0 {
+		entry.expires = time.Now().Add(lifetime)
+		rCache.Set(key, entry, lifetime+staleTTL)
+	}
+
+	return endpoints, err
+}
+
+func clampTTL(ttl, minTTL, maxTTL time.Duration) time.Duration {
+	if ttl < minTTL {
+		ttl = minTTL
+	}
+	if maxTTL > 0 && ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+// the metrics are created by OperationsInit, the lookups done before are not
+// observed
+func observeDnsCache(result string) {
+	if config.GetMetricsEnabled() && operations.DnsCacheRequests != nil {
+		operations.DnsCacheRequests.WithLabelValues(result).Inc()
+	}
+}
+
+func observeDnsLookup(dnsType uint16, start time.Time, err error) {
+	if config.GetMetricsEnabled() && operations.DnsHistogramLookupLatency != nil {
+		status := "success"
+		if err != nil {
+			status = "failure"
+		}
+		operations.DnsHistogramLookupLatency.WithLabelValues(dns.TypeToString[dnsType], status).Observe(time.Since(start).Seconds())
+	}
+}
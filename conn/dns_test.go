@@ -0,0 +1,150 @@
+package conn
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClampTTL(t *testing.T) {
+	tests := []struct {
+		name          string
+		ttl, min, max time.Duration
+		want          time.Duration
+	}{
+		{"within bounds", 30 * time.Second, 5 * time.Second, 5 * time.Minute, 30 * time.Second},
+		{"below min", time.Second, 5 * time.Second, 5 * time.Minute, 5 * time.Second},
+		{"above max", time.Hour, 5 * time.Second, 5 * time.Minute, 5 * time.Minute},
+		{"equal to min", 5 * time.Second, 5 * time.Second, 5 * time.Minute, 5 * time.Second},
+		{"equal to max", 5 * time.Minute, 5 * time.Second, 5 * time.Minute, 5 * time.Minute},
+		{"zero ttl", 0, 5 * time.Second, 5 * time.Minute, 5 * time.Second},
+		{"no max", time.Hour, 5 * time.Second, 0, time.Hour},
+		{"no clamps", 42 * time.Second, 0, 0, 42 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clampTTL(tt.ttl, tt.min, tt.max); got != tt.want {
+				t.Errorf("clampTTL(%s, %s, %s) = %s, want %s", tt.ttl, tt.min, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+// waitLookup waits for the resolution of key in progress, if any
+func waitLookup(key string) {
+	rGroup.Do(key, func() (interface{}, error) { return nil, nil })
+}
+
+func TestCachedLookupNegativeHit(t *testing.T) {
+	key := "A/negative.test"
+	rCache.Delete(key)
+	errLookup := errors.New("no such host")
+	resolves := 0
+	resolve := func() ([]string, uint32, error) {
+		resolves++
+		return nil, 0, errLookup
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := cachedLookup(key, resolve); err != errLookup {
+			t.Fatalf("lookup %d: got error %v, want %v", i, err, errLookup)
+		}
+	}
+	if resolves != 1 {
+		t.Errorf("resolved %d times, want 1", resolves)
+	}
+}
+
+func TestCachedLookupStaleWhileRefreshFails(t *testing.T) {
+	key := "A/stale.test"
+	stale := []string{"192.0.2.1"}
+	rCache.Set(key, &cacheEntry{endpoints: stale, expires: time.Now().Add(-time.Second)}, time.Minute)
+
+	var resolves int32
+	resolve := func() ([]string, uint32, error) {
+		atomic.AddInt32(&resolves, 1)
+		return nil, 0, errors.New("server failure")
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := cachedLookup(key, resolve)
+		if err != nil || !reflect.DeepEqual(got, stale) {
+			t.Fatalf("lookup %d: got %v, %v, want the stale endpoints %v", i, got, err, stale)
+		}
+		waitLookup(key)
+	}
+
+	// each lookup of the stale entry retries the refresh
+	if n := atomic.LoadInt32(&resolves); n != 2 {
+		t.Errorf("resolved %d times, want 2", n)
+	}
+	cached, ok := rCache.Get(key)
+	if !ok {
+		t.Fatal("stale entry evicted after a failed refresh")
+	}
+	if entry := cached.(*cacheEntry); entry.err != nil || !reflect.DeepEqual(entry.endpoints, stale) {
+		t.Errorf("cached entry = %v, %v, want the stale endpoints %v", entry.endpoints, entry.err, stale)
+	}
+}
+
+func TestCachedLookupStaleRefreshed(t *testing.T) {
+	key := "A/refreshed.test"
+	rCache.Set(key, &cacheEntry{endpoints: []string{"192.0.2.1"}, expires: time.Now().Add(-time.Second)}, time.Minute)
+
+	fresh := []string{"192.0.2.2"}
+	resolve := func() ([]string, uint32, error) {
+		return fresh, 60, nil
+	}
+
+	if got, _ := cachedLookup(key, resolve); !reflect.DeepEqual(got, []string{"192.0.2.1"}) {
+		t.Fatalf("got %v, want the stale endpoints", got)
+	}
+	waitLookup(key)
+	if got, err := cachedLookup(key, resolve); err != nil || !reflect.DeepEqual(got, fresh) {
+		t.Errorf("got %v, %v, want the refreshed endpoints %v", got, err, fresh)
+	}
+}
+
+func TestCachedLookupConcurrentMisses(t *testing.T) {
+	key := "A/concurrent.test"
+	rCache.Delete(key)
+	want := []string{"192.0.2.3"}
+	release := make(chan struct{})
+	var resolves int32
+	resolve := func() ([]string, uint32, error) {
+		atomic.AddInt32(&resolves, 1)
+		<-release
+		return want, 60, nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := cachedLookup(key, resolve)
+			if err == nil && !reflect.DeepEqual(got, want) {
+				err = errors.New("unexpected endpoints")
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if n := atomic.LoadInt32(&resolves); n != 1 {
+		t.Errorf("resolved %d times, want 1", n)
+	}
+}
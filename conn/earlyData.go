@@ -0,0 +1,118 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+type earlyDataContextKey struct{}
+
+var clientSessionCache tls.ClientSessionCache
+var clientSessionCacheOnce sync.Once
+
+// getClientSessionCache returns the session cache shared by all the client
+// connections, so the session tickets outlive a single Do call
+func getClientSessionCache() tls.ClientSessionCache {
+	clientSessionCacheOnce.Do(func() {
+		clientSessionCache = tls.NewLRUClientSessionCache(config.GetQuicConfig().SessionCacheSize)
+	})
+
+	return clientSessionCache
+}
+
+// IsEarlyData reports whether the request was received as 0-RTT data, i.e.
+// before the handshake completed. Such a request can be replayed by an
+// attacker.
+func IsEarlyData(r *http.Request) bool {
+	early, _ := r.Context().Value(earlyDataContextKey{}).(bool)
+	return early
+}
+
+// earlyDataListener marks the streams accepted before the handshake
+// completion, so handlers can tell the requests received as 0-RTT data
+type earlyDataListener struct {
+	quic.EarlyListener
+}
+
+func (l earlyDataListener) Accept(ctx context.Context) (quic.EarlyConnection, error) {
+	conn, err := l.EarlyListener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+	setEarlyConnPeerID(conn)
+
+	return &earlyDataConn{EarlyConnection: conn}, nil
+}
+
+type earlyDataConn struct {
+	quic.EarlyConnection
+}
+
+func (c *earlyDataConn) AcceptStream(ctx context.Context) (quic.Stream, error) {
+	str, err := c.EarlyConnection.AcceptStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-c.HandshakeComplete().Done():
+		return str, nil
+	default:
+		// http3 derives the request context from the stream context
+		return &earlyDataStream{
+			Stream: str,
+			ctx:    context.WithValue(str.Context(), earlyDataContextKey{}, true),
+		}, nil
+	}
+}
+
+type earlyDataStream struct {
+	quic.Stream
+	ctx context.Context
+}
+
+func (s *earlyDataStream) Context() context.Context {
+	return s.ctx
+}
+
+// earlyDataHandler applies the replay protection to the requests received
+// as 0-RTT data: they are marked with the "Early-Data: 1" header (RFC 8470)
+// and only the methods configured in quic.early_data_methods are served, the
+// others are answered with 425 (Too Early)
+func earlyDataHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsEarlyData(r) {
+			r.Header.Set("Early-Data", "1")
+
+			if !config.IsEarlyDataMethod(r.Method) {
+				w.WriteHeader(http.StatusTooEarly)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// earlyDataRoundTripper sends the requests allowed as early data using
+// 0-RTT. The http3 RoundTripper only supports GET requests as 0-RTT, the
+// other methods always wait for the handshake completion.
+type earlyDataRoundTripper struct {
+	Base http.RoundTripper
+}
+
+func (rt earlyDataRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Method == http.MethodGet && config.IsEarlyDataMethod(r.Method) {
+		r = r.Clone(r.Context())
+		r.Method = http3.MethodGet0RTT
+	}
+
+	return rt.Base.RoundTrip(r)
+}
@@ -0,0 +1,54 @@
+package conn
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEarlyDataHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		early      bool
+		wantStatus int
+		wantServed bool
+		wantHeader string
+	}{
+		{"early GET", http.MethodGet, true, http.StatusOK, true, "1"},
+		{"early HEAD", http.MethodHead, true, http.StatusOK, true, "1"},
+		{"early POST", http.MethodPost, true, http.StatusTooEarly, false, ""},
+		{"early DELETE", http.MethodDelete, true, http.StatusTooEarly, false, ""},
+		{"POST after the handshake", http.MethodPost, false, http.StatusOK, true, ""},
+		{"GET after the handshake", http.MethodGet, false, http.StatusOK, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := false
+			var header string
+			handler := earlyDataHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+				header = r.Header.Get("Early-Data")
+			}))
+
+			r := httptest.NewRequest(tt.method, "https://workload.test/", nil)
+			if tt.early {
+				r = r.WithContext(context.WithValue(r.Context(), earlyDataContextKey{}, true))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if served != tt.wantServed {
+				t.Errorf("served = %t, want %t", served, tt.wantServed)
+			}
+			if header != tt.wantHeader {
+				t.Errorf("Early-Data header %q, want %q", header, tt.wantHeader)
+			}
+		})
+	}
+}
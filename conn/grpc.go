@@ -0,0 +1,248 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+// NextProtoGRPC is the ALPN of the QUIC connections carrying gRPC, one
+// bidirectional stream per gRPC (HTTP/2) connection. It is a QuicSec
+// protocol, incompatible with gRPC over HTTP/3.
+const NextProtoGRPC = "quicsec-grpc"
+
+// nextProtoH2 is the ALPN of the gRPC connections over TLS
+const nextProtoH2 = "h2"
+
+var (
+	errListenerClosed   = errors.New("conn: listener closed")
+	errListenerNotReady = errors.New("conn: listener not serving yet")
+)
+
+// StreamConn is a net.Conn over a bidirectional QUIC stream
+type StreamConn struct {
+	quic.Stream
+
+	conn quic.Connection
+}
+
+func (c *StreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *StreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close closes both directions of the stream, the QUIC connection is kept
+func (c *StreamConn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+// Connection returns the QUIC connection of the stream
+func (c *StreamConn) Connection() quic.Connection {
+	return c.conn
+}
+
+// grpcListener merges the streams of the QUIC connections and the TLS
+// connections accepted on the same address
+type grpcListener struct {
+	quicLn quic.Listener
+	tcpLn  net.Listener
+
+	conns     chan net.Conn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// ListenGRPC returns a listener for gRPC servers. It accepts on addr the
+// bidirectional streams of QUIC connections (NextProtoGRPC) and, for the
+// clients without QUIC, TLS connections over TCP (h2). Both are
+// authenticated and authorized with the SPIFFE ID of the client. The TLS
+// handshake of the TCP connections is left to the caller (e.g. the gRPC
+// transport credentials).
+func ListenGRPC(addr string) (net.Listener, error) {
+	keyLog, _ := ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(true)
+	connLogger.Info("ListenGRPC() initialization", "addr", addr)
+
+	quicTLSConfig := newServerTLSConfig(keyLog)
+	quicTLSConfig.NextProtos = []string{NextProtoGRPC}
+
+	quicLn, err := quic.ListenAddr(addr, quicTLSConfig, newTunnelQuicConfig())
+	if err != nil {
+		connLogger.Error(err, "failed to Listen for QUIC connections")
+		return nil, err
+	}
+
+	tcpLn, err := net.Listen("tcp", addr)
+	if err != nil {
+		connLogger.Error(err, "failed to Listen at TCP address")
+		quicLn.Close()
+		return nil, err
+	}
+
+	tlsConfig := newServerTLSConfig(keyLog)
+	tlsConfig.NextProtos = []string{nextProtoH2}
+
+	l := &grpcListener{
+		quicLn: quicLn,
+		tcpLn:  tcpLn,
+		conns:  make(chan net.Conn),
+		errs:   make(chan error, 2),
+		done:   make(chan struct{}),
+	}
+
+	go l.acceptQuic()
+	go l.acceptTLS(tlsConfig)
+
+	return l, nil
+}
+
+func (l *grpcListener) acceptQuic() {
+	for {
+		qconn, err := l.quicLn.Accept(context.Background())
+		if err != nil {
+			l.errs <- err
+			return
+		}
+		setConnPeerID(qconn)
+
+		go func() {
+			for {
+				str, err := qconn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+
+				select {
+				case l.conns <- &StreamConn{Stream: str, conn: qconn}:
+				case <-l.done:
+					str.CancelRead(0)
+					str.CancelWrite(0)
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (l *grpcListener) acceptTLS(tlsConfig *tls.Config) {
+	for {
+		c, err := l.tcpLn.Accept()
+		if err != nil {
+			l.errs <- err
+			return
+		}
+
+		select {
+		case l.conns <- tls.Server(c, tlsConfig):
+		case <-l.done:
+			c.Close()
+			return
+		}
+	}
+}
+
+func (l *grpcListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errs:
+		l.Close()
+		return nil, err
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+func (l *grpcListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.quicLn.Close()
+		if tcpErr := l.tcpLn.Close(); err == nil {
+			err = tcpErr
+		}
+	})
+	return err
+}
+
+func (l *grpcListener) Addr() net.Addr {
+	return l.tcpLn.Addr()
+}
+
+var grpcDialers = struct {
+	sync.Mutex
+	m map[string]*tunnelDialer
+}{m: make(map[string]*tunnelDialer)}
+
+// DialGRPC dials a gRPC connection to addr: a bidirectional stream of a
+// QUIC connection shared by the gRPC connections to addr, or a TLS
+// connection over TCP (h2) when the QUIC connection can't be established.
+// The server is authenticated and authorized with its SPIFFE ID like in Do.
+func DialGRPC(ctx context.Context, addr string) (net.Conn, error) {
+	keyLog, _ := ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(false)
+
+	grpcDialers.Lock()
+	d, ok := grpcDialers.m[addr]
+	if !ok {
+		d = &tunnelDialer{remote: addr, nextProto: NextProtoGRPC}
+		grpcDialers.m[addr] = d
+	}
+	grpcDialers.Unlock()
+
+	str, qconn, err := d.openStream(ctx)
+	if err == nil {
+		return &StreamConn{Stream: str, conn: qconn}, nil
+	}
+	connLogger.V(log.DebugLevel).Info("falling back to TLS over TCP", "addr", addr, "reason", err.Error())
+
+	tlsConfig, err := newClientTLSConfig(keyLog, nextProtoH2)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialTLS(ctx, addr, tlsConfig)
+}
+
+// dialTLS dials remote trying the static upstreams first, the remote address
+// is resolved by the system otherwise
+func dialTLS(ctx context.Context, remote string, tlsConfig *tls.Config) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	epAddrs, static := GetStaticEpAddresses(host, port)
+	if !static {
+		epAddrs = []string{remote}
+	}
+
+	errs := []error{}
+	for _, ep := range epAddrs {
+		d := &tls.Dialer{Config: keyLogPerPeer(tlsConfig)}
+		c, err := d.DialContext(ctx, "tcp", ep)
+		if err == nil {
+			return c, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", ep, err))
+	}
+
+	return nil, fmt.Errorf("failed to dial %s: %v", remote, errs)
+}
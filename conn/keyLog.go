@@ -0,0 +1,52 @@
+package conn
+
+import (
+	"crypto/tls"
+
+	"github.com/quicsec/quicsec/config"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+// keyLogPerPeer returns the TLS config of a single connection whose secrets
+// are only logged once the peer is verified and selected by
+// quic.debug.secret_peers. Without key log or peer selection, tlsConfig is
+// returned as is.
+func keyLogPerPeer(tlsConfig *tls.Config) *tls.Config {
+	if tlsConfig.KeyLogWriter == nil || config.GetKeyLogPeers() == nil {
+		return tlsConfig
+	}
+
+	keyLog := ops.NewPeerKeyLog(tlsConfig.KeyLogWriter)
+	verifyConnection := tlsConfig.VerifyConnection
+
+	c := tlsConfig.Clone()
+	c.GetConfigForClient = nil
+	c.KeyLogWriter = keyLog
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verifyConnection != nil {
+			if err := verifyConnection(cs); err != nil {
+				keyLog.SetPeerID("")
+				return err
+			}
+		}
+
+		keyLog.SetPeerID(peerIDFromCerts(cs))
+		return nil
+	}
+
+	return c
+}
+
+// serverKeyLogPerPeer makes the server using tlsConfig apply keyLogPerPeer
+// to each connection. tlsConfig must not be cloned afterwards, the clones
+// would still use the original config.
+func serverKeyLogPerPeer(tlsConfig *tls.Config) {
+	if tlsConfig.KeyLogWriter == nil || config.GetKeyLogPeers() == nil {
+		return
+	}
+
+	tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return keyLogPerPeer(tlsConfig), nil
+	}
+}
@@ -0,0 +1,93 @@
+package conn
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+
+	"github.com/quicsec/quicsec/internal/testenv"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+var testEnv *testenv.Env
+
+// TestMain loads the core config of the tests, with the authz rules of the
+// identities used by the tests, and starts the operations (metrics)
+func TestMain(m *testing.M) {
+	coreConfig := map[string]interface{}{
+		"upstreams": map[string]interface{}{
+			"bookstore": []interface{}{
+				map[string]interface{}{"address": "10.0.0.5:14001", "weight": 3},
+				"10.0.0.6",
+				map[string]interface{}{"address": "10.0.0.7:14001", "priority": 2},
+			},
+		},
+		"datagrams": map[string]interface{}{
+			"limits": []interface{}{
+				map[string]interface{}{"spiffe_id": datagramsPeerID, "rate_limit": 0.001, "burst": 3},
+			},
+		},
+		"connect": map[string]interface{}{
+			"allow": []interface{}{
+				map[string]interface{}{
+					"spiffe_id": testenv.WorkloadID,
+					"targets": []interface{}{
+						"api.example.org:443",
+						"*.svc.test:443",
+						"10.0.0.0/8:53",
+						"db.example.org:5432-5439",
+						"127.0.0.1:*",
+					},
+				},
+			},
+		},
+	}
+	env := map[string]string{
+		"QUICSEC_CONNECT_ENABLE": "1",
+	}
+
+	var err error
+	testEnv, err = testenv.Setup(coreConfig, env)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ops.OperationsInit()
+
+	code := m.Run()
+	testEnv.Close()
+	os.Exit(code)
+}
+
+// newTestServer serves handler over HTTP/3 on a loopback address with the
+// TLS config of the listeners and returns the address
+func newTestServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := http3.ConfigureTLSConfig(newServerTLSConfig(nil))
+	ln, err := quic.ListenEarly(udpConn, tlsConf, newQuicConfig(nil))
+	if err != nil {
+		udpConn.Close()
+		t.Fatal(err)
+	}
+
+	server := &http3.Server{Handler: handler}
+	go server.ServeListener(ln)
+	t.Cleanup(func() {
+		server.Close()
+		ln.Close()
+		udpConn.Close()
+	})
+
+	return udpConn.LocalAddr().String()
+}
@@ -0,0 +1,460 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/operations/tracing"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+var errPooledConnClosed = errors.New("conn: pooled connection is closed")
+var errHandshakeFailed = errors.New("conn: handshake failed")
+
+// poolKey identifies the connections that can be shared: the resolved
+// endpoint and the SPIFFE ID verified during the handshake
+type poolKey struct {
+	endpoint string
+	peerID   string
+}
+
+// pooledConn is a client QUIC connection shared by the requests sent to the
+// same endpoint. Each connection has its own http3.RoundTripper, so the
+// requests are never sent over a connection dialed to another endpoint.
+type pooledConn struct {
+	conn     quic.EarlyConnection
+	rt       *http3.RoundTripper
+	key      poolKey
+	inFlight int
+	lastUsed time.Time
+
+	// core config generation of the dial, the TLS and QUIC configs of the
+	// connection are stale after a reload
+	generation uint64
+	// expiry of the peer certificate, zero without certificate
+	notAfter time.Time
+	// a request failed on the connection (GOAWAY, stream limit...)
+	broken bool
+}
+
+// usable reports whether new requests can be sent over the connection
+func (pc *pooledConn) usable(generation uint64) bool {
+	if pc.conn.Context().Err() != nil || pc.broken || pc.generation != generation {
+		return false
+	}
+	return pc.notAfter.IsZero() || time.Now().Before(pc.notAfter)
+}
+
+type connPool struct {
+	mutex sync.Mutex
+	conns map[poolKey][]*pooledConn
+
+	// SPIFFE ID verified in the last handshake with each endpoint
+	peers map[string]string
+
+	// dials in progress by endpoint, counted in max_conns_per_endpoint. The
+	// requests waiting for a connection are woken up by dialDone once a dial
+	// completes.
+	dialing  map[string]int
+	dialDone map[string]chan struct{}
+
+	maintOnce sync.Once
+}
+
+var pool = &connPool{
+	conns:    make(map[poolKey][]*pooledConn),
+	peers:    make(map[string]string),
+	dialing:  make(map[string]int),
+	dialDone: make(map[string]chan struct{}),
+}
+
+// get returns a connection to endpoint, reusing a pooled connection when
+// possible. The connection must be given back with release.
+func (p *connPool) get(ctx context.Context, endpoint string, tlsConf *tls.Config, quicConf *quic.Config) (*pooledConn, error) {
+	p.maintOnce.Do(func() {
+		go p.maintain()
+	})
+
+	maxConns := config.GetPoolConfig().MaxConnsPerEndpoint
+	for {
+		p.mutex.Lock()
+		pc, healthy := p.pick(endpoint)
+		conns := healthy + p.dialing[endpoint]
+
+		// an idle connection is reused first, a busy one only when no other
+		// connection can be dialed
+		if pc != nil && (pc.inFlight == 0 || conns >= maxConns) {
+			pc.inFlight++
+			pc.lastUsed = time.Now()
+			p.updateMetrics()
+			p.mutex.Unlock()
+			return pc, nil
+		}
+
+		if conns < maxConns {
+			p.dialing[endpoint]++
+			p.updateMetrics()
+			p.mutex.Unlock()
+			return p.dial(ctx, endpoint, tlsConf, quicConf)
+		}
+
+		// the max is reached by the dials in progress, wait for one of them
+		done, ok := p.dialDone[endpoint]
+		if !ok {
+			done = make(chan struct{})
+			p.dialDone[endpoint] = done
+		}
+		p.mutex.Unlock()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// pick returns the least loaded usable connection to endpoint and the number
+// of usable connections. It must be called with the pool locked.
+func (p *connPool) pick(endpoint string) (*pooledConn, int) {
+	peerID, ok := p.peers[endpoint]
+	if !ok {
+		return nil, 0
+	}
+
+	// the authz rules may have changed since the handshake
+	if config.GetMtlsEnable() && !identity.VerifyIdentity(peerID) {
+		return nil, 0
+	}
+
+	generation := config.GetConfigGeneration()
+	var best *pooledConn
+	healthy := 0
+	for _, pc := range p.conns[poolKey{endpoint: endpoint, peerID: peerID}] {
+		if !pc.usable(generation) {
+			continue
+		}
+		healthy++
+		if best == nil || pc.inFlight < best.inFlight {
+			best = pc
+		}
+	}
+
+	return best, healthy
+}
+
+// dialFinished wakes up the requests waiting for a dial to endpoint, it must
+// be called with the pool locked
+func (p *connPool) dialFinished(endpoint string) {
+	if p.dialing[endpoint]--; p.dialing[endpoint] <= 0 {
+		delete(p.dialing, endpoint)
+	}
+	if done, ok := p.dialDone[endpoint]; ok {
+		close(done)
+		delete(p.dialDone, endpoint)
+	}
+}
+
+func (p *connPool) dial(ctx context.Context, endpoint string, tlsConf *tls.Config, quicConf *quic.Config) (*pooledConn, error) {
+	_, span := tracing.Start(ctx, "quic.handshake", trace.SpanKindClient, semconv.ServerAddress(endpoint))
+
+	generation := config.GetConfigGeneration()
+	conn, err := quic.DialAddrEarlyContext(ctx, endpoint, keyLogPerPeer(tlsConf), quicConf)
+	if err != nil {
+		tracing.End(span, err)
+		p.mutex.Lock()
+		p.dialFinished(endpoint)
+		p.updateMetrics()
+		p.mutex.Unlock()
+		return nil, err
+	}
+
+	pc := &pooledConn{
+		conn:       conn,
+		key:        poolKey{endpoint: endpoint},
+		inFlight:   1,
+		lastUsed:   time.Now(),
+		generation: generation,
+	}
+
+	dialed := false
+	pc.rt = &http3.RoundTripper{
+		TLSClientConfig: tlsConf,
+		QuicConfig:      quicConf,
+		EnableDatagrams: quicConf.EnableDatagrams,
+		Dial: func(context.Context, string, *tls.Config, *quic.Config) (quic.EarlyConnection, error) {
+			// the round tripper only owns the pooled connection, it must not
+			// redial once the connection is gone
+			if dialed {
+				return nil, errPooledConnClosed
+			}
+			dialed = true
+			return conn, nil
+		},
+	}
+
+	go p.add(pc, span)
+
+	return pc, nil
+}
+
+// add waits for the handshake completion and makes the connection available
+// to other requests, keyed by the verified SPIFFE ID of the peer. The
+// handshake span ends with the handshake.
+func (p *connPool) add(pc *pooledConn, span trace.Span) {
+	select {
+	case <-pc.conn.HandshakeComplete().Done():
+		state := pc.conn.ConnectionState()
+		span.SetAttributes(
+			tracing.AttrPeerIdentity.String(peerIDFromState(state)),
+			attribute.Bool("quicsec.tls.resumed", state.TLS.DidResume),
+			attribute.Bool("quicsec.quic.0rtt", state.TLS.Used0RTT),
+		)
+		tracing.End(span, nil)
+		setConnPeerID(pc.conn)
+	case <-pc.conn.Context().Done():
+		tracing.End(span, errHandshakeFailed)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.dialFinished(pc.key.endpoint)
+	if pc.conn.Context().Err() == nil {
+		pc.key.peerID = peerIDFromConn(pc.conn)
+		if certs := pc.conn.ConnectionState().TLS.PeerCertificates; len(certs) > 0 {
+			pc.notAfter = certs[0].NotAfter
+		}
+		p.peers[pc.key.endpoint] = pc.key.peerID
+		p.conns[pc.key] = append(p.conns[pc.key], pc)
+
+		log.LoggerLgr.WithName(log.ConstConnManager).V(log.DebugLevel).Info("connection added to the pool",
+			"endpoint", pc.key.endpoint, "peer_id", pc.key.peerID)
+	}
+	p.updateMetrics()
+}
+
+// release gives pc back to the pool, failed reports a request failure: the
+// connection is not reused
+func (p *connPool) release(pc *pooledConn, failed bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	pc.inFlight--
+	pc.lastUsed = time.Now()
+	if failed {
+		pc.broken = true
+	}
+	if pc.conn.Context().Err() != nil {
+		p.remove(pc)
+	}
+	p.updateMetrics()
+}
+
+// remove must be called with the pool locked
+func (p *connPool) remove(pc *pooledConn) {
+	var kept []*pooledConn
+	for _, c := range p.conns[pc.key] {
+		if c != pc {
+			kept = append(kept, c)
+		}
+	}
+
+	if len(kept) > 0 {
+		p.conns[pc.key] = kept
+		return
+	}
+
+	delete(p.conns, pc.key)
+	if p.peers[pc.key.endpoint] == pc.key.peerID {
+		delete(p.peers, pc.key.endpoint)
+	}
+}
+
+// maintain runs the pool health checks: the closed connections (idle timeout,
+// stateless reset, peer close...) are evicted, as well as the connections
+// idle for longer than pool.idle_timeout and the unusable ones (failed
+// request, core config reloaded, peer certificate expired) once their
+// requests are done
+func (p *connPool) maintain() {
+	ticker := time.NewTicker(config.GetPoolConfig().HealthCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		for _, pc := range p.evict() {
+			pc.conn.CloseWithError(0, "connection evicted from the pool")
+		}
+	}
+}
+
+// evict removes the closed, idle and unusable connections from the pool and
+// returns the open ones, so they are closed without holding the lock
+func (p *connPool) evict() []*pooledConn {
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	idleTimeout := config.GetPoolConfig().IdleTimeout
+	generation := config.GetConfigGeneration()
+	var evicted []*pooledConn
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, pcs := range p.conns {
+		for _, pc := range pcs {
+			switch {
+			case pc.conn.Context().Err() != nil:
+				connLogger.V(log.DebugLevel).Info("closed connection evicted from the pool", "endpoint", pc.key.endpoint)
+				p.remove(pc)
+			case pc.inFlight == 0 && !pc.usable(generation):
+				connLogger.V(log.DebugLevel).Info("unusable connection evicted from the pool", "endpoint", pc.key.endpoint,
+					"broken", pc.broken, "stale_config", pc.generation != generation)
+				p.remove(pc)
+				evicted = append(evicted, pc)
+			case pc.inFlight == 0 && time.Since(pc.lastUsed) > idleTimeout:
+				connLogger.V(log.DebugLevel).Info("idle connection evicted from the pool", "endpoint", pc.key.endpoint)
+				p.remove(pc)
+				evicted = append(evicted, pc)
+			}
+		}
+	}
+	p.updateMetrics()
+
+	return evicted
+}
+
+// updateMetrics must be called with the pool locked
+func (p *connPool) updateMetrics() {
+	if !config.GetMetricsEnabled() {
+		return
+	}
+
+	active, idle := 0, 0
+	endpoints := make(map[string]struct{})
+	for key, pcs := range p.conns {
+		endpoints[key.endpoint] = struct{}{}
+		for _, pc := range pcs {
+			if pc.inFlight > 0 {
+				active++
+			} else {
+				idle++
+			}
+		}
+	}
+
+	ops.PoolConnections.WithLabelValues("active").Set(float64(active))
+	ops.PoolConnections.WithLabelValues("idle").Set(float64(idle))
+	handshaking := 0
+	for _, n := range p.dialing {
+		handshaking += n
+	}
+	ops.PoolConnections.WithLabelValues("handshaking").Set(float64(handshaking))
+	ops.PoolEndpoints.Set(float64(len(endpoints)))
+}
+
+// setConnPeerID labels the QUIC metrics of qconn with the SPIFFE ID of its
+// peer and counts its resumption and 0-RTT outcome, the handshake must be
+// complete
+func setConnPeerID(qconn quic.Connection) {
+	state := qconn.ConnectionState()
+	ops.SetConnPeerID(qconn.Context(), peerIDFromState(state))
+	ops.SetConnHandshakeState(qconn.Context(), state)
+}
+
+// setEarlyConnPeerID calls setConnPeerID once the handshake of qconn
+// completes
+func setEarlyConnPeerID(qconn quic.EarlyConnection) {
+	go func() {
+		select {
+		case <-qconn.HandshakeComplete().Done():
+			setConnPeerID(qconn)
+		case <-qconn.Context().Done():
+		}
+	}()
+}
+
+func peerIDFromConn(conn quic.Connection) string {
+	return peerIDFromState(conn.ConnectionState())
+}
+
+func peerIDFromState(state quic.ConnectionState) string {
+	return peerIDFromCerts(state.TLS.ConnectionState)
+}
+
+func peerIDFromCerts(state tls.ConnectionState) string {
+	certs := state.PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+
+	id, err := identity.IDFromCert(certs[0])
+	if err != nil {
+		return ""
+	}
+
+	return id.String()
+}
+
+// poolRoundTripper sends the requests to endpoint over the pooled connections
+type poolRoundTripper struct {
+	endpoint string
+	tlsConf  *tls.Config
+	quicConf *quic.Config
+}
+
+func (rt poolRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	pc, err := pool.get(r.Context(), rt.endpoint, rt.tlsConf, rt.quicConf)
+	if err != nil {
+		return nil, err
+	}
+
+	// the URL authority is replaced by the endpoint and the original one is
+	// kept in the Host header (:authority)
+	epReq := r.Clone(r.Context())
+	if epReq.Host == "" {
+		epReq.Host = r.URL.Host
+	}
+	epReq.URL.Host = rt.endpoint
+
+	// the round tripper drops its client on any error, a canceled request
+	// included, so the connection can't be used for other requests
+	resp, err := pc.rt.RoundTrip(epReq)
+	if err != nil {
+		pool.release(pc, true)
+		return nil, err
+	}
+
+	resp.Request = r
+	resp.Body = &pooledBody{ReadCloser: resp.Body, pc: pc}
+
+	return resp, nil
+}
+
+// pooledBody gives the connection back to the pool when the response body is
+// closed
+type pooledBody struct {
+	io.ReadCloser
+	pc   *pooledConn
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() {
+		pool.release(b.pc, false)
+	})
+	return err
+}
@@ -0,0 +1,56 @@
+package conn
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestPoolRoundTripAfterCanceledRequest(t *testing.T) {
+	started := make(chan struct{}, 1)
+	addr := newTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+
+	tlsConf, err := newClientTLSConfig(nil, http3.NextProtoH3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := poolRoundTripper{endpoint: addr, tlsConf: tlsConf, quicConf: newQuicConfig(nil)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://workload.test/slow", nil)
+	if _, err := rt.RoundTrip(req); err == nil {
+		t.Fatal("canceled request succeeded")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, "https://workload.test/", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("request after a canceled one failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("got %d %q, want 200 \"ok\"", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,122 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+// NextProtoRaw is the ALPN of the raw QUIC connections when the application
+// doesn't set its own
+const NextProtoRaw = "quicsec"
+
+// Listen returns a QUIC listener on addr for applications that don't speak
+// HTTP. The connections are accepted from the clients authorized by the
+// SPIFFE authz rules for the application protocols nextProtos (NextProtoRaw
+// by default), with the keylog and the tracers (qlog, metrics) of the
+// operations config.
+func Listen(addr string, nextProtos ...string) (quic.Listener, error) {
+	keyLog, opsTracer := ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(true)
+	connLogger.Info("Listen() initialization", "addr", addr)
+
+	tlsConfig := newServerTLSConfig(keyLog)
+	tlsConfig.NextProtos = rawNextProtos(nextProtos)
+
+	ln, err := quic.ListenAddr(addr, tlsConfig, newQuicConfig(opsTracer))
+	if err != nil {
+		connLogger.Error(err, "failed to Listen for QUIC connections")
+		return nil, err
+	}
+
+	return rawListener{Listener: ln}, nil
+}
+
+// rawListener labels the metrics of the accepted connections with the
+// SPIFFE ID of the client
+type rawListener struct {
+	quic.Listener
+}
+
+func (l rawListener) Accept(ctx context.Context) (quic.Connection, error) {
+	qconn, err := l.Listener.Accept(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	setConnPeerID(qconn)
+	return qconn, nil
+}
+
+// Dial dials a QUIC connection to addr for applications that don't speak
+// HTTP, trying the static upstreams of addr first. The server is
+// authenticated and authorized with its SPIFFE ID like in Do.
+func Dial(ctx context.Context, addr string, nextProtos ...string) (quic.Connection, error) {
+	keyLog, opsTracer := ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(false)
+	connLogger.V(log.DebugLevel).Info("Dial() initialization", "addr", addr)
+
+	tlsConfig, err := newClientTLSConfig(keyLog, NextProtoRaw)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.NextProtos = rawNextProtos(nextProtos)
+
+	qconn, err := dialQuic(ctx, addr, tlsConfig, newQuicConfig(opsTracer))
+	if err != nil {
+		connLogger.Error(err, "failed to dial the QUIC connection", "addr", addr)
+		return nil, err
+	}
+
+	connLogger.V(log.DebugLevel).Info("QUIC connection established", "addr", addr, "peer_id", peerIDFromConn(qconn))
+	return qconn, nil
+}
+
+// PeerID returns the SPIFFE ID of the peer of a connection returned by
+// Listen or Dial, empty when the peer didn't present a SPIFFE certificate
+func PeerID(qconn quic.Connection) string {
+	return peerIDFromConn(qconn)
+}
+
+func rawNextProtos(nextProtos []string) []string {
+	if len(nextProtos) == 0 {
+		return []string{NextProtoRaw}
+	}
+	return nextProtos
+}
+
+// dialQuic dials remote trying the static upstreams first, the remote
+// address is resolved by the system otherwise
+func dialQuic(ctx context.Context, remote string, tlsConfig *tls.Config, quicConf *quic.Config) (quic.Connection, error) {
+	host, port, err := net.SplitHostPort(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	epAddrs, static := GetStaticEpAddresses(host, port)
+	if !static {
+		epAddrs = []string{remote}
+	}
+
+	errs := []error{}
+	for _, ep := range epAddrs {
+		qconn, err := quic.DialAddrContext(ctx, ep, keyLogPerPeer(tlsConfig), quicConf)
+		if err == nil {
+			setConnPeerID(qconn)
+			return qconn, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", ep, err))
+	}
+
+	return nil, fmt.Errorf("failed to dial %s: %v", remote, errs)
+}
@@ -0,0 +1,81 @@
+package conn
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/quicsec/quicsec/internal/testenv"
+)
+
+func TestRawLoopback(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverPeer := make(chan string, 1)
+	go func() {
+		qconn, err := ln.Accept(ctx)
+		if err != nil {
+			return
+		}
+		defer qconn.CloseWithError(0, "")
+		serverPeer <- PeerID(qconn)
+
+		str, err := qconn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		req, err := io.ReadAll(str)
+		if err != nil {
+			return
+		}
+		str.Write(append([]byte("got: "), req...))
+		str.Close()
+		<-qconn.Context().Done()
+	}()
+
+	qconn, err := Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer qconn.CloseWithError(0, "")
+
+	if proto := qconn.ConnectionState().TLS.NegotiatedProtocol; proto != NextProtoRaw {
+		t.Errorf("negotiated protocol %q, want %q", proto, NextProtoRaw)
+	}
+	if id := PeerID(qconn); id != testenv.WorkloadID {
+		t.Errorf("client: peer ID %q, want %q", id, testenv.WorkloadID)
+	}
+
+	str, err := qconn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := str.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	str.Close()
+	rsp, err := io.ReadAll(str)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp) != "got: ping" {
+		t.Errorf("got %q, want %q", rsp, "got: ping")
+	}
+
+	select {
+	case id := <-serverPeer:
+		if id != testenv.WorkloadID {
+			t.Errorf("server: peer ID %q, want %q", id, testenv.WorkloadID)
+		}
+	case <-ctx.Done():
+		t.Fatal("connection not accepted")
+	}
+}
@@ -0,0 +1,292 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/quicsec/quicsec/auth"
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+// NextProtoTunnel is the ALPN of the QUIC connections carrying TCP
+// connections, one bidirectional stream per TCP connection
+const NextProtoTunnel = "quicsec-tcp"
+
+// tunnelKeepAlivePeriod is used when quic.keep_alive_period is not
+// configured, so idle TCP connections (e.g. database connection pools)
+// don't make the QUIC connection time out
+const tunnelKeepAlivePeriod = 15 * time.Second
+
+// tunnelErrorCode resets the streams of the TCP connections closed with
+// errors
+const tunnelErrorCode quic.StreamErrorCode = 0x1
+
+const tunnelTargetDialTimeout = 10 * time.Second
+
+func newTunnelQuicConfig() *quic.Config {
+	_, opsTracer := ops.OperationsInit()
+
+	quicConf := newQuicConfig(opsTracer)
+	if quicConf.KeepAlivePeriod == 0 {
+		quicConf.KeepAlivePeriod = tunnelKeepAlivePeriod
+	}
+
+	return quicConf
+}
+
+// ListenAndServeTunnel accepts QUIC connections on addr from the clients
+// authorized by the SPIFFE authz rules and forwards each stream to a new TCP
+// connection to target. The clients have to present an authorized SPIFFE
+// certificate even when mTLS is disabled, the target (e.g. a database) is
+// never exposed to anonymous clients.
+func ListenAndServeTunnel(addr, target string) error {
+	ln, err := listenTunnel(addr, target)
+	if err != nil {
+		return err
+	}
+	defer ln.Close()
+
+	return serveTunnel(ln, target)
+}
+
+func listenTunnel(addr, target string) (quic.Listener, error) {
+	keyLog, _ := ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(true)
+	connLogger.Info("ListenAndServeTunnel() initialization", "addr", addr, "target", target)
+
+	tlsConfig := newServerTLSConfig(keyLog)
+	tlsConfig.NextProtos = []string{NextProtoTunnel}
+	tlsConfig.ClientAuth = tls.RequireAnyClientCert
+	tlsConfig.VerifyPeerCertificate = auth.RequireAuthorizedPeerCertificate
+	tlsConfig.VerifyConnection = auth.WrapVerifyConnection(tlsConfig.VerifyPeerCertificate)
+
+	ln, err := quic.ListenAddr(addr, tlsConfig, newTunnelQuicConfig())
+	if err != nil {
+		connLogger.Error(err, "failed to Listen for QUIC connections")
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+func serveTunnel(ln quic.Listener, target string) error {
+	for {
+		qconn, err := ln.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+
+		setConnPeerID(qconn)
+		go serveTunnelConn(qconn, target)
+	}
+}
+
+func serveTunnelConn(qconn quic.Connection, target string) {
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	peerID := peerIDFromConn(qconn)
+
+	for {
+		str, err := qconn.AcceptStream(context.Background())
+		if err != nil {
+			connLogger.V(log.DebugLevel).Info("tunnel connection closed", "peer_id", peerID, "reason", err.Error())
+			return
+		}
+
+		go func() {
+			start := time.Now()
+
+			tcpConn, err := net.DialTimeout("tcp", target, tunnelTargetDialTimeout)
+			if err != nil {
+				connLogger.Error(err, "failed to dial the tunnel target", "peer_id", peerID, "target", target)
+				str.CancelRead(tunnelErrorCode)
+				str.CancelWrite(tunnelErrorCode)
+				observeTunnel("incoming", false, 0, 0, start)
+				return
+			}
+
+			sent, rcvd := pipeTunnel(tcpConn.(*net.TCPConn), str)
+			observeTunnel("incoming", true, sent, rcvd, start)
+			connLogger.Info("tunneled connection closed", "peer_id", peerID, "target", target,
+				"sent", sent, "rcvd", rcvd, "duration", time.Since(start).Seconds())
+		}()
+	}
+}
+
+// ListenAndForwardTunnel accepts TCP connections on addr and forwards each
+// of them to remote over a bidirectional stream of a QUIC connection. The
+// QUIC connection is shared by all the TCP connections and dialed again when
+// it is closed.
+func ListenAndForwardTunnel(addr, remote string) error {
+	ops.OperationsInit()
+	connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+	config.SetServerSideFlag(false)
+	connLogger.Info("ListenAndForwardTunnel() initialization", "addr", addr, "remote", remote)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		connLogger.Error(err, "failed to Listen at TCP address")
+		return err
+	}
+	defer ln.Close()
+
+	d := &tunnelDialer{remote: remote, nextProto: NextProtoTunnel}
+
+	for {
+		tcpConn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			start := time.Now()
+
+			str, qconn, err := d.openStream(context.Background())
+			if err != nil {
+				connLogger.Error(err, "failed to open the tunnel stream", "remote", remote)
+				tcpConn.Close()
+				observeTunnel("outgoing", false, 0, 0, start)
+				return
+			}
+
+			sent, rcvd := pipeTunnel(tcpConn.(*net.TCPConn), str)
+			observeTunnel("outgoing", true, sent, rcvd, start)
+			connLogger.Info("tunneled connection closed", "peer_id", peerIDFromConn(qconn), "remote", remote,
+				"sent", sent, "rcvd", rcvd, "duration", time.Since(start).Seconds())
+		}()
+	}
+}
+
+// tunnelDialer opens the streams of a QUIC connection to remote shared by
+// all the tunneled connections
+type tunnelDialer struct {
+	remote    string
+	nextProto string
+
+	mutex sync.Mutex
+	qconn quic.Connection
+
+	// the streams opened during a dial wait for that dial, without holding
+	// the mutex
+	dials singleflight.Group
+}
+
+func (d *tunnelDialer) openStream(ctx context.Context) (quic.Stream, quic.Connection, error) {
+	qconn, err := d.conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	str, err := qconn.OpenStreamSync(ctx)
+	return str, qconn, err
+}
+
+// conn returns the shared QUIC connection, dialed again when it is closed
+func (d *tunnelDialer) conn(ctx context.Context) (quic.Connection, error) {
+	d.mutex.Lock()
+	qconn := d.qconn
+	d.mutex.Unlock()
+	if qconn != nil && qconn.Context().Err() == nil {
+		return qconn, nil
+	}
+
+	// the dial is shared, it isn't canceled with the context of the stream
+	// starting it (bounded by the QUIC handshake timeout)
+	dial := d.dials.DoChan("", func() (interface{}, error) {
+		qconn, err := dialTunnel(context.Background(), d.remote, d.nextProto)
+		if err != nil {
+			return nil, err
+		}
+
+		d.mutex.Lock()
+		d.qconn = qconn
+		d.mutex.Unlock()
+
+		return qconn, nil
+	})
+
+	select {
+	case res := <-dial:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(quic.Connection), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// dialTunnel dials remote trying the static upstreams first, the remote
+// address is resolved by the system otherwise
+func dialTunnel(ctx context.Context, remote, nextProto string) (quic.Connection, error) {
+	keyLog, _ := ops.OperationsInit()
+
+	tlsConfig, err := newClientTLSConfig(keyLog, nextProto)
+	if err != nil {
+		return nil, err
+	}
+
+	return dialQuic(ctx, remote, tlsConfig, newTunnelQuicConfig())
+}
+
+// pipeTunnel copies the data between the TCP connection and the stream in
+// both directions. The end of the data is propagated as a half close, errors
+// reset the stream or close the TCP connection. It returns the bytes sent
+// (TCP to QUIC) and received (QUIC to TCP).
+func pipeTunnel(tcpConn *net.TCPConn, str quic.Stream) (int64, int64) {
+	var rcvd int64
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		var err error
+		rcvd, err = io.Copy(tcpConn, str)
+		if err != nil {
+			str.CancelRead(tunnelErrorCode)
+			tcpConn.Close()
+			return
+		}
+		tcpConn.CloseWrite()
+	}()
+
+	sent, err := io.Copy(str, tcpConn)
+	if err != nil {
+		str.CancelWrite(tunnelErrorCode)
+	} else {
+		str.Close()
+	}
+
+	<-done
+	tcpConn.Close()
+
+	return sent, rcvd
+}
+
+func observeTunnel(direction string, success bool, sent, rcvd int64, start time.Time) {
+	if !config.GetMetricsEnabled() {
+		return
+	}
+
+	status := "success"
+	if !success {
+		status = "failure"
+	}
+	ops.TunnelConns.WithLabelValues(direction, status).Inc()
+
+	if success {
+		ops.TunnelBytes.WithLabelValues(direction, "sent").Add(float64(sent))
+		ops.TunnelBytes.WithLabelValues(direction, "rcvd").Add(float64(rcvd))
+		ops.TunnelHistogramDuration.WithLabelValues(direction).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,189 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/quicsec/quicsec/internal/testenv"
+)
+
+// newTunnelTarget listens for the tunneled TCP connections: it reads the
+// request until the half close of the client, answers and closes the
+// connection. It returns the address and the number of accepted connections.
+func newTunnelTarget(t *testing.T) (string, *int32) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var accepted int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+
+			go func() {
+				defer c.Close()
+				req, err := io.ReadAll(c)
+				if err != nil {
+					return
+				}
+				c.Write(append([]byte("got: "), req...))
+			}()
+		}
+	}()
+
+	return ln.Addr().String(), &accepted
+}
+
+// newTunnelServer tunnels the streams of the QUIC connections to target and
+// returns the QUIC address
+func newTunnelServer(t *testing.T, target string) string {
+	t.Helper()
+
+	ln, err := listenTunnel("127.0.0.1:0", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveTunnel(ln, target)
+	t.Cleanup(func() { ln.Close() })
+
+	return ln.Addr().String()
+}
+
+func TestTunnel(t *testing.T) {
+	target, accepted := newTunnelTarget(t)
+	remote := newTunnelServer(t, target)
+
+	// the client side of ListenAndForwardTunnel
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	d := &tunnelDialer{remote: remote, nextProto: NextProtoTunnel}
+	defer func() {
+		if d.qconn != nil {
+			d.qconn.CloseWithError(0, "")
+		}
+	}()
+
+	type piped struct{ sent, rcvd int64 }
+	done := make(chan piped, 1)
+	go func() {
+		tcpConn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		str, _, err := d.openStream(context.Background())
+		if err != nil {
+			t.Error(err)
+			tcpConn.Close()
+			return
+		}
+		sent, rcvd := pipeTunnel(tcpConn.(*net.TCPConn), str)
+		done <- piped{sent, rcvd}
+	}()
+
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	// the target only answers once the end of the request is tunneled
+	if err := c.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	// and the answer ends with the close of the target connection
+	rsp, err := io.ReadAll(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rsp) != "got: ping" {
+		t.Errorf("got %q, want %q", rsp, "got: ping")
+	}
+
+	select {
+	case p := <-done:
+		if p.sent != 4 || p.rcvd != 9 {
+			t.Errorf("sent %d and received %d bytes, want 4 and 9", p.sent, p.rcvd)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel not closed")
+	}
+	if n := atomic.LoadInt32(accepted); n != 1 {
+		t.Errorf("target accepted %d connections, want 1", n)
+	}
+}
+
+func TestTunnelUnauthorizedClient(t *testing.T) {
+	target, accepted := newTunnelTarget(t)
+	remote := newTunnelServer(t, target)
+
+	intruder, err := testEnv.NewCert(testenv.IntruderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		certs []tls.Certificate
+	}{
+		{"identity without authz rule", []tls.Certificate{intruder}},
+		{"no client certificate", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConf, err := newClientTLSConfig(nil, NextProtoTunnel)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tlsConf.Certificates = tt.certs
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			// the server verifies the client certificate after the client
+			// completes the handshake: the refusal closes the connection
+			qconn, err := quic.DialAddrContext(ctx, remote, tlsConf, newTunnelQuicConfig())
+			if err == nil {
+				defer qconn.CloseWithError(0, "")
+				var str quic.Stream
+				if str, err = qconn.OpenStreamSync(ctx); err == nil {
+					str.Write([]byte("ping"))
+					str.Close()
+					_, err = io.ReadAll(str)
+				}
+			}
+			if err == nil {
+				t.Fatal("unauthorized client tunneled a connection")
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("connection not refused: %v", err)
+			}
+		})
+	}
+
+	if n := atomic.LoadInt32(accepted); n != 0 {
+		t.Errorf("target accepted %d connections, want 0", n)
+	}
+}
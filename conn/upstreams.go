@@ -0,0 +1,83 @@
+package conn
+
+import (
+	"math/rand"
+	"net"
+	"sort"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+const defaultUpstreamPort = "443"
+
+// GetStaticEpAddresses returns the static endpoints configured for domain
+// (core config "upstreams" section or hosts file). They are consulted before
+// any DNS lookup. The endpoints are ordered by priority and, inside the same
+// priority, shuffled according to their weights. The port is used for the
+// endpoints configured without one.
+func GetStaticEpAddresses(domain, port string) ([]string, bool) {
+	eps := config.GetUpstreams(domain)
+	if len(eps) == 0 {
+		return nil, false
+	}
+
+	if port == "" {
+		port = defaultUpstreamPort
+	}
+
+	var endpoints []string
+	for _, ep := range orderUpstreams(eps) {
+		addr := ep.Address
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, port)
+		}
+		endpoints = append(endpoints, addr)
+	}
+
+	return endpoints, true
+}
+
+// orderUpstreams sorts the endpoints by priority (lowest first) and does a
+// weighted shuffle of the endpoints sharing the same priority
+func orderUpstreams(eps []config.UpstreamEndpoint) []config.UpstreamEndpoint {
+	byPriority := make(map[int][]config.UpstreamEndpoint)
+	var priorities []int
+	for _, ep := range eps {
+		if _, ok := byPriority[ep.Priority]; !ok {
+			priorities = append(priorities, ep.Priority)
+		}
+		byPriority[ep.Priority] = append(byPriority[ep.Priority], ep)
+	}
+	sort.Ints(priorities)
+
+	ordered := make([]config.UpstreamEndpoint, 0, len(eps))
+	for _, priority := range priorities {
+		ordered = append(ordered, weightedShuffle(byPriority[priority])...)
+	}
+
+	return ordered
+}
+
+func weightedShuffle(eps []config.UpstreamEndpoint) []config.UpstreamEndpoint {
+	remaining := append([]config.UpstreamEndpoint(nil), eps...)
+	shuffled := make([]config.UpstreamEndpoint, 0, len(eps))
+
+	for len(remaining) > 0 {
+		total := 0
+		for _, ep := range remaining {
+			total += ep.Weight
+		}
+
+		pick := rand.Intn(total)
+		for i, ep := range remaining {
+			pick -= ep.Weight
+			if pick < 0 {
+				shuffled = append(shuffled, ep)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+
+	return shuffled
+}
@@ -0,0 +1,46 @@
+package conn
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetStaticEpAddresses(t *testing.T) {
+	if _, static := GetStaticEpAddresses("library", "443"); static {
+		t.Error("static endpoints for a name without upstreams")
+	}
+
+	// 10.0.0.5 (weight 3) and 10.0.0.6 (weight 1) share the first priority,
+	// 10.0.0.7 is always last
+	const runs = 4000
+	first := make(map[string]int)
+	for i := 0; i < runs; i++ {
+		addrs, static := GetStaticEpAddresses("Bookstore", "")
+		if !static || len(addrs) != 3 {
+			t.Fatalf("GetStaticEpAddresses() = %v, %t, want 3 static endpoints", addrs, static)
+		}
+
+		if addrs[2] != "10.0.0.7:14001" {
+			t.Fatalf("GetStaticEpAddresses() = %v, want the priority 2 endpoint last", addrs)
+		}
+		samePriority := []string{addrs[0], addrs[1]}
+		if !reflect.DeepEqual(samePriority, []string{"10.0.0.5:14001", "10.0.0.6:443"}) &&
+			!reflect.DeepEqual(samePriority, []string{"10.0.0.6:443", "10.0.0.5:14001"}) {
+			t.Fatalf("GetStaticEpAddresses() = %v, want the priority 1 endpoints first", addrs)
+		}
+		first[addrs[0]]++
+	}
+
+	// 10.0.0.5 comes first 3 times out of 4: 3000 ± 5 standard deviations
+	if n := first["10.0.0.5:14001"]; n < 2860 || n > 3140 {
+		t.Errorf("10.0.0.5 first %d times out of %d, want about %d", n, runs, runs*3/4)
+	}
+
+	addrs, _ := GetStaticEpAddresses("bookstore", "8443")
+	for _, addr := range addrs {
+		if addr == "10.0.0.6:8443" {
+			return
+		}
+	}
+	t.Errorf("GetStaticEpAddresses() = %v, want the port of the request for 10.0.0.6", addrs)
+}
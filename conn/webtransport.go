@@ -0,0 +1,292 @@
+package conn
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+
+	"github.com/quicsec/quicsec/auth"
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/spiffeid"
+
+	ops "github.com/quicsec/quicsec/operations"
+)
+
+const protocolWebTransport = "webtransport"
+
+// WebTransportHandler serves a WebTransport session. The session is closed
+// by the handler (CloseWithError) or by the peer, not when the handler
+// returns.
+type WebTransportHandler func(*WebTransportSession)
+
+var webTransportHandlers = struct {
+	sync.RWMutex
+	m map[string]WebTransportHandler
+}{m: make(map[string]WebTransportHandler)}
+
+// HandleWebTransport registers the handler of the WebTransport sessions
+// established on path. The sessions are only accepted when
+// webtransport.enable is set.
+func HandleWebTransport(path string, handler WebTransportHandler) {
+	webTransportHandlers.Lock()
+	defer webTransportHandlers.Unlock()
+
+	webTransportHandlers.m[path] = handler
+}
+
+func getWebTransportHandler(path string) (WebTransportHandler, bool) {
+	webTransportHandlers.RLock()
+	defer webTransportHandlers.RUnlock()
+
+	h, ok := webTransportHandlers.m[path]
+	return h, ok
+}
+
+// WebTransportSession is a WebTransport session authorized by the SPIFFE
+// authz rules and the request authorizer. The streams opened and accepted
+// through it are counted in the metrics.
+type WebTransportSession struct {
+	*webtransport.Session
+
+	peerID    spiffeid.ID
+	path      string
+	datagrams *Datagrams
+}
+
+// PeerID returns the SPIFFE ID of the peer, zero when the peer didn't
+// present a SPIFFE certificate (mTLS disabled)
+func (s *WebTransportSession) PeerID() spiffeid.ID {
+	return s.peerID
+}
+
+func (s *WebTransportSession) AcceptStream(ctx context.Context) (webtransport.Stream, error) {
+	str, err := s.Session.AcceptStream(ctx)
+	if err == nil {
+		s.observeStream("bidi", "incoming")
+	}
+	return str, err
+}
+
+func (s *WebTransportSession) AcceptUniStream(ctx context.Context) (webtransport.ReceiveStream, error) {
+	str, err := s.Session.AcceptUniStream(ctx)
+	if err == nil {
+		s.observeStream("uni", "incoming")
+	}
+	return str, err
+}
+
+func (s *WebTransportSession) OpenStream() (webtransport.Stream, error) {
+	str, err := s.Session.OpenStream()
+	if err == nil {
+		s.observeStream("bidi", "outgoing")
+	}
+	return str, err
+}
+
+func (s *WebTransportSession) OpenStreamSync(ctx context.Context) (webtransport.Stream, error) {
+	str, err := s.Session.OpenStreamSync(ctx)
+	if err == nil {
+		s.observeStream("bidi", "outgoing")
+	}
+	return str, err
+}
+
+func (s *WebTransportSession) OpenUniStream() (webtransport.SendStream, error) {
+	str, err := s.Session.OpenUniStream()
+	if err == nil {
+		s.observeStream("uni", "outgoing")
+	}
+	return str, err
+}
+
+func (s *WebTransportSession) OpenUniStreamSync(ctx context.Context) (webtransport.SendStream, error) {
+	str, err := s.Session.OpenUniStreamSync(ctx)
+	if err == nil {
+		s.observeStream("uni", "outgoing")
+	}
+	return str, err
+}
+
+// SendDatagram sends b as a datagram of the session, within the datagram
+// rate limit of the peer identity
+func (s *WebTransportSession) SendDatagram(b []byte) error {
+	if s.datagrams == nil {
+		return errDatagramsNotSupported
+	}
+	return s.datagrams.Send(b)
+}
+
+// ReceiveDatagram returns the next datagram of the session
+func (s *WebTransportSession) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	if s.datagrams == nil {
+		return nil, errDatagramsNotSupported
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.Context().Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return s.datagrams.Receive(ctx)
+}
+
+func (s *WebTransportSession) observeStream(streamType, direction string) {
+	if config.GetMetricsEnabled() {
+		ops.WebTransportStreams.WithLabelValues(s.path, streamType, direction).Inc()
+	}
+}
+
+// newWebTransportServer returns the WebTransport server, nil when
+// WebTransport is disabled. Its H3 field is the http3 server to set up.
+func newWebTransportServer() *webtransport.Server {
+	if !config.GetWebTransportConfig().Enable {
+		return nil
+	}
+
+	s := &webtransport.Server{}
+	if origins := config.GetWebTransportConfig().AllowedOrigins; len(origins) > 0 {
+		s.CheckOrigin = func(r *http.Request) bool {
+			return checkWebTransportOrigin(r, origins)
+		}
+	}
+
+	return s
+}
+
+// webTransportSettings adds the HTTP/3 settings required by WebTransport to
+// settings (the WebTransport setting itself is added by webtransport-go)
+func webTransportSettings(wt *webtransport.Server, settings map[uint64]uint64) map[uint64]uint64 {
+	if wt == nil {
+		return settings
+	}
+
+	if settings == nil {
+		settings = make(map[uint64]uint64)
+	}
+	settings[settingEnableConnectProtocol] = 1
+
+	return settings
+}
+
+// serveWebTransport serves the connections accepted by ln with s: the
+// webtransport.Server is only initialized by its Serve methods
+func serveWebTransport(s *webtransport.Server, ln quic.EarlyListener) error {
+	for {
+		qconn, err := ln.Accept(context.Background())
+		if err != nil {
+			return err
+		}
+
+		go s.ServeQUICConn(qconn)
+	}
+}
+
+func checkWebTransportOrigin(r *http.Request, origins []string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) || strings.EqualFold(o, u.Host) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// webTransportHandler establishes the WebTransport sessions (extended
+// CONNECT with the "webtransport" protocol) on the registered paths. The
+// other requests are served by handler.
+func webTransportHandler(wt *webtransport.Server, handler http.Handler) http.Handler {
+	if wt == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.Proto != protocolWebTransport {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		connLogger := log.LoggerLgr.WithName(log.ConstConnManager)
+		path := r.URL.Path
+
+		h, ok := getWebTransportHandler(path)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		peerID, err := auth.AuthorizeRequest(r)
+		if err != nil {
+			observeWebTransportSession(path, "unauthorized")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		// webtransport-go needs the http.Flusher and http3.Hijacker of the
+		// server response writer
+		raw := rawResponseWriter(w)
+		sess, err := wt.Upgrade(raw, r)
+		if err != nil {
+			connLogger.Error(err, "failed to upgrade to WebTransport", "path", path, "peer_id", peerID.String())
+			observeWebTransportSession(path, "failure")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		observeWebTransportSession(path, "accepted")
+
+		s := &WebTransportSession{
+			Session: sess,
+			peerID:  peerID,
+			path:    path,
+		}
+		if dconn, ok := raw.(http3.Hijacker).StreamCreator().(datagramConn); ok {
+			if demux, err := getDatagramDemux(dconn); err == nil {
+				s.datagrams = newStreamDatagrams(demux, r.Body.(http3.HTTPStreamer).HTTPStream().StreamID())
+			}
+		}
+
+		start := time.Now()
+		go func() {
+			<-sess.Context().Done()
+			if s.datagrams != nil {
+				s.datagrams.Close()
+			}
+			if config.GetMetricsEnabled() {
+				ops.WebTransportHistogramSessionDuration.WithLabelValues(path).Observe(time.Since(start).Seconds())
+			}
+			connLogger.V(log.DebugLevel).Info("WebTransport session closed", "path", path, "peer_id", peerID.String(),
+				"duration", time.Since(start).Seconds())
+		}()
+
+		connLogger.V(log.DebugLevel).Info("WebTransport session accepted", "path", path, "peer_id", peerID.String())
+		h(s)
+	})
+}
+
+func observeWebTransportSession(path, status string) {
+	if config.GetMetricsEnabled() {
+		ops.WebTransportSessions.WithLabelValues(path, status).Inc()
+	}
+}
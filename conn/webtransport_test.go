@@ -0,0 +1,183 @@
+package conn
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+
+	"github.com/quicsec/quicsec/auth"
+	"github.com/quicsec/quicsec/internal/testenv"
+	"github.com/quicsec/quicsec/spiffeid"
+)
+
+func TestCheckWebTransportOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		origin  string
+		origins []string
+		want    bool
+	}{
+		{"no origin header", "", nil, true},
+		{"no allowed origins", "https://app.example.org", nil, false},
+		{"wildcard", "https://app.example.org", []string{"*"}, true},
+		{"exact origin", "https://app.example.org", []string{"https://app.example.org"}, true},
+		{"origin case", "https://APP.example.org", []string{"https://app.example.org"}, true},
+		{"host only", "https://app.example.org:4433", []string{"app.example.org:4433"}, true},
+		{"host without the port", "https://app.example.org:4433", []string{"app.example.org"}, false},
+		{"other scheme", "http://app.example.org", []string{"https://app.example.org"}, false},
+		{"other host", "https://evil.example.org", []string{"https://app.example.org", "app.example.org"}, false},
+		{"suffix of an allowed host", "https://app.example.org.evil.com", []string{"app.example.org"}, false},
+		{"second allowed origin", "https://b.example.org", []string{"https://a.example.org", "https://b.example.org"}, true},
+		{"invalid origin", "https://[::1", []string{"*"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodConnect, "https://localhost/wt", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := checkWebTransportOrigin(r, tt.origins); got != tt.want {
+				t.Errorf("checkWebTransportOrigin(%q, %q) = %t, want %t", tt.origin, tt.origins, got, tt.want)
+			}
+		})
+	}
+}
+
+// newWebTransportTestServer serves the WebTransport sessions on a loopback
+// address and returns the address. The handshake only verifies that the
+// client certificate is issued by the test CA, so the identities without
+// authz rule reach the session authorization.
+func newWebTransportTestServer(t *testing.T) string {
+	t.Helper()
+
+	cert, err := testEnv.NewCert(testenv.WorkloadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := http3.ConfigureTLSConfig(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    testEnv.CAPool,
+	})
+	quicConf := newQuicConfig(nil)
+	quicConf.EnableDatagrams = true
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := quic.ListenEarly(udpConn, tlsConf, quicConf)
+	if err != nil {
+		udpConn.Close()
+		t.Fatal(err)
+	}
+
+	wt := &webtransport.Server{}
+	wt.H3 = http3.Server{
+		Handler:            webTransportHandler(wt, http.NotFoundHandler()),
+		QuicConfig:         quicConf,
+		EnableDatagrams:    true,
+		AdditionalSettings: webTransportSettings(wt, nil),
+	}
+	go serveWebTransport(wt, ln)
+	t.Cleanup(func() {
+		wt.Close()
+		ln.Close()
+		udpConn.Close()
+	})
+
+	return udpConn.LocalAddr().String()
+}
+
+func TestWebTransportSessionAuthorization(t *testing.T) {
+	addr := newWebTransportTestServer(t)
+
+	sessions := make(chan spiffeid.ID, 1)
+	HandleWebTransport("/wt", func(s *WebTransportSession) {
+		sessions <- s.PeerID()
+		s.CloseWithError(0, "")
+	})
+
+	denied := errors.New("token required")
+	tests := []struct {
+		name       string
+		clientID   string
+		authorizer auth.RequestAuthorizer
+		wantStatus int
+	}{
+		{"authorized identity", testenv.WorkloadID, nil, http.StatusOK},
+		{"identity without authz rule", testenv.IntruderID, nil, http.StatusForbidden},
+		{"authorizer allows", testenv.WorkloadID, func(*http.Request, spiffeid.ID) error { return nil }, http.StatusOK},
+		{"authorizer denies", testenv.WorkloadID, func(*http.Request, spiffeid.ID) error { return denied }, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authorized := make(chan string, 1)
+			if tt.authorizer != nil {
+				auth.SetRequestAuthorizer(func(r *http.Request, peerID spiffeid.ID) error {
+					authorized <- r.URL.Path + " " + peerID.String()
+					return tt.authorizer(r, peerID)
+				})
+				defer auth.SetRequestAuthorizer(nil)
+			}
+
+			cert, err := testEnv.NewCert(tt.clientID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := &webtransport.Dialer{RoundTripper: &http3.RoundTripper{
+				TLSClientConfig: &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: testEnv.CAPool},
+			}}
+			defer d.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			rsp, sess, err := d.Dial(ctx, "https://"+addr+"/wt", nil)
+			if rsp == nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			if rsp.StatusCode != tt.wantStatus {
+				t.Fatalf("status %d, want %d", rsp.StatusCode, tt.wantStatus)
+			}
+
+			if tt.wantStatus == http.StatusOK {
+				defer sess.CloseWithError(0, "")
+				select {
+				case id := <-sessions:
+					if id.String() != tt.clientID {
+						t.Errorf("session peer ID %s, want %s", id, tt.clientID)
+					}
+				case <-ctx.Done():
+					t.Fatal("session not handled")
+				}
+			}
+
+			if tt.authorizer != nil {
+				select {
+				case got := <-authorized:
+					if want := "/wt " + tt.clientID; got != want {
+						t.Errorf("authorizer called with %q, want %q", got, want)
+					}
+				default:
+					t.Error("authorizer not called")
+				}
+			}
+		})
+	}
+
+	select {
+	case id := <-sessions:
+		t.Errorf("unexpected session of %s", id)
+	default:
+	}
+}
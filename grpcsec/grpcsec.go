@@ -0,0 +1,149 @@
+// Package grpcsec runs gRPC over the QuicSec transport: the gRPC (HTTP/2)
+// connections are carried by the bidirectional streams of QUIC connections
+// authenticated and authorized with SPIFFE mTLS, with a fallback to TLS over
+// TCP on the same address for the clients that can't reach the server over
+// QUIC.
+package grpcsec
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/quicsec/quicsec/conn"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/spiffeid"
+)
+
+// AuthType is the auth type of the AuthInfo of the QuicSec connections
+const AuthType = "quicsec"
+
+const (
+	TransportQUIC = "quic"
+	TransportTLS  = "tls"
+)
+
+var errUnexpectedConn = errors.New("grpcsec: connection not established by QuicSec")
+
+// AuthInfo is the peer.AuthInfo of the gRPC connections established by
+// QuicSec
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+
+	// PeerID is the SPIFFE ID of the peer, zero when the peer didn't
+	// present a SPIFFE certificate (mTLS disabled)
+	PeerID spiffeid.ID
+	// Transport is TransportQUIC or TransportTLS
+	Transport string
+	State     tls.ConnectionState
+}
+
+func (AuthInfo) AuthType() string {
+	return AuthType
+}
+
+// PeerID returns the SPIFFE ID of the peer of the gRPC call ctx, for the
+// interceptors and the handlers
+func PeerID(ctx context.Context) (spiffeid.ID, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return spiffeid.ID{}, false
+	}
+
+	info, ok := p.AuthInfo.(AuthInfo)
+	if !ok || info.PeerID.IsZero() {
+		return spiffeid.ID{}, false
+	}
+
+	return info.PeerID, true
+}
+
+// Listen returns the listener of a gRPC server on addr (QUIC and TLS over
+// TCP), to serve with the ServerOption credentials
+func Listen(addr string) (net.Listener, error) {
+	return conn.ListenGRPC(addr)
+}
+
+// ServerOption returns the credentials of the gRPC servers serving the
+// listener returned by Listen
+func ServerOption() grpc.ServerOption {
+	return grpc.Creds(NewCredentials())
+}
+
+// DialOptions returns the dialer and the credentials of the gRPC clients
+func DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithContextDialer(conn.DialGRPC),
+		grpc.WithTransportCredentials(NewCredentials()),
+	}
+}
+
+type transportCredentials struct {
+	serverName string
+}
+
+// NewCredentials returns the gRPC transport credentials of the connections
+// established by QuicSec. The SPIFFE authentication and authorization
+// happen in the QUIC or TLS handshake; the credentials complete the TLS
+// handshake and expose the peer identity as AuthInfo.
+func NewCredentials() credentials.TransportCredentials {
+	return &transportCredentials{}
+}
+
+func (c *transportCredentials) ClientHandshake(ctx context.Context, _ string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return handshake(ctx, rawConn)
+}
+
+func (c *transportCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return handshake(context.Background(), rawConn)
+}
+
+func (c *transportCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{
+		SecurityProtocol: AuthType,
+		ServerName:       c.serverName,
+	}
+}
+
+func (c *transportCredentials) Clone() credentials.TransportCredentials {
+	return &transportCredentials{serverName: c.serverName}
+}
+
+func (c *transportCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
+
+func handshake(ctx context.Context, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	info := AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}
+
+	switch c := rawConn.(type) {
+	case *conn.StreamConn:
+		info.Transport = TransportQUIC
+		info.State = c.Connection().ConnectionState().TLS.ConnectionState
+	case *tls.Conn:
+		if err := c.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, nil, err
+		}
+		info.Transport = TransportTLS
+		info.State = c.ConnectionState()
+	default:
+		return nil, nil, errUnexpectedConn
+	}
+
+	if len(info.State.PeerCertificates) > 0 {
+		if id, err := identity.IDFromCert(info.State.PeerCertificates[0]); err == nil {
+			info.PeerID = id
+		}
+	}
+
+	return rawConn, info, nil
+}
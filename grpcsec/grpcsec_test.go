@@ -0,0 +1,131 @@
+package grpcsec
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+
+	"github.com/quicsec/quicsec/internal/testenv"
+)
+
+func TestMain(m *testing.M) {
+	// the clients fall back to TLS once the QUIC handshake times out
+	env, err := testenv.Setup(nil, map[string]string{"QUICSEC_QUIC_HANDSHAKE_TIMEOUT": "1s"})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	env.Close()
+	os.Exit(code)
+}
+
+// listenSamePort listens on the same UDP and TCP port of the loopback, so
+// the clients reach the QUIC listener
+func listenSamePort(t *testing.T) net.Listener {
+	t.Helper()
+
+	var err error
+	for i := 0; i < 10; i++ {
+		var tcpLn net.Listener
+		tcpLn, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := tcpLn.Addr().String()
+		tcpLn.Close()
+
+		var ln net.Listener
+		if ln, err = Listen(addr); err == nil {
+			return ln
+		}
+	}
+	t.Fatal(err)
+	return nil
+}
+
+// listenOtherPorts listens on different UDP and TCP ports: the clients
+// dialing the TCP address can't reach the QUIC listener
+func listenOtherPorts(t *testing.T) net.Listener {
+	t.Helper()
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+func TestCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		listen    func(*testing.T) net.Listener
+		transport string
+	}{
+		{"quic", listenSamePort, TransportQUIC},
+		{"tls fallback", listenOtherPorts, TransportTLS},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln := tt.listen(t)
+
+			serverPeer := make(chan AuthInfo, 1)
+			s := grpc.NewServer(ServerOption(), grpc.UnaryInterceptor(
+				func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+					p, _ := peer.FromContext(ctx)
+					id, ok := PeerID(ctx)
+					if !ok {
+						t.Error("server: no peer ID")
+					} else if id.String() != testenv.WorkloadID {
+						t.Errorf("server: peer ID %s, want %s", id, testenv.WorkloadID)
+					}
+					serverPeer <- p.AuthInfo.(AuthInfo)
+					return handler(ctx, req)
+				}))
+			healthpb.RegisterHealthServer(s, health.NewServer())
+			go s.Serve(ln)
+			defer s.Stop()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cc, err := grpc.DialContext(ctx, ln.Addr().String(), DialOptions()...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cc.Close()
+
+			var p peer.Peer
+			resp, err := healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Peer(&p))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != healthpb.HealthCheckResponse_SERVING {
+				t.Errorf("status %s, want SERVING", resp.Status)
+			}
+
+			if info := <-serverPeer; info.Transport != tt.transport {
+				t.Errorf("server: transport %s, want %s", info.Transport, tt.transport)
+			}
+			clientInfo, ok := p.AuthInfo.(AuthInfo)
+			if !ok {
+				t.Fatalf("client: auth info %T, want AuthInfo", p.AuthInfo)
+			}
+			if clientInfo.Transport != tt.transport {
+				t.Errorf("client: transport %s, want %s", clientInfo.Transport, tt.transport)
+			}
+			if clientInfo.PeerID.String() != testenv.WorkloadID {
+				t.Errorf("client: peer ID %s, want %s", clientInfo.PeerID, testenv.WorkloadID)
+			}
+		})
+	}
+}
@@ -7,13 +7,21 @@ import (
 	"fmt"
 	"io/ioutil"
 	"strings"
+	"sync"
 
 	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/audit"
 	"github.com/quicsec/quicsec/operations/log"
 	"github.com/quicsec/quicsec/spiffeid"
 )
 
 func VerifyIdentity(uri string) bool {
+	_, ok := MatchIdentity(uri)
+	return ok
+}
+
+// MatchIdentity returns the authz rule authorizing uri, if any
+func MatchIdentity(uri string) (string, bool) {
 	var AuthIDs []string
 	AuthIDs = config.GetLastAuthRules()
 
@@ -21,11 +29,11 @@ func VerifyIdentity(uri string) bool {
 		v := strings.EqualFold(uri, id)
 
 		if v {
-			return true
+			return id, true
 		}
 	}
 
-	return false
+	return "", false
 }
 
 func GetCurrentIdentity() (spiffeid.ID, error) {
@@ -63,11 +71,63 @@ func GetCert() (*tls.Certificate, error) {
 
 	if err != nil {
 		err = fmt.Errorf("failed trying to load x509 key pair %v", err)
+	} else {
+		recordIdentityChange(&cert)
 	}
 
 	return &cert, err
 }
 
+// the local identity certificate seen in the last load
+var lastIdentity struct {
+	sync.Mutex
+	id          string
+	fingerprint string
+}
+
+// recordIdentityChange records the first load of the identity certificate
+// and its rotations in the audit log
+func recordIdentityChange(cert *tls.Certificate) {
+	if !audit.Enabled() {
+		return
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	fingerprint := audit.Fingerprint(leaf)
+
+	lastIdentity.Lock()
+	defer lastIdentity.Unlock()
+
+	if fingerprint == lastIdentity.fingerprint {
+		return
+	}
+
+	var id string
+	if spiffeID, err := IDFromCert(leaf); err == nil {
+		id = spiffeID.String()
+	}
+	notAfter := leaf.NotAfter.UTC()
+
+	e := audit.Event{
+		Type:                audit.TypeIdentityLoaded,
+		LocalID:             id,
+		CertFingerprint:     fingerprint,
+		PreviousID:          lastIdentity.id,
+		PreviousFingerprint: lastIdentity.fingerprint,
+		NotAfter:            &notAfter,
+	}
+	if lastIdentity.fingerprint != "" {
+		e.Type = audit.TypeIdentityRotation
+	}
+	audit.Record(e)
+
+	lastIdentity.id = id
+	lastIdentity.fingerprint = fingerprint
+}
+
 func GetCertPool() (*x509.CertPool, error) {
 	idLogger := log.LoggerLgr.WithName(log.ConstConnManager)
 
@@ -0,0 +1,205 @@
+// Package testenv sets up the QuicSec configuration of the tests: a test CA,
+// the workload certificate signed by it and a core config authorizing the
+// workload identity.
+package testenv
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+const (
+	// WorkloadID is the identity of the workload certificate, authorized by
+	// the core config
+	WorkloadID = "spiffe://quicsec.test/workload"
+	// IntruderID is an identity signed by the test CA without authz rule
+	IntruderID = "spiffe://quicsec.test/intruder"
+)
+
+// Env is the test configuration loaded by Setup
+type Env struct {
+	// Dir holds the certificates and the core config
+	Dir string
+	// CAPool contains the test CA
+	CAPool *x509.CertPool
+
+	ca         *x509.Certificate
+	caKey      *ecdsa.PrivateKey
+	serial     int64
+	conf       map[string]interface{}
+	configPath string
+}
+
+// Setup writes the workload certificate, the test CA and the core config to
+// a temporary directory, points the QuicSec environment variables to them and
+// loads the config. The sections of coreConfig are added to the core config
+// and env to the environment variables.
+func Setup(coreConfig map[string]interface{}, env map[string]string) (*Env, error) {
+	dir, err := os.MkdirTemp("", "quicsec-test")
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := setup(dir, coreConfig, env)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func setup(dir string, coreConfig map[string]interface{}, env map[string]string) (*Env, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "quicsec test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Env{Dir: dir, CAPool: x509.NewCertPool(), ca: ca, caKey: caKey, serial: 1,
+		configPath: filepath.Join(dir, "config.json")}
+	e.CAPool.AddCert(ca)
+
+	workloadCert, err := e.NewCert(WorkloadID)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(workloadCert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return nil, err
+	}
+
+	certPath := filepath.Join(dir, "cert.pem")
+	keyPath := filepath.Join(dir, "cert.key")
+	caPath := filepath.Join(dir, "ca.pem")
+	files := map[string]*pem.Block{
+		certPath: {Type: "CERTIFICATE", Bytes: workloadCert.Certificate[0]},
+		keyPath:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+		caPath:   {Type: "CERTIFICATE", Bytes: caDER},
+	}
+	for path, block := range files {
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	e.conf = map[string]interface{}{
+		"qm_service_conf": []interface{}{
+			map[string]interface{}{
+				"server_instance_key": "127.0.0.1",
+				"client_cert":         true,
+				"policy": map[string]interface{}{
+					WorkloadID: map[string]interface{}{"authz": "allow"},
+				},
+			},
+		},
+	}
+	if err := e.writeConfig(coreConfig); err != nil {
+		return nil, err
+	}
+
+	vars := map[string]string{
+		"QUICSEC_CORE_CONFIG":     e.configPath,
+		"QUICSEC_CERTS_CERT_PATH": certPath,
+		"QUICSEC_CERTS_KEY_PATH":  keyPath,
+		"QUICSEC_CERTS_CA_PATH":   caPath,
+		"QUICSEC_LOG_DEBUG":       "false",
+		// no Prometheus endpoint
+		"QUICSEC_METRICS_BIND_PORT": "0",
+	}
+	for k, v := range env {
+		vars[k] = v
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+	config.LoadConfig()
+
+	return e, nil
+}
+
+// UpdateConfig replaces the sections of coreConfig in the core config. The
+// file is replaced at once, the config watcher reloads it asynchronously.
+func (e *Env) UpdateConfig(coreConfig map[string]interface{}) error {
+	return e.writeConfig(coreConfig)
+}
+
+func (e *Env) writeConfig(coreConfig map[string]interface{}) error {
+	for k, v := range coreConfig {
+		e.conf[k] = v
+	}
+	raw, err := json.Marshal(e.conf)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := e.configPath + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, e.configPath)
+}
+
+// NewCert returns a certificate for the SPIFFE ID id (and 127.0.0.1) signed
+// by the test CA
+func (e *Env) NewCert(id string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	uri, err := url.Parse(id)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(atomic.AddInt64(&e.serial, 1)),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		URIs:         []*url.URL{uri},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, e.ca, &key.PublicKey, e.caKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// Close removes the certificates and the core config
+func (e *Env) Close() error {
+	return os.RemoveAll(e.Dir)
+}
@@ -1,6 +1,8 @@
 package operations
 
 import (
+	"context"
+	"errors"
 	"io"
 	"sync"
 
@@ -8,11 +10,17 @@ import (
 	"github.com/quicsec/quicsec/config"
 	"github.com/quicsec/quicsec/identity"
 	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/operations/tracing"
 	"github.com/quicsec/quicsec/utils"
 )
 
 var (
 	onlyOnce sync.Once
+
+	// shared by the listeners and the clients, so the admin API applies to
+	// all the connections
+	keyLog io.Writer
+	tracer logging.Tracer
 )
 
 // OperationsInit initialize the Operations Manager
@@ -21,10 +29,10 @@ var (
 // 2. Creates the shared secret file to dump the traffic secrets
 // 3. Creates the qlog
 // 4. Start tracing the metrics
+// 5. Starts the distributed tracing exporter
+// 6. Starts the admin API
 func OperationsInit() (io.Writer, logging.Tracer) {
 	var tracers []logging.Tracer
-	var tracer logging.Tracer
-	var keyLog io.Writer
 	conf := config.LoadConfig()
 
 	onlyOnce.Do(func() {
@@ -33,19 +41,34 @@ func OperationsInit() (io.Writer, logging.Tracer) {
 
 		opsLogger.Info("module initialization")
 
-		if conf.Quic.Debug.SecretFilePathEnableFlag {
-			opsLogger.V(log.DebugLevel).Info("pre shared key dump enabled", "path", conf.Quic.Debug.SecretFilePath)
+		var staticKeyLog io.Writer
+		if debug := conf.Quic.Debug; debug.SecretFilePathEnableFlag {
+			opsLogger.Error(nil, "WARNING: TLS key log enabled, the traffic secrets are written to a file and anyone who can read it can decrypt the captured traffic",
+				"path", debug.SecretFilePath, "peers", debug.SecretPeers)
 
-			keyLog = utils.CreateFileRotate(conf.Quic.Debug.SecretFilePath, 2)
+			f, err := utils.NewRotatingFile(debug.SecretFilePath, debug.SecretMaxSize, debug.SecretMaxAge, debug.SecretMaxBackups)
+			if err != nil {
+				opsLogger.Error(err, "failed to open the key log file, the TLS secrets are not logged")
+			} else {
+				staticKeyLog = f
+			}
 		} else {
 			opsLogger.V(log.DebugLevel).Info("pre shared key dump disabled")
 		}
 
-		if conf.Quic.Debug.QlogEnableFlag {
-			opsLogger.V(log.DebugLevel).Info("qlog enabled", "path", conf.Quic.Debug.QlogDirPath)
+		// the admin API opens key log windows at runtime
+		var keyLogs *keyLogGate
+		if staticKeyLog != nil || conf.Admin.Enable {
+			keyLogs = &keyLogGate{static: staticKeyLog}
+			keyLog = keyLogs
+		}
+
+		if conf.Quic.Debug.QlogEnableFlag || conf.Admin.Enable {
+			opsLogger.V(log.DebugLevel).Info("qlog enabled", "path", conf.Quic.Debug.QlogDirPath, "sampled", conf.Quic.Debug.QlogEnableFlag)
 
-			qlogTracer := qlogInit(conf.Quic.Debug.QlogDirPath)
-			tracers = append(tracers, qlogTracer)
+			if qlogTr := qlogInit(conf.Quic.Debug, conf.Admin); qlogTr != nil {
+				tracers = append(tracers, qlogTr)
+			}
 		} else {
 			opsLogger.V(log.DebugLevel).Info("qlog disabled")
 		}
@@ -58,10 +81,23 @@ func OperationsInit() (io.Writer, logging.Tracer) {
 			opsLogger.V(log.DebugLevel).Info("trace metrics disabled")
 		}
 
+		if conf.Tracing.Enable {
+			opsLogger.V(log.DebugLevel).Info("distributed tracing enabled", "exporter", conf.Tracing.Exporter)
+			if err := tracing.Init(); err != nil {
+				opsLogger.Error(err, "failed to start distributed tracing")
+			}
+		} else {
+			opsLogger.V(log.DebugLevel).Info("distributed tracing disabled")
+		}
+
 		if len(tracers) > 0 {
 			tracer = logging.NewMultiplexedTracer(tracers...)
 		}
 
+		if conf.Admin.Enable {
+			go runAdminAPI(conf.Admin, keyLogs)
+		}
+
 		currentId, err := identity.GetCurrentIdentity()
 		if err == nil {
 			config.SetIdentity(currentId)
@@ -70,3 +106,9 @@ func OperationsInit() (io.Writer, logging.Tracer) {
 
 	return keyLog, tracer
 }
+
+// Shutdown sends the spans and the log entries not exported yet, waiting
+// until ctx is done. The application calls it before exiting.
+func Shutdown(ctx context.Context) error {
+	return errors.Join(tracing.Shutdown(ctx), log.StopShipping(ctx))
+}
@@ -0,0 +1,298 @@
+package operations
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+// maxCaptureConnections bounds the connections of a qlog capture
+const maxCaptureConnections = 1000
+
+type adminServer struct {
+	conf    config.AdminConfigs
+	keyLogs *keyLogGate
+}
+
+// runAdminAPI serves the local admin API: the qlog captures, the key log
+// windows and the captured files. With a token, the requests must carry it
+// in the Authorization header ("Bearer <token>").
+//
+//	GET    /qlog/captures          running captures
+//	POST   /qlog/captures          {"connections": 10, "peer_id": "spiffe://...", "remote_addr": "10.0.0.1"}
+//	DELETE /qlog/captures/{id}
+//	GET    /keylog                 open window, if any
+//	POST   /keylog                 {"duration": "5m"}
+//	DELETE /keylog
+//	GET    /captures               captured files
+//	GET    /captures/{name}        download
+//	DELETE /captures/{name}
+func runAdminAPI(conf config.AdminConfigs, keyLogs *keyLogGate) {
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+
+	network, address, err := config.ParseAdminAddress(conf.Address)
+	if err != nil {
+		opsLogger.Error(err, "invalid admin API address")
+		return
+	}
+
+	var ln net.Listener
+	if network == "unix" {
+		ln, err = listenAdminSocket(address)
+	} else {
+		ln, err = net.Listen(network, address)
+	}
+	if err != nil {
+		opsLogger.Error(err, "failed to listen for the admin API", "address", conf.Address)
+		return
+	}
+
+	s := &adminServer{conf: conf, keyLogs: keyLogs}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/qlog/captures", s.handleQlogCaptures)
+	mux.HandleFunc("/qlog/captures/", s.handleQlogCapture)
+	mux.HandleFunc("/keylog", s.handleKeyLog)
+	mux.HandleFunc("/captures", s.handleCaptures)
+	mux.HandleFunc("/captures/", s.handleCapture)
+
+	opsLogger.Info("admin API available", "address", conf.Address, "captures", conf.CapturePath)
+
+	if err := http.Serve(ln, s.authorize(mux)); err != nil {
+		opsLogger.Error(err, "admin API failed")
+	}
+}
+
+// listenAdminSocket listens on the unix socket path, only accessible by the
+// owner: the socket is bound to a temporary path and moved to path once its
+// mode is restricted, a stale socket of a previous process is replaced
+func listenAdminSocket(path string) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket == 0 {
+		return nil, fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	os.Remove(tmpPath)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// the socket is moved, it is replaced by the next process
+	ln.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		ln.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		ln.Close()
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// authorize rejects the requests without the token of the configuration,
+// if any
+func (s *adminServer) authorize(next http.Handler) http.Handler {
+	if s.conf.Token == "" {
+		return next
+	}
+
+	want := []byte("Bearer " + s.conf.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *adminServer) handleQlogCaptures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, QlogCaptures())
+	case http.MethodPost:
+		var req struct {
+			Connections int    `json:"connections"`
+			PeerID      string `json:"peer_id"`
+			RemoteAddr  string `json:"remote_addr"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Connections <= 0 || req.Connections > maxCaptureConnections {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("connections must be between 1 and %d", maxCaptureConnections))
+			return
+		}
+		if req.PeerID != "" && !strings.HasPrefix(req.PeerID, "spiffe://") {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid peer_id %q", req.PeerID))
+			return
+		}
+
+		c := StartQlogCapture(req.Connections, req.PeerID, req.RemoteAddr)
+		log.LoggerLgr.WithName(log.ConstOperationsManager).Info("qlog capture started",
+			"id", c.ID, "connections", c.Remaining, "peer_id", c.PeerID, "remote_addr", c.RemoteAddr)
+		writeJSON(w, http.StatusCreated, c)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *adminServer) handleQlogCapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/qlog/captures/")
+	if !StopQlogCapture(id) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no running capture %q", id))
+		return
+	}
+	log.LoggerLgr.WithName(log.ConstOperationsManager).Info("qlog capture stopped", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type keyLogStatus struct {
+	Enabled bool       `json:"enabled"`
+	File    string     `json:"file,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+}
+
+func (s *adminServer) handleKeyLog(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var status keyLogStatus
+		if file, until, ok := s.keyLogs.status(); ok {
+			status = keyLogStatus{Enabled: true, File: file, Until: &until}
+		}
+		writeJSON(w, http.StatusOK, status)
+	case http.MethodPost:
+		// the guard of the key log file applies to the windows
+		if err := config.CheckKeyLogAllowed(); err != nil {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+
+		var req struct {
+			Duration string `json:"duration"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > s.conf.MaxKeyLogWindow {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("duration must be between 0 and %s", s.conf.MaxKeyLogWindow))
+			return
+		}
+
+		file, until, err := s.keyLogs.openWindow(s.conf.CapturePath, d)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.LoggerLgr.WithName(log.ConstOperationsManager).Info("key log window opened: the TLS secrets of the new connections are written",
+			"file", file, "until", until)
+		writeJSON(w, http.StatusOK, keyLogStatus{Enabled: true, File: file, Until: &until})
+	case http.MethodDelete:
+		s.keyLogs.stopWindow()
+		log.LoggerLgr.WithName(log.ConstOperationsManager).Info("key log window closed")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+type capturedFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+func (s *adminServer) handleCaptures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := os.ReadDir(s.conf.CapturePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	files := []capturedFile{}
+	for _, e := range entries {
+		// the temporary files of the open connections aren't complete
+		if e.IsDir() || strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, capturedFile{Name: e.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Modified.Before(files[j].Modified) })
+
+	writeJSON(w, http.StatusOK, files)
+}
+
+func (s *adminServer) handleCapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/captures/")
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no captured file %q", name))
+		return
+	}
+
+	path := filepath.Join(s.conf.CapturePath, name)
+	if fi, err := os.Stat(path); err != nil || !fi.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no captured file %q", name))
+		return
+	}
+
+	// the captures are not removed by the retention policy
+	if r.Method == http.MethodDelete {
+		if err := os.Remove(path); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.LoggerLgr.WithName(log.ConstOperationsManager).Info("captured file removed", "name", name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	http.ServeFile(w, r, path)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,126 @@
+package operations
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+func TestListenAdminSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+
+	// the socket of a previous process is replaced
+	stale, err := listenAdminSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.Close()
+
+	ln, err := listenAdminSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != 0600 {
+		t.Errorf("socket mode %s, want a socket with 0600", fi.Mode())
+	}
+
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://admin/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// a file that isn't a socket is never removed
+	file := filepath.Join(t.TempDir(), "admin.sock")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenAdminSocket(file); err == nil {
+		t.Error("listening on a regular file succeeded")
+	}
+}
+
+func TestAdminAuthorize(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		want   int
+	}{
+		{"no token configured", "", "", http.StatusOK},
+		{"valid token", "s3cr3t", "Bearer s3cr3t", http.StatusOK},
+		{"missing token", "s3cr3t", "", http.StatusUnauthorized},
+		{"invalid token", "s3cr3t", "Bearer s3cr3", http.StatusUnauthorized},
+		{"not a bearer token", "s3cr3t", "s3cr3t", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &adminServer{conf: config.AdminConfigs{Token: tt.token}}
+			r := httptest.NewRequest(http.MethodGet, "/captures", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.authorize(ok).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdminCapture(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"client_1.qlog", "client_2.qlog.tmp"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("qlog"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := &adminServer{conf: config.AdminConfigs{CapturePath: dir}}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		want   int
+	}{
+		{"download", http.MethodGet, "/captures/client_1.qlog", http.StatusOK},
+		{"open connection", http.MethodGet, "/captures/client_2.qlog.tmp", http.StatusNotFound},
+		{"outside of the capture directory", http.MethodGet, "/captures/..%2fadmin.sock", http.StatusNotFound},
+		{"remove an open connection", http.MethodDelete, "/captures/client_2.qlog.tmp", http.StatusNotFound},
+		{"remove", http.MethodDelete, "/captures/client_1.qlog", http.StatusNoContent},
+		{"removed", http.MethodGet, "/captures/client_1.qlog", http.StatusNotFound},
+		{"other method", http.MethodPut, "/captures/client_1.qlog", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handleCapture(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			}
+		})
+	}
+}
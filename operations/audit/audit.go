@@ -0,0 +1,177 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/utils"
+)
+
+// SchemaVersion is the version of the Event schema, increased on
+// incompatible changes only (new fields are added without a bump)
+const SchemaVersion = 1
+
+// event types
+const (
+	// TypeAuthn is the authentication of a peer certificate chain
+	TypeAuthn = "authn"
+	// TypeAuthz is the authorization of the SPIFFE ID of a peer during the
+	// handshake (or the session resumption)
+	TypeAuthz = "authz"
+	// TypeRequestAuthz is the authorization of a request establishing a
+	// long lived session (e.g. WebTransport)
+	TypeRequestAuthz = "request_authz"
+	// TypeConfigReload is a reload of the core config file
+	TypeConfigReload = "config_reload"
+	// TypeIdentityLoaded is the first load of the local identity certificate
+	TypeIdentityLoaded = "identity_loaded"
+	// TypeIdentityRotation is a change of the local identity certificate
+	TypeIdentityRotation = "identity_rotation"
+)
+
+// authn results and authz decisions
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	DecisionAllow = "allow"
+	DecisionDeny  = "deny"
+	// Skipped is the result or the decision when the check is disabled
+	// (mTLS disabled)
+	Skipped = "skipped"
+)
+
+// directions of the connections
+const (
+	DirectionIncoming = "incoming"
+	DirectionOutgoing = "outgoing"
+)
+
+// Event is a line of the audit log. Only the fields of the event type are
+// set, the other ones are omitted.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Version int       `json:"schema_version"`
+	Type    string    `json:"type"`
+
+	// authn, authz and request_authz
+	Direction       string `json:"direction,omitempty"`
+	LocalID         string `json:"local_id,omitempty"`
+	PeerID          string `json:"peer_id,omitempty"`
+	CertFingerprint string `json:"cert_fingerprint,omitempty"`
+	Result          string `json:"result,omitempty"`
+	Decision        string `json:"decision,omitempty"`
+	Policy          string `json:"policy,omitempty"`
+	Rule            string `json:"rule,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+
+	// config_reload
+	ConfigPath  string   `json:"config_path,omitempty"`
+	AuthzRules  []string `json:"authz_rules,omitempty"`
+	MtlsEnabled *bool    `json:"mtls_enabled,omitempty"`
+
+	// identity_loaded and identity_rotation, the local identity is LocalID
+	// and CertFingerprint
+	PreviousID          string     `json:"previous_id,omitempty"`
+	PreviousFingerprint string     `json:"previous_fingerprint,omitempty"`
+	NotAfter            *time.Time `json:"not_after,omitempty"`
+}
+
+var (
+	mutex sync.Mutex
+	sink  io.WriteCloser
+	// set from a failed write until the next successful one
+	failing bool
+
+	writeErrors uint64
+)
+
+// Init opens the audit log at path (JSON lines, mode 0600). The events are
+// dropped until Init is called.
+func Init(path string) error {
+	f, err := utils.NewRotatingFile(path, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("audit: failed to open %s: %w", path, err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if sink != nil {
+		sink.Close()
+	}
+	sink = f
+	failing = false
+
+	return nil
+}
+
+// Enabled reports whether the events are recorded
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	return sink != nil
+}
+
+// Record writes e to the audit log, the time and the schema version are set
+// by Record
+func Record(e Event) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if sink == nil {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+	e.Version = SchemaVersion
+
+	line, err := json.Marshal(e)
+	if err == nil {
+		_, err = sink.Write(append(line, '\n'))
+	}
+	observeWrite(err)
+}
+
+// WriteErrors returns the number of events which couldn't be written
+func WriteErrors() uint64 {
+	return atomic.LoadUint64(&writeErrors)
+}
+
+// observeWrite counts the failed writes and logs the first failure and the
+// recovery, it must be called with the mutex held
+func observeWrite(err error) {
+	if err != nil {
+		atomic.AddUint64(&writeErrors, 1)
+	}
+	if (err != nil) == failing {
+		return
+	}
+	failing = err != nil
+
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+	if err != nil {
+		opsLogger.Error(err, "failed to write the audit log, the security events are lost")
+	} else {
+		opsLogger.Info("audit log written again")
+	}
+}
+
+// Fingerprint returns the SHA-256 fingerprint of cert ("sha256:<hex>"),
+// empty without certificate
+func Fingerprint(cert *x509.Certificate) string {
+	if cert == nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(cert.Raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,189 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memorySink keeps the lines written, or fails them with err
+type memorySink struct {
+	bytes.Buffer
+	err error
+}
+
+func (s *memorySink) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	return s.Buffer.Write(p)
+}
+
+func (s *memorySink) Close() error {
+	return nil
+}
+
+// setSink records the events to s during the test
+func setSink(t *testing.T, s *memorySink) {
+	mutex.Lock()
+	prevSink, prevFailing := sink, failing
+	sink, failing = s, false
+	mutex.Unlock()
+
+	t.Cleanup(func() {
+		mutex.Lock()
+		sink, failing = prevSink, prevFailing
+		mutex.Unlock()
+	})
+}
+
+func TestEventSchema(t *testing.T) {
+	mtls := true
+	notAfter := time.Date(2027, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		event Event
+		want  map[string]interface{}
+	}{
+		{"authn", Event{
+			Type:            TypeAuthn,
+			Direction:       DirectionIncoming,
+			LocalID:         "spiffe://foo.bar/server",
+			PeerID:          "spiffe://foo.bar/client",
+			CertFingerprint: "sha256:00",
+			Result:          ResultFailure,
+			Reason:          "certificate expired",
+		}, map[string]interface{}{
+			"type":             "authn",
+			"direction":        "incoming",
+			"local_id":         "spiffe://foo.bar/server",
+			"peer_id":          "spiffe://foo.bar/client",
+			"cert_fingerprint": "sha256:00",
+			"result":           "failure",
+			"reason":           "certificate expired",
+		}},
+		{"authz", Event{
+			Type:            TypeAuthz,
+			Direction:       DirectionOutgoing,
+			LocalID:         "spiffe://foo.bar/client",
+			PeerID:          "spiffe://foo.bar/server",
+			CertFingerprint: "sha256:01",
+			Decision:        DecisionAllow,
+			Policy:          "192.168.0.10",
+			Rule:            "spiffe://foo.bar/server",
+		}, map[string]interface{}{
+			"type":             "authz",
+			"direction":        "outgoing",
+			"local_id":         "spiffe://foo.bar/client",
+			"peer_id":          "spiffe://foo.bar/server",
+			"cert_fingerprint": "sha256:01",
+			"decision":         "allow",
+			"policy":           "192.168.0.10",
+			"rule":             "spiffe://foo.bar/server",
+		}},
+		{"config_reload", Event{
+			Type:        TypeConfigReload,
+			ConfigPath:  "/etc/quicsec/config.json",
+			Policy:      "192.168.0.10",
+			AuthzRules:  []string{"spiffe://foo.bar/client"},
+			MtlsEnabled: &mtls,
+		}, map[string]interface{}{
+			"type":         "config_reload",
+			"config_path":  "/etc/quicsec/config.json",
+			"policy":       "192.168.0.10",
+			"authz_rules":  []interface{}{"spiffe://foo.bar/client"},
+			"mtls_enabled": true,
+		}},
+		{"identity_rotation", Event{
+			Type:                TypeIdentityRotation,
+			LocalID:             "spiffe://foo.bar/server",
+			CertFingerprint:     "sha256:03",
+			PreviousID:          "spiffe://foo.bar/server",
+			PreviousFingerprint: "sha256:02",
+			NotAfter:            &notAfter,
+		}, map[string]interface{}{
+			"type":                 "identity_rotation",
+			"local_id":             "spiffe://foo.bar/server",
+			"cert_fingerprint":     "sha256:03",
+			"previous_id":          "spiffe://foo.bar/server",
+			"previous_fingerprint": "sha256:02",
+			"not_after":            "2027-01-02T03:04:05Z",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &memorySink{}
+			setSink(t, s)
+
+			before := time.Now().UTC()
+			Record(tt.event)
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(s.Bytes(), &got); err != nil {
+				t.Fatalf("invalid line %q: %v", s.String(), err)
+			}
+			if s.Bytes()[s.Len()-1] != '\n' {
+				t.Errorf("line %q doesn't end with a newline", s.String())
+			}
+
+			recorded, err := time.Parse(time.RFC3339Nano, got["time"].(string))
+			if err != nil || recorded.Before(before.Truncate(time.Second)) || recorded.Location() != time.UTC {
+				t.Errorf("time %v, want the UTC time of the record", got["time"])
+			}
+			delete(got, "time")
+
+			want := map[string]interface{}{"schema_version": float64(SchemaVersion)}
+			for k, v := range tt.want {
+				want[k] = v
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	cert := &x509.Certificate{Raw: []byte("test")}
+	want := "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	if got := Fingerprint(cert); got != want {
+		t.Errorf("Fingerprint() = %q, want %q", got, want)
+	}
+	if got := Fingerprint(nil); got != "" {
+		t.Errorf("Fingerprint(nil) = %q, want empty", got)
+	}
+}
+
+func TestRecordWriteErrors(t *testing.T) {
+	s := &memorySink{err: errors.New("disk full")}
+	setSink(t, s)
+
+	before := WriteErrors()
+	for i := 0; i < 3; i++ {
+		Record(Event{Type: TypeConfigReload})
+	}
+	if got := WriteErrors() - before; got != 3 {
+		t.Errorf("%d write errors, want 3", got)
+	}
+	if !failing {
+		t.Error("failing not set after a failed write")
+	}
+
+	s.err = nil
+	Record(Event{Type: TypeConfigReload})
+	if got := WriteErrors() - before; got != 3 {
+		t.Errorf("%d write errors after a successful write, want 3", got)
+	}
+	if failing {
+		t.Error("failing still set after a successful write")
+	}
+	if s.Len() == 0 {
+		t.Error("event not written once the sink recovered")
+	}
+}
@@ -0,0 +1,152 @@
+package httplog
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// outcomes of the request and response bodies
+const (
+	// the body was read (or written) until its end
+	BodyComplete = "complete"
+	// the body was closed or left before its end
+	BodyIncomplete = "incomplete"
+	// the request context was canceled (e.g. timeout)
+	BodyCanceled = "canceled"
+	// the stream was reset by the peer or the connection closed
+	BodyReset = "reset"
+	// the stream was taken over by the handler (e.g. CONNECT, WebTransport)
+	BodyHijacked = "hijacked"
+)
+
+// countingBody counts the bytes read from a request or response body and
+// records how it ended. done is called once, when the body ends (EOF, error,
+// close or all the bytes of its content length read).
+type countingBody struct {
+	body io.ReadCloser
+	done func(*countingBody)
+	// content length of the body, -1 when unknown
+	length int64
+
+	mutex     sync.Mutex
+	size      int64
+	firstByte time.Time
+	end       time.Time
+	outcome   string
+}
+
+func newCountingBody(body io.ReadCloser, length int64, done func(*countingBody)) *countingBody {
+	return &countingBody{body: body, length: length, done: done}
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+
+	b.mutex.Lock()
+	b.size += int64(n)
+	if n > 0 && b.firstByte.IsZero() {
+		b.firstByte = time.Now()
+	}
+	read := b.read()
+	b.mutex.Unlock()
+
+	switch {
+	case err == io.EOF || (err == nil && read):
+		// the callers reading the content length only may never see EOF
+		b.finish(BodyComplete)
+	case err != nil:
+		b.finish(bodyErrorOutcome(err))
+	}
+
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.mutex.Lock()
+	read := b.read()
+	b.mutex.Unlock()
+
+	if read {
+		b.finish(BodyComplete)
+	} else {
+		b.finish(BodyIncomplete)
+	}
+	return b.body.Close()
+}
+
+// read reports whether the whole content length was read, it must be called
+// with the body locked
+func (b *countingBody) read() bool {
+	return b.length >= 0 && b.size >= b.length
+}
+
+func (b *countingBody) finish(outcome string) {
+	b.mutex.Lock()
+	if b.outcome != "" {
+		b.mutex.Unlock()
+		return
+	}
+	b.outcome = outcome
+	b.end = time.Now()
+	b.mutex.Unlock()
+
+	if b.done != nil {
+		b.done(b)
+	}
+}
+
+// state returns the bytes read and the outcome of the body, incomplete when
+// it hasn't ended yet and its content length wasn't read
+func (b *countingBody) state() (int64, string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case b.outcome != "":
+		return b.size, b.outcome
+	case b.read():
+		return b.size, BodyComplete
+	default:
+		return b.size, BodyIncomplete
+	}
+}
+
+// streamerBody is a countingBody of a request received by the HTTP/3
+// server, the handlers can still take over the stream
+type streamerBody struct {
+	*countingBody
+}
+
+func (b streamerBody) HTTPStream() http3.Stream {
+	b.finish(BodyHijacked)
+	return b.body.(http3.HTTPStreamer).HTTPStream()
+}
+
+// wrapRequestBody wraps the body of a request received by the server, it
+// returns nil when the request has no body
+func wrapRequestBody(r *http.Request) (io.ReadCloser, *countingBody) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return r.Body, nil
+	}
+
+	b := newCountingBody(r.Body, r.ContentLength, nil)
+	if _, ok := r.Body.(http3.HTTPStreamer); ok {
+		return streamerBody{b}, b
+	}
+	return b, b
+}
+
+// bodyErrorOutcome returns the outcome of a body that ended with err: the
+// errors other than the cancelation come from the stream or the connection
+func bodyErrorOutcome(err error) string {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return BodyCanceled
+	}
+	return BodyReset
+}
@@ -0,0 +1,73 @@
+package httplog
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// exactReader returns the data without io.EOF, like a stream whose end was
+// not received yet
+type exactReader struct {
+	data string
+	err  error
+}
+
+func (r *exactReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestCountingBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    io.Reader
+		length  int64
+		read    int
+		close   bool
+		size    int64
+		outcome string
+		done    bool
+	}{
+		{"read until EOF", strings.NewReader("hello"), -1, 10, false, 5, BodyComplete, true},
+		{"content length read without EOF", &exactReader{data: "hello"}, 5, 5, false, 5, BodyComplete, true},
+		{"content length read then closed", &exactReader{data: "hello"}, 5, 5, true, 5, BodyComplete, true},
+		{"closed before the end", &exactReader{data: "hello"}, 5, 2, true, 2, BodyIncomplete, true},
+		{"unknown length closed", &exactReader{data: "hello"}, -1, 5, true, 5, BodyIncomplete, true},
+		{"not ended", &exactReader{data: "hello"}, -1, 2, false, 2, BodyIncomplete, false},
+		{"empty body not read", &exactReader{}, 0, 0, false, 0, BodyComplete, false},
+		{"stream reset", &exactReader{data: "he", err: errors.New("stream reset")}, 5, 10, false, 2, BodyReset, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			b := newCountingBody(io.NopCloser(tt.body), tt.length, func(*countingBody) { calls++ })
+
+			buf := make([]byte, tt.read)
+			for read := 0; read < tt.read; {
+				n, err := b.Read(buf[read:])
+				read += n
+				if err != nil || n == 0 {
+					break
+				}
+			}
+			if tt.close {
+				b.Close()
+			}
+
+			size, outcome := b.state()
+			if size != tt.size || outcome != tt.outcome {
+				t.Errorf("state() = %d, %s, want %d, %s", size, outcome, tt.size, tt.outcome)
+			}
+			if want := map[bool]int{true: 1, false: 0}[tt.done]; calls != want {
+				t.Errorf("done called %d times, want %d", calls, want)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/quicsec/quicsec/config"
 	"github.com/quicsec/quicsec/identity"
 	"github.com/quicsec/quicsec/operations/log"
+	"github.com/quicsec/quicsec/operations/tracing"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
@@ -23,23 +24,56 @@ type loggingResponseWriter struct {
 	http.ResponseWriter // compose original http.ResponseWriter
 	statusCode          int
 	size                int
+	firstByte           time.Time // headers or first body bytes written
+	writeErr            error     // first error writing the body
+	hijacked            bool      // connection taken over (http.Hijacker)
 }
 
 func (r *loggingResponseWriter) Write(b []byte) (int, error) {
+	if r.firstByte.IsZero() {
+		r.firstByte = time.Now()
+	}
 	size, err := r.ResponseWriter.Write(b) // write response using original http.ResponseWriter
 	r.size += size                         // capture size
+	if err != nil && r.writeErr == nil {
+		r.writeErr = err
+	}
 	return size, err
 }
 
 func (r *loggingResponseWriter) WriteHeader(statusCode int) {
+	if r.firstByte.IsZero() {
+		r.firstByte = time.Now()
+	}
 	r.statusCode = statusCode                // capture status code
 	r.ResponseWriter.WriteHeader(statusCode) // write status code using original http.ResponseWriter
 }
 
+// Unwrap returns the original http.ResponseWriter, so handlers can use its
+// optional interfaces (http.Flusher, http3.Hijacker...)
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 func NewLoggingResponseWriter(w http.ResponseWriter) *loggingResponseWriter {
 	// WriteHeader(int) is not called if our response implicitly returns 200 OK, so
 	// we default to that status code.
-	return &loggingResponseWriter{w, http.StatusOK, 0}
+	return &loggingResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+}
+
+// outcome returns the outcome of the response body once the handler
+// returned
+func (r *loggingResponseWriter) outcome(req *http.Request, reqOutcome string) string {
+	switch {
+	case reqOutcome == BodyHijacked || r.hijacked:
+		return BodyHijacked
+	case req.Context().Err() != nil:
+		return BodyCanceled
+	case r.writeErr != nil:
+		return bodyErrorOutcome(r.writeErr)
+	default:
+		return BodyComplete
+	}
 }
 
 type LoggableHTTPRequestClient struct {
@@ -55,12 +89,17 @@ type LoggableHTTPRequest struct {
 // This type implements the http.RoundTripper interface
 type LoggingRoundTripper struct {
 	Base http.RoundTripper
+	// Transport is logged when the round trip fails, the transport of the
+	// response is logged otherwise
+	Transport string
 }
 
+// RoundTrip logs the response headers when they are received and the request
+// once the response body is complete (read until its end or its content
+// length, closed or failed).
 func (lrt LoggingRoundTripper) RoundTrip(r *http.Request) (res *http.Response, err error) {
 	start := time.Now()
 	logger := log.LoggerRequest.Named("quicsec.log.access.http.client")
-	defer logger.Sync()
 
 	loggableReq := zap.Object("request", LoggableHTTPRequestClient{
 		Request: r,
@@ -68,44 +107,109 @@ func (lrt LoggingRoundTripper) RoundTrip(r *http.Request) (res *http.Response, e
 	accLog := logger.With(loggableReq)
 	log := accLog.Info
 
+	r, span := startClientSpan(r)
+
+	var reqBody *countingBody
+	if r.Body != nil && r.Body != http.NoBody {
+		reqBody = newCountingBody(r.Body, r.ContentLength, nil)
+		counted := *r
+		counted.Body = reqBody
+		r = &counted
+	}
+
 	// Send the request, get the response
 	res, err = lrt.Base.RoundTrip(r)
 
-	duration := time.Since(start)
+	ttfb := time.Since(start)
+
+	var reqSize int64
+	reqOutcome := BodyComplete
+	if reqBody != nil {
+		reqSize, reqOutcome = reqBody.state()
+	}
 
 	if err != nil {
 		log("handled request",
-			zap.Duration("duration", duration),
+			zap.Duration("duration", ttfb),
+			zap.String("transport", lrt.Transport),
+			zap.Int64("req_size", reqSize),
+			zap.String("req_body", reqOutcome),
 			zap.String("error", err.Error()),
 		)
-	} else {
-		size := 0
-		if res.ContentLength > 0 {
-			size = int(res.ContentLength)
-		}
+		logger.Sync()
+		span.SetAttributes(tracing.AttrTransport.String(lrt.Transport))
+		tracing.End(span, err)
+		return res, err
+	}
 
-		//Prometheus metrics for HTTP
-		if config.GetMetricsEnabled() {
-			if len(res.TLS.PeerCertificates) > 0 {
-				serverId, err := identity.IDFromCert(res.TLS.PeerCertificates[0])
-				if err == nil {
-					operations.HttpRequestsPathIdClient.WithLabelValues(config.GetIdentity().String(), serverId.String(), r.Host, r.Method, r.URL.RequestURI(), strconv.Itoa(res.StatusCode)).Inc()
-					operations.HTTPHistogramNetworkLatencyId.WithLabelValues(config.GetIdentity().String(), serverId.String()).Observe(duration.Seconds())
+	transport := Transport(res.ProtoMajor, res.ProtoMinor)
+
+	//Prometheus metrics for HTTP
+	if config.GetMetricsEnabled() {
+		if res.TLS != nil && len(res.TLS.PeerCertificates) > 0 {
+			serverId, err := identity.IDFromCert(res.TLS.PeerCertificates[0])
+			if err == nil {
+				path := operations.PathLabel("outgoing", config.GetIdentity().String(), serverId.String(), r.URL.Path)
+				host := r.Host
+				if host == "" {
+					host = r.URL.Host
 				}
+				instance := operations.InstanceLabel("outgoing", config.GetIdentity().String(), serverId.String(), host)
+				operations.HttpRequestsPathIdClient.WithLabelValues(config.GetIdentity().String(), serverId.String(), transport, instance, r.Method, path, strconv.Itoa(res.StatusCode)).Inc()
+				operations.HTTPHistogramNetworkLatencyId.WithLabelValues(config.GetIdentity().String(), serverId.String(), transport).Observe(ttfb.Seconds())
 			}
 		}
+	}
 
-		log("handled request",
-			zap.Duration("duration", duration),
-			zap.Int("size", size),
-			zap.Int("status", res.StatusCode),
-			zap.String("resp_proto", res.Proto),
-			zap.Object("tls", LoggableTLSConnState(*res.TLS)),
-			// zap.Object("resp_headers", LoggableHTTPHeader{
-			// 	Header: res.Header,
-			// }),
-		)
+	statusCode, proto, tlsState := res.StatusCode, res.Proto, res.TLS
+
+	// the request is logged again when the response body is complete, which
+	// may never happen when the caller doesn't close it
+	fields := []zap.Field{
+		zap.Duration("ttfb", ttfb),
+		zap.String("transport", transport),
+		zap.Int("status", statusCode),
+		zap.String("resp_proto", proto),
+		zap.Object("resp_headers", LoggableHTTPHeader{
+			Header: res.Header,
+		}),
 	}
+	if tlsState != nil {
+		fields = append(fields, zap.Object("tls", LoggableTLSConnState(*tlsState)))
+	}
+	log("received response headers", fields...)
+
+	res.Body = newCountingBody(res.Body, res.ContentLength, func(b *countingBody) {
+		defer logger.Sync()
+
+		size, outcome := b.state()
+		duration := b.end.Sub(start)
+		if reqBody != nil {
+			reqSize, reqOutcome = reqBody.state()
+		}
+
+		endSpan(span, statusCode, outcome, tracing.AttrTransport.String(transport), tracing.AttrPeerIdentity.String(peerIDFromTLS(tlsState)))
+
+		if config.GetMetricsEnabled() {
+			observeBodies("outgoing", transport, ttfb, duration, reqSize, reqOutcome, size, outcome)
+		}
+
+		fields := []zap.Field{
+			zap.Duration("duration", duration),
+			zap.Duration("ttfb", ttfb),
+			zap.String("transport", transport),
+			zap.Int64("size", size),
+			zap.String("resp_body", outcome),
+			zap.Int64("req_size", reqSize),
+			zap.String("req_body", reqOutcome),
+			zap.Int("status", statusCode),
+			zap.String("resp_proto", proto),
+		}
+		if tlsState != nil {
+			fields = append(fields, zap.Object("tls", LoggableTLSConnState(*tlsState)))
+		}
+		log("handled request", fields...)
+	})
 
 	return res, err
 }
@@ -236,23 +340,56 @@ func WrapHandlerWithLogging(wrappedHandler http.Handler) http.Handler {
 		accLog := logger.With(loggableReq)
 		log := accLog.Info
 
+		transport := Transport(r.ProtoMajor, r.ProtoMinor)
+
+		r, span := startServerSpan(r, transport)
+
+		var reqBody *countingBody
+		r.Body, reqBody = wrapRequestBody(r)
+
 		lrw := NewLoggingResponseWriter(w)
-		wrappedHandler.ServeHTTP(lrw, r)
+		wrappedHandler.ServeHTTP(wrapResponseWriter(lrw), r)
 
 		duration := time.Since(start)
 
+		var reqSize int64
+		reqOutcome := BodyComplete
+		if reqBody != nil {
+			// HTTP/3 requests always have a body, a body not read by the
+			// handler with a zero content length is complete
+			reqSize, reqOutcome = reqBody.state()
+		}
+		outcome := lrw.outcome(r, reqOutcome)
+
+		ttfb := duration
+		if !lrw.firstByte.IsZero() {
+			ttfb = lrw.firstByte.Sub(start)
+		}
+
+		endSpan(span, lrw.statusCode, outcome)
+
 		// Prometheus metrics for HTTP
-		if len(r.TLS.PeerCertificates) > 0 {
-			serverId, err := identity.IDFromCert(r.TLS.PeerCertificates[0])
-			if err == nil {
-				operations.HttpRequestsPathIdServer.WithLabelValues(config.GetIdentity().String(), serverId.String(), r.Host, r.Method, r.RequestURI, strconv.Itoa(lrw.statusCode)).Inc()
-				operations.HTTPHistogramAppProcessId.WithLabelValues(config.GetIdentity().String(), serverId.String()).Observe(duration.Seconds())
+		if config.GetMetricsEnabled() {
+			if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+				serverId, err := identity.IDFromCert(r.TLS.PeerCertificates[0])
+				if err == nil {
+					path := operations.PathLabel("incoming", config.GetIdentity().String(), serverId.String(), r.URL.Path)
+					instance := operations.InstanceLabel("incoming", config.GetIdentity().String(), serverId.String(), r.Host)
+					operations.HttpRequestsPathIdServer.WithLabelValues(config.GetIdentity().String(), serverId.String(), transport, instance, r.Method, path, strconv.Itoa(lrw.statusCode)).Inc()
+					operations.HTTPHistogramAppProcessId.WithLabelValues(config.GetIdentity().String(), serverId.String(), transport).Observe(duration.Seconds())
+				}
 			}
+			observeBodies("incoming", transport, ttfb, duration, reqSize, reqOutcome, int64(lrw.size), outcome)
 		}
 
 		log("handled request",
 			zap.Duration("duration", duration),
+			zap.Duration("ttfb", ttfb),
+			zap.String("transport", transport),
 			zap.Int("size", lrw.size),
+			zap.String("resp_body", outcome),
+			zap.Int64("req_size", reqSize),
+			zap.String("req_body", reqOutcome),
 			zap.Int("status", lrw.statusCode),
 			zap.Object("resp_headers", LoggableHTTPHeader{
 				Header: w.Header(),
@@ -260,3 +397,35 @@ func WrapHandlerWithLogging(wrappedHandler http.Handler) http.Handler {
 		)
 	})
 }
+
+// observeBodies records the time to first byte, the stream duration and the
+// body sizes of a request in the HTTP histograms
+func observeBodies(direction, transport string, ttfb, duration time.Duration, reqSize int64, reqOutcome string, respSize int64, respOutcome string) {
+	operations.HTTPHistogramTTFB.WithLabelValues(direction, transport, respOutcome).Observe(ttfb.Seconds())
+	operations.HTTPHistogramStreamDuration.WithLabelValues(direction, transport, respOutcome).Observe(duration.Seconds())
+	operations.HTTPHistogramBodySize.WithLabelValues(direction, transport, "request", reqOutcome).Observe(float64(reqSize))
+	operations.HTTPHistogramBodySize.WithLabelValues(direction, transport, "response", respOutcome).Observe(float64(respSize))
+}
+
+// transports of the requests in the access logs and the HTTP metrics
+const (
+	TransportH3     = "h3"
+	TransportH2     = "h2"
+	TransportHTTP11 = "http1.1"
+	TransportHTTP10 = "http1.0"
+)
+
+// Transport returns the transport of a request or a response from its
+// protocol version
+func Transport(major, minor int) string {
+	switch {
+	case major == 3:
+		return TransportH3
+	case major == 2:
+		return TransportH2
+	case major == 1 && minor == 0:
+		return TransportHTTP10
+	default:
+		return TransportHTTP11
+	}
+}
@@ -0,0 +1,189 @@
+package httplog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// optional interfaces of the response writers preserved by the logging
+// wrapper
+const (
+	supportsFlusher = 1 << iota
+	supportsHijacker
+	supportsReaderFrom
+	supportsH3Hijacker
+)
+
+// flusher, hijacker, readerFrom and h3Hijacker expose an optional interface
+// of the wrapped response writer, keeping the logged values accurate
+type flusher struct{ *loggingResponseWriter }
+
+func (f flusher) Flush() {
+	if f.firstByte.IsZero() {
+		f.firstByte = time.Now()
+	}
+	f.ResponseWriter.(http.Flusher).Flush()
+}
+
+type hijacker struct{ *loggingResponseWriter }
+
+func (h hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.hijacked = true
+	}
+	return c, rw, err
+}
+
+type readerFrom struct{ *loggingResponseWriter }
+
+func (rf readerFrom) ReadFrom(src io.Reader) (int64, error) {
+	if rf.firstByte.IsZero() {
+		rf.firstByte = time.Now()
+	}
+	n, err := rf.ResponseWriter.(io.ReaderFrom).ReadFrom(src)
+	rf.size += int(n)
+	if err != nil && rf.writeErr == nil {
+		rf.writeErr = err
+	}
+	return n, err
+}
+
+// streamHijacker is http3.Hijacker under another name, to embed it with
+// http.Hijacker
+type streamHijacker interface {
+	http3.Hijacker
+}
+
+type h3Hijacker struct{ *loggingResponseWriter }
+
+func (h h3Hijacker) StreamCreator() http3.StreamCreator {
+	return h.ResponseWriter.(http3.Hijacker).StreamCreator()
+}
+
+// wrapResponseWriter returns lrw with exactly the optional interfaces
+// (http.Flusher, http.Hijacker, io.ReaderFrom and http3.Hijacker) of the
+// response writer it wraps, so the handlers can still stream, upgrade or
+// take over the connection.
+func wrapResponseWriter(lrw *loggingResponseWriter) http.ResponseWriter {
+	var supported int
+	if _, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		supported |= supportsFlusher
+	}
+	if _, ok := lrw.ResponseWriter.(http.Hijacker); ok {
+		supported |= supportsHijacker
+	}
+	if _, ok := lrw.ResponseWriter.(io.ReaderFrom); ok {
+		supported |= supportsReaderFrom
+	}
+	if _, ok := lrw.ResponseWriter.(http3.Hijacker); ok {
+		supported |= supportsH3Hijacker
+	}
+
+	f := flusher{lrw}
+	h := hijacker{lrw}
+	rf := readerFrom{lrw}
+	h3 := h3Hijacker{lrw}
+
+	switch supported {
+	case supportsFlusher:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+		}{lrw, f}
+	case supportsHijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Hijacker
+		}{lrw, h}
+	case supportsFlusher | supportsHijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{lrw, f, h}
+	case supportsReaderFrom:
+		return struct {
+			*loggingResponseWriter
+			io.ReaderFrom
+		}{lrw, rf}
+	case supportsFlusher | supportsReaderFrom:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{lrw, f, rf}
+	case supportsHijacker | supportsReaderFrom:
+		return struct {
+			*loggingResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{lrw, h, rf}
+	case supportsFlusher | supportsHijacker | supportsReaderFrom:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{lrw, f, h, rf}
+	case supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			streamHijacker
+		}{lrw, h3}
+	case supportsFlusher | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			streamHijacker
+		}{lrw, f, h3}
+	case supportsHijacker | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Hijacker
+			streamHijacker
+		}{lrw, h, h3}
+	case supportsFlusher | supportsHijacker | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			http.Hijacker
+			streamHijacker
+		}{lrw, f, h, h3}
+	case supportsReaderFrom | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			io.ReaderFrom
+			streamHijacker
+		}{lrw, rf, h3}
+	case supportsFlusher | supportsReaderFrom | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			io.ReaderFrom
+			streamHijacker
+		}{lrw, f, rf, h3}
+	case supportsHijacker | supportsReaderFrom | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+			streamHijacker
+		}{lrw, h, rf, h3}
+	case supportsFlusher | supportsHijacker | supportsReaderFrom | supportsH3Hijacker:
+		return struct {
+			*loggingResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+			streamHijacker
+		}{lrw, f, h, rf, h3}
+	default:
+		return lrw
+	}
+}
@@ -0,0 +1,183 @@
+package httplog
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// interfaces returns the optional interfaces implemented by w
+func interfaces(w http.ResponseWriter) int {
+	var supported int
+	if _, ok := w.(http.Flusher); ok {
+		supported |= supportsFlusher
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		supported |= supportsHijacker
+	}
+	if _, ok := w.(io.ReaderFrom); ok {
+		supported |= supportsReaderFrom
+	}
+	if _, ok := w.(http3.Hijacker); ok {
+		supported |= supportsH3Hijacker
+	}
+	return supported
+}
+
+type hijackerOnly struct{ http.ResponseWriter }
+
+func (hijackerOnly) Hijack() (net.Conn, *bufio.ReadWriter, error) { return nil, nil, nil }
+
+type readerFromH3Hijacker struct{ http.ResponseWriter }
+
+func (readerFromH3Hijacker) ReadFrom(io.Reader) (int64, error)  { return 0, nil }
+func (readerFromH3Hijacker) StreamCreator() http3.StreamCreator { return nil }
+
+func TestWrapResponseWriter(t *testing.T) {
+	tests := []struct {
+		name string
+		w    http.ResponseWriter
+		want int
+	}{
+		{"no optional interface", struct{ http.ResponseWriter }{httptest.NewRecorder()}, 0},
+		{"recorder", httptest.NewRecorder(), supportsFlusher},
+		{"hijacker only", hijackerOnly{httptest.NewRecorder()}, supportsHijacker},
+		{"reader from and http3 hijacker", readerFromH3Hijacker{httptest.NewRecorder()}, supportsReaderFrom | supportsH3Hijacker},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := interfaces(tt.w); got != tt.want {
+				t.Fatalf("test writer implements %04b, want %04b", got, tt.want)
+			}
+			if got := interfaces(wrapResponseWriter(NewLoggingResponseWriter(tt.w))); got != tt.want {
+				t.Errorf("wrapped writer implements %04b, want %04b", got, tt.want)
+			}
+		})
+	}
+}
+
+// checkedHeader is set by interfacesHandler once the interfaces are checked
+const checkedHeader = "X-Interfaces-Checked"
+
+// interfacesHandler checks that the wrapped response writer has the optional
+// interfaces of the server writer, and that the wrapped request body can
+// still be taken over (http3.HTTPStreamer is implemented by the request body
+// in this quic-go version, not by the response writer)
+func interfacesHandler(t *testing.T, want int, wantStreamer bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := interfaces(w); got != want {
+			t.Errorf("server writer implements %04b, want %04b", got, want)
+		}
+		if got := interfaces(wrapResponseWriter(NewLoggingResponseWriter(w))); got != want {
+			t.Errorf("wrapped server writer implements %04b, want %04b", got, want)
+		}
+
+		_, streamer := r.Body.(http3.HTTPStreamer)
+		body, _ := wrapRequestBody(r)
+		if _, ok := body.(http3.HTTPStreamer); ok != streamer || ok != wantStreamer {
+			t.Errorf("wrapped request body is a streamer: %v, server body: %v, want %v", ok, streamer, wantStreamer)
+		}
+		w.Header().Set(checkedHeader, "1")
+	})
+}
+
+func TestWrapResponseWriterHTTP1(t *testing.T) {
+	srv := httptest.NewServer(interfacesHandler(t, supportsFlusher|supportsHijacker|supportsReaderFrom, false))
+	defer srv.Close()
+
+	resp, err := srv.Client().Post(srv.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(checkedHeader) == "" {
+		t.Fatal("interfaces not checked by the handler")
+	}
+}
+
+func TestWrapResponseWriterHTTP2(t *testing.T) {
+	srv := httptest.NewUnstartedServer(interfacesHandler(t, supportsFlusher, false))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Post(srv.URL, "text/plain", strings.NewReader("body"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("response proto %s, want HTTP/2", resp.Proto)
+	}
+	if resp.Header.Get(checkedHeader) == "" {
+		t.Fatal("interfaces not checked by the handler")
+	}
+}
+
+func TestWrapResponseWriterHTTP3(t *testing.T) {
+	udpConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udpConn.Close()
+
+	srv := &http3.Server{
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}},
+		Handler:   interfacesHandler(t, supportsFlusher|supportsH3Hijacker, true),
+	}
+	go srv.Serve(udpConn)
+	defer srv.Close()
+
+	rt := &http3.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer rt.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "https://"+udpConn.LocalAddr().String()+"/", strings.NewReader("body"))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get(checkedHeader) == "" {
+		t.Fatal("interfaces not checked by the handler")
+	}
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
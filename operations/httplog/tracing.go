@@ -0,0 +1,81 @@
+package httplog
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations/tracing"
+)
+
+// startServerSpan starts the span of the handler execution, child of the
+// trace context propagated by the client. The returned request carries the
+// span, so the handler can propagate it.
+func startServerSpan(r *http.Request, transport string) (*http.Request, trace.Span) {
+	ctx := tracing.Extract(r.Context(), r.Header)
+	ctx, span := tracing.Start(ctx, "HTTP "+r.Method, trace.SpanKindServer,
+		semconv.HTTPMethod(r.Method),
+		semconv.HTTPTarget(r.RequestURI),
+		semconv.ServerAddress(r.Host),
+		tracing.AttrTransport.String(transport),
+	)
+	if peerID := peerIDFromTLS(r.TLS); peerID != "" {
+		span.SetAttributes(tracing.AttrPeerIdentity.String(peerID))
+	}
+
+	return r.WithContext(ctx), span
+}
+
+// startClientSpan starts the span of the round trip and injects its trace
+// context in the headers of the returned request
+func startClientSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx, span := tracing.Start(tracing.RequestContext(r), "HTTP "+r.Method, trace.SpanKindClient,
+		semconv.HTTPMethod(r.Method),
+		semconv.HTTPURL(r.URL.String()),
+		semconv.ServerAddress(r.Host),
+	)
+
+	traced := r.WithContext(ctx)
+	traced.Header = r.Header.Clone()
+	if traced.Header == nil {
+		traced.Header = make(http.Header)
+	}
+	tracing.Inject(ctx, traced.Header)
+
+	return traced, span
+}
+
+// endSpan ends the span of a request with the status and the body outcome
+// of its response
+func endSpan(span trace.Span, statusCode int, outcome string, attrs ...attribute.KeyValue) {
+	span.SetAttributes(semconv.HTTPStatusCode(statusCode), attribute.String("quicsec.resp_body", outcome))
+	span.SetAttributes(attrs...)
+
+	var err error
+	if statusCode >= http.StatusInternalServerError {
+		err = statusError(statusCode)
+	}
+	tracing.End(span, err)
+}
+
+type statusError int
+
+func (e statusError) Error() string {
+	return http.StatusText(int(e))
+}
+
+func peerIDFromTLS(state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	id, err := identity.IDFromCert(state.PeerCertificates[0])
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
@@ -0,0 +1,193 @@
+package operations
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+// keyLogGate is the TLS key log writer of the connections: the secrets are
+// written to the key log file of quic.debug.secret_path, if any, and to the
+// capture file of the window opened by the admin API until it expires
+type keyLogGate struct {
+	static io.Writer
+
+	mutex  sync.Mutex
+	window *os.File
+	until  time.Time
+	timer  *time.Timer
+
+	// the last write of the file (key_log) or of the window failed
+	failing map[string]bool
+}
+
+// Write never fails: an error of the key log must not fail the TLS
+// handshakes, it is logged and counted instead
+func (g *keyLogGate) Write(p []byte) (int, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.static != nil {
+		_, err := g.static.Write(p)
+		g.observe("key_log", err)
+	}
+
+	if g.window != nil {
+		if time.Now().After(g.until) {
+			g.closeWindow()
+		} else {
+			_, err := g.window.Write(p)
+			g.observe("window", err)
+		}
+	}
+
+	return len(p), nil
+}
+
+// observe counts the failed writes of file and logs the first failure and
+// the recovery of a series. Must be called with the gate locked.
+func (g *keyLogGate) observe(file string, err error) {
+	if err != nil && keyLogWriteErrors != nil {
+		keyLogWriteErrors.WithLabelValues(file).Inc()
+	}
+	if (err != nil) == g.failing[file] {
+		return
+	}
+
+	if g.failing == nil {
+		g.failing = make(map[string]bool)
+	}
+	g.failing[file] = err != nil
+
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+	if err != nil {
+		opsLogger.Error(err, "failed to write the TLS secrets, the key log is incomplete", "file", file)
+	} else {
+		opsLogger.Info("TLS secrets written again", "file", file)
+	}
+}
+
+// openWindow writes the secrets to a new file of dir for d, a window already
+// open is extended
+func (g *keyLogGate) openWindow(dir string, d time.Duration) (string, time.Time, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.window == nil || time.Now().After(g.until) {
+		g.closeWindow()
+
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return "", time.Time{}, err
+		}
+		name := fmt.Sprintf("keylog_%s.txt", time.Now().UTC().Format("2006-01-02T15-04-05UTC"))
+		f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		g.window = f
+	}
+	g.until = time.Now().Add(d)
+
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	g.timer = time.AfterFunc(d, g.expire)
+
+	return filepath.Base(g.window.Name()), g.until, nil
+}
+
+// status returns the file and the end of the open window, if any
+func (g *keyLogGate) status() (string, time.Time, bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.window == nil || time.Now().After(g.until) {
+		return "", time.Time{}, false
+	}
+	return filepath.Base(g.window.Name()), g.until, true
+}
+
+// expire closes the window once it expired, unless it was extended
+func (g *keyLogGate) expire() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if time.Now().After(g.until) {
+		g.closeWindow()
+	}
+}
+
+func (g *keyLogGate) stopWindow() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.closeWindow()
+}
+
+// closeWindow must be called with the gate locked
+func (g *keyLogGate) closeWindow() {
+	if g.window != nil {
+		g.window.Close()
+		g.window = nil
+	}
+}
+
+// PeerKeyLog is the key log writer of a single connection when
+// quic.debug.secret_peers is set: the secrets are held until the peer is
+// verified, then written to the key log if the peer is selected and dropped
+// otherwise
+type PeerKeyLog struct {
+	w io.Writer
+
+	mutex    sync.Mutex
+	decided  bool
+	selected bool
+	pending  [][]byte
+}
+
+func NewPeerKeyLog(w io.Writer) *PeerKeyLog {
+	return &PeerKeyLog{w: w}
+}
+
+func (k *PeerKeyLog) Write(p []byte) (int, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if !k.decided {
+		// the TLS stack reuses its buffer
+		k.pending = append(k.pending, append([]byte(nil), p...))
+		return len(p), nil
+	}
+	if !k.selected {
+		return len(p), nil
+	}
+
+	return k.w.Write(p)
+}
+
+// SetPeerID writes the held secrets when peerID is selected by
+// quic.debug.secret_peers, an empty peerID (unverified peer) is never
+// selected
+func (k *PeerKeyLog) SetPeerID(peerID string) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.decided {
+		return
+	}
+	k.decided = true
+	k.selected = peerID != "" && config.IsKeyLogPeer(peerID)
+
+	if k.selected {
+		for _, p := range k.pending {
+			k.w.Write(p)
+		}
+	}
+	k.pending = nil
+}
@@ -0,0 +1,74 @@
+package operations
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+type failingWriter struct{ err error }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return len(p), nil
+}
+
+func TestKeyLogGateWrite(t *testing.T) {
+	prev := keyLogWriteErrors
+	keyLogWriteErrors = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_key_log_write_errors_total"}, []string{"file"})
+	defer func() { keyLogWriteErrors = prev }()
+
+	static := &failingWriter{err: errors.New("disk full")}
+	g := &keyLogGate{static: static}
+	dir := t.TempDir()
+	if _, _, err := g.openWindow(dir, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer g.stopWindow()
+
+	// a failed key log never fails the handshake
+	line := []byte("CLIENT_HANDSHAKE_TRAFFIC_SECRET 00 11\n")
+	for i := 0; i < 3; i++ {
+		if n, err := g.Write(line); n != len(line) || err != nil {
+			t.Fatalf("Write() = %d, %v", n, err)
+		}
+	}
+	if got := testutil.ToFloat64(keyLogWriteErrors.WithLabelValues("key_log")); got != 3 {
+		t.Errorf("%g key_log write errors, want 3", got)
+	}
+	if !g.failing["key_log"] {
+		t.Error("key_log not failing")
+	}
+
+	// the window still gets the secrets
+	files, _ := filepath.Glob(filepath.Join(dir, "keylog_*.txt"))
+	if len(files) != 1 {
+		t.Fatalf("%d window files", len(files))
+	}
+	if data, _ := os.ReadFile(files[0]); len(data) != 3*len(line) {
+		t.Errorf("window has %d bytes, want %d", len(data), 3*len(line))
+	}
+
+	// a failed window too
+	g.window.Close()
+	if n, err := g.Write(line); n != len(line) || err != nil {
+		t.Fatalf("Write() = %d, %v with a failed window", n, err)
+	}
+	if got := testutil.ToFloat64(keyLogWriteErrors.WithLabelValues("window")); got != 1 {
+		t.Errorf("%g window write errors, want 1", got)
+	}
+
+	// the recovery ends the series
+	static.err = nil
+	g.Write(line)
+	if g.failing["key_log"] {
+		t.Error("key_log still failing")
+	}
+}
@@ -18,6 +18,7 @@ const (
 	ConstConnManager       = "connection_manager"
 	ConstAuthManager       = "authentication_manager"
 	ConstQuicSecGeneral    = "quicsec_general"
+	ConstProxy             = "proxy"
 )
 
 const (
@@ -52,6 +53,7 @@ func InitLoggerLogr(debug bool, filePath string) {
 	}
 
 	z, _ := zapconf.Build()
+	z = teeShipCore(z, zapconf.Level, "quicsec")
 
 	LoggerLgr = zapr.NewLogger(z).WithName("Quicsec")
 	LoggerLgr.WithName(ConstOperationsManager).Info("logger initialization")
@@ -88,5 +90,5 @@ func InitLoggerRequest(debug bool, filePath string) {
 	LoggerLgr.WithName(ConstOperationsManager).Info(msgLogFile, "path", filePath)
 
 	z, _ := zapconf.Build()
-	LoggerRequest = z
+	LoggerRequest = teeShipCore(z, zapconf.Level, "access")
 }
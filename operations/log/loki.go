@@ -0,0 +1,165 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiPushPath is the push API of Loki
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiOptions configure the shipping of the logs to the push API of Loki
+type LokiOptions struct {
+	ShipperOptions
+
+	// base URL of Loki, the entries are pushed to <URL>/loki/api/v1/push
+	URL string
+	// X-Scope-OrgID of the multi-tenant deployments, if set
+	TenantID string
+	// static labels of the streams, added to component, level and identity
+	Labels map[string]string
+
+	Timeout time.Duration
+	// retries of the pushes failing with a network error, 429 or 5xx
+	MaxRetries int
+}
+
+type lokiSender struct {
+	opts   LokiOptions
+	url    string
+	client *http.Client
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiPush struct {
+	Streams []*lokiStream `json:"streams"`
+}
+
+// lokiError is a push rejected by Loki
+type lokiError struct {
+	status int
+	body   string
+}
+
+func (e *lokiError) Error() string {
+	return fmt.Sprintf("loki: push rejected (%d): %s", e.status, e.body)
+}
+
+func newLokiSender(opts LokiOptions) (*lokiSender, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil {
+		return nil, fmt.Errorf("loki: invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("loki: invalid url %q, http or https required", opts.URL)
+	}
+
+	return &lokiSender{
+		opts:   opts,
+		url:    strings.TrimSuffix(opts.URL, "/") + lokiPushPath,
+		client: &http.Client{Timeout: opts.Timeout},
+	}, nil
+}
+
+// send pushes batch, one stream per label set, retrying the transient
+// failures with an exponential backoff
+func (l *lokiSender) send(batch []shipEntry) (int, error) {
+	body, err := json.Marshal(l.streams(batch))
+	if err != nil {
+		return 0, err
+	}
+
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = l.push(body)
+		if err == nil {
+			return len(batch), nil
+		}
+
+		lokiErr, rejected := err.(*lokiError)
+		retryable := !rejected || lokiErr.status == http.StatusTooManyRequests || lokiErr.status >= 500
+		if !retryable || attempt >= l.opts.MaxRetries {
+			return 0, err
+		}
+
+		time.Sleep(backoff)
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (l *lokiSender) streams(batch []shipEntry) lokiPush {
+	var push lokiPush
+	streams := make(map[string]*lokiStream)
+
+	for _, e := range batch {
+		labels := make(map[string]string, len(l.opts.Labels)+3)
+		for k, v := range l.opts.Labels {
+			labels[k] = v
+		}
+		labels["component"] = e.component
+		labels["level"] = e.level.String()
+		if e.identity != "" {
+			labels["identity"] = e.identity
+		}
+
+		key := lokiStreamKey(labels)
+		s, ok := streams[key]
+		if !ok {
+			s = &lokiStream{Stream: labels}
+			streams[key] = s
+			push.Streams = append(push.Streams, s)
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(e.time.UnixNano(), 10), e.line})
+	}
+
+	return push
+}
+
+func lokiStreamKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k, v := range labels {
+		keys = append(keys, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
+}
+
+func (l *lokiSender) push(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, l.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.opts.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.opts.TenantID)
+	}
+
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &lokiError{status: resp.StatusCode, body: strings.TrimSpace(string(msg))}
+	}
+	io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
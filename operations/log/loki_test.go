@@ -0,0 +1,203 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLokiStreamKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{"empty", map[string]string{}, ""},
+		{"sorted", map[string]string{"level": "info", "component": "http"}, `component="http",level="info"`},
+		{"quoted", map[string]string{"env": `a"b,c=d`}, `env="a\"b,c=d"`},
+		{"same labels, any order", map[string]string{"b": "2", "a": "1", "c": "3"}, `a="1",b="2",c="3"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lokiStreamKey(tt.labels); got != tt.want {
+				t.Errorf("lokiStreamKey() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// the separators inside the values don't merge distinct label sets
+	a := lokiStreamKey(map[string]string{"a": `1",b="2`})
+	b := lokiStreamKey(map[string]string{"a": "1", "b": "2"})
+	if a == b {
+		t.Errorf("distinct label sets share the key %s", a)
+	}
+}
+
+// fakeLoki is a Loki push API answering with statuses, 204 once they are
+// used up
+type fakeLoki struct {
+	mutex    sync.Mutex
+	statuses []int
+	pushes   []lokiPush
+	tenants  []string
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if r.URL.Path != lokiPushPath || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var push lokiPush
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.pushes = append(f.pushes, push)
+	f.tenants = append(f.tenants, r.Header.Get("X-Scope-OrgID"))
+
+	status := http.StatusNoContent
+	if len(f.statuses) > 0 {
+		status, f.statuses = f.statuses[0], f.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestLokiSenderStreams(t *testing.T) {
+	loki := &fakeLoki{}
+	srv := httptest.NewServer(loki)
+	defer srv.Close()
+
+	sender, err := newLokiSender(LokiOptions{URL: srv.URL + "/", TenantID: "team-a", Labels: map[string]string{"env": "test"}, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 42)
+	batch := []shipEntry{
+		{time: now, level: zapcore.InfoLevel, component: "http", identity: "spiffe://test.org/app", line: `{"msg":"a"}`},
+		{time: now, level: zapcore.ErrorLevel, component: "http", identity: "spiffe://test.org/app", line: `{"msg":"b"}`},
+		{time: now.Add(time.Second), level: zapcore.InfoLevel, component: "http", identity: "spiffe://test.org/app", line: `{"msg":"c"}`},
+		{time: now, level: zapcore.InfoLevel, component: "config", line: `{"msg":"d"}`},
+	}
+	if n, err := sender.send(batch); n != len(batch) || err != nil {
+		t.Fatalf("send() = %d, %v", n, err)
+	}
+
+	if len(loki.pushes) != 1 || loki.tenants[0] != "team-a" {
+		t.Fatalf("%d pushes, tenants %q", len(loki.pushes), loki.tenants)
+	}
+	streams := loki.pushes[0].Streams
+	if len(streams) != 3 {
+		t.Fatalf("%d streams, want 3", len(streams))
+	}
+
+	want := []struct {
+		labels map[string]string
+		lines  []string
+	}{
+		{map[string]string{"env": "test", "component": "http", "level": "info", "identity": "spiffe://test.org/app"}, []string{`{"msg":"a"}`, `{"msg":"c"}`}},
+		{map[string]string{"env": "test", "component": "http", "level": "error", "identity": "spiffe://test.org/app"}, []string{`{"msg":"b"}`}},
+		{map[string]string{"env": "test", "component": "config", "level": "info"}, []string{`{"msg":"d"}`}},
+	}
+	for i, w := range want {
+		if got, want := lokiStreamKey(streams[i].Stream), lokiStreamKey(w.labels); got != want {
+			t.Errorf("stream %d labels %s, want %s", i, got, want)
+		}
+		if len(streams[i].Values) != len(w.lines) {
+			t.Errorf("stream %d has %d values, want %d", i, len(streams[i].Values), len(w.lines))
+			continue
+		}
+		for j, line := range w.lines {
+			if streams[i].Values[j][1] != line {
+				t.Errorf("stream %d value %d = %s, want %s", i, j, streams[i].Values[j][1], line)
+			}
+		}
+	}
+	if ts := streams[0].Values[0][0]; ts != strconv.FormatInt(now.UnixNano(), 10) {
+		t.Errorf("timestamp %s, want %d", ts, now.UnixNano())
+	}
+}
+
+func TestLokiSenderRetries(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []int
+		maxRetries int
+		wantPushes int
+		wantSent   int
+		wantStatus int
+	}{
+		{"accepted", nil, 2, 1, 1, 0},
+		{"too many requests then accepted", []int{http.StatusTooManyRequests}, 2, 2, 1, 0},
+		{"server errors then accepted", []int{http.StatusServiceUnavailable, http.StatusInternalServerError}, 2, 3, 1, 0},
+		{"retries exhausted", []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}, 2, 3, 0, http.StatusBadGateway},
+		{"no retries", []int{http.StatusServiceUnavailable}, 0, 1, 0, http.StatusServiceUnavailable},
+		{"bad request not retried", []int{http.StatusBadRequest}, 2, 1, 0, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loki := &fakeLoki{statuses: tt.statuses}
+			srv := httptest.NewServer(loki)
+			defer srv.Close()
+
+			sender, err := newLokiSender(LokiOptions{URL: srv.URL, Timeout: time.Second, MaxRetries: tt.maxRetries})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := sender.send([]shipEntry{entry("line")})
+			if n != tt.wantSent {
+				t.Errorf("%d entries sent, want %d", n, tt.wantSent)
+			}
+			if len(loki.pushes) != tt.wantPushes {
+				t.Errorf("%d pushes, want %d", len(loki.pushes), tt.wantPushes)
+			}
+
+			lokiErr, _ := err.(*lokiError)
+			switch {
+			case tt.wantStatus == 0 && err != nil:
+				t.Errorf("send() error = %v", err)
+			case tt.wantStatus != 0 && (lokiErr == nil || lokiErr.status != tt.wantStatus):
+				t.Errorf("send() error = %v, want status %d", err, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestLokiSenderNetworkError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	sender, err := newLokiSender(LokiOptions{URL: srv.URL, Timeout: time.Second, MaxRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if n, err := sender.send([]shipEntry{entry("line")}); n != 0 || err == nil {
+		t.Errorf("send() = %d, %v with the receiver down", n, err)
+	}
+	// retried once after the backoff
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("failed after %s, without retry", elapsed)
+	}
+}
+
+func TestNewLokiSender(t *testing.T) {
+	for _, u := range []string{"localhost:3100", "ftp://loki", "://"} {
+		if _, err := newLokiSender(LokiOptions{URL: u}); err == nil {
+			t.Errorf("newLokiSender(%q) succeeded", u)
+		}
+	}
+}
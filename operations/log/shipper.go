@@ -0,0 +1,382 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ShipperOptions are the batching and the backpressure of a log shipper
+type ShipperOptions struct {
+	// entries sent together, and the max wait of a partial batch
+	BatchSize int
+	BatchWait time.Duration
+	// entries waiting to be sent, the new entries are dropped once the queue
+	// is full and BlockTimeout expired (0 drops them immediately)
+	QueueSize    int
+	BlockTimeout time.Duration
+}
+
+// ShippingOptions are the log shippers started by InitShipping, nil
+// disables a shipper
+type ShippingOptions struct {
+	Loki   *LokiOptions
+	Syslog *SyslogOptions
+}
+
+// ShipperStats are the counters of a log shipper
+type ShipperStats struct {
+	Sink string
+	Sent uint64
+	// dropped entries by reason
+	QueueFull  uint64
+	SendFailed uint64
+}
+
+// shipEntry is a log entry encoded as a JSON line
+type shipEntry struct {
+	time      time.Time
+	level     zapcore.Level
+	component string
+	identity  string
+	line      string
+}
+
+// shipSender sends a batch of entries and returns how many were sent
+type shipSender interface {
+	send(batch []shipEntry) (int, error)
+}
+
+// shipper sends the entries of its queue in batches from its own goroutine,
+// so the logging never waits for the network (up to BlockTimeout when the
+// queue is full)
+type shipper struct {
+	sink   string
+	opts   ShipperOptions
+	sender shipSender
+
+	queue chan shipEntry
+	flush chan chan struct{}
+	// closed by stop, run sends the queued entries and closes done
+	stopping chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	sent       uint64
+	queueFull  uint64
+	sendFailed uint64
+
+	// reported on stderr once until a batch is sent again
+	failing bool
+}
+
+var (
+	shippers []*shipper
+	identity atomic.Value
+)
+
+// InitShipping starts the log shippers, the loggers initialized afterwards
+// by InitLoggerLogr and InitLoggerRequest write to them as well
+func InitShipping(opts ShippingOptions) error {
+	if opts.Loki != nil {
+		sender, err := newLokiSender(*opts.Loki)
+		if err != nil {
+			return err
+		}
+		shippers = append(shippers, newShipper("loki", opts.Loki.ShipperOptions, sender))
+	}
+
+	if opts.Syslog != nil {
+		sender, err := newSyslogSender(*opts.Syslog)
+		if err != nil {
+			return err
+		}
+		shippers = append(shippers, newShipper("syslog", opts.Syslog.ShipperOptions, sender))
+	}
+
+	return nil
+}
+
+// StopShipping sends the queued entries and stops the log shippers, waiting
+// for them until ctx is done. The entries logged afterwards are dropped.
+func StopShipping(ctx context.Context) error {
+	for _, s := range shippers {
+		s.stop()
+	}
+
+	for _, s := range shippers {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// SetIdentity sets the SPIFFE ID of the workload, added to the shipped
+// entries (Loki label, spiffe_id field)
+func SetIdentity(id string) {
+	identity.Store(id)
+}
+
+// ShippersStats returns the counters of the running log shippers
+func ShippersStats() []ShipperStats {
+	var stats []ShipperStats
+	for _, s := range shippers {
+		stats = append(stats, ShipperStats{
+			Sink:       s.sink,
+			Sent:       atomic.LoadUint64(&s.sent),
+			QueueFull:  atomic.LoadUint64(&s.queueFull),
+			SendFailed: atomic.LoadUint64(&s.sendFailed),
+		})
+	}
+	return stats
+}
+
+func newShipper(sink string, opts ShipperOptions, sender shipSender) *shipper {
+	s := &shipper{
+		sink:     sink,
+		opts:     opts,
+		sender:   sender,
+		queue:    make(chan shipEntry, opts.QueueSize),
+		flush:    make(chan chan struct{}),
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.run()
+
+	return s
+}
+
+// enqueue adds e to the queue, waiting up to BlockTimeout when it is full
+func (s *shipper) enqueue(e shipEntry) {
+	select {
+	case <-s.stopping:
+		return
+	default:
+	}
+
+	select {
+	case s.queue <- e:
+		return
+	default:
+	}
+
+	if s.opts.BlockTimeout > 0 {
+		timer := time.NewTimer(s.opts.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.queue <- e:
+			return
+		case <-timer.C:
+		}
+	}
+
+	atomic.AddUint64(&s.queueFull, 1)
+}
+
+// sync sends the entries queued so far, waiting at most 5s
+func (s *shipper) sync() {
+	done := make(chan struct{})
+	select {
+	case s.flush <- done:
+	case <-s.done:
+		return
+	case <-time.After(5 * time.Second):
+		return
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+	}
+}
+
+// stop makes run send the queued entries and return
+func (s *shipper) stop() {
+	s.stopOnce.Do(func() { close(s.stopping) })
+}
+
+func (s *shipper) run() {
+	ticker := time.NewTicker(s.opts.BatchWait)
+	defer ticker.Stop()
+
+	batch := make([]shipEntry, 0, s.opts.BatchSize)
+	for {
+		select {
+		case e := <-s.queue:
+			batch = append(batch, e)
+			if len(batch) >= s.opts.BatchSize {
+				s.ship(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				s.ship(batch)
+				batch = batch[:0]
+			}
+		case done := <-s.flush:
+			batch = s.drain(batch)
+			close(done)
+		case <-s.stopping:
+			s.drain(batch)
+			close(s.done)
+			return
+		}
+	}
+}
+
+// drain sends batch and the entries queued so far
+func (s *shipper) drain(batch []shipEntry) []shipEntry {
+	for n := len(s.queue); n > 0; n-- {
+		batch = append(batch, <-s.queue)
+		if len(batch) >= s.opts.BatchSize {
+			s.ship(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		s.ship(batch)
+		batch = batch[:0]
+	}
+	return batch
+}
+
+// ship sends batch, the entries not sent are dropped. The failures can't be
+// logged (the entries would be shipped again), they are reported on stderr.
+func (s *shipper) ship(batch []shipEntry) {
+	n, err := s.sender.send(batch)
+	atomic.AddUint64(&s.sent, uint64(n))
+
+	if err != nil {
+		atomic.AddUint64(&s.sendFailed, uint64(len(batch)-n))
+		if !s.failing {
+			fmt.Fprintf(os.Stderr, "log: %s shipper failed, entries dropped: %v\n", s.sink, err)
+			s.failing = true
+		}
+		return
+	}
+	s.failing = false
+}
+
+// shipCore is the zap core writing the entries to the log shippers, encoded
+// as JSON lines
+type shipCore struct {
+	zapcore.LevelEnabler
+
+	enc zapcore.Encoder
+	// component of the entries of the unnamed loggers
+	component string
+	shippers  []*shipper
+}
+
+// newShipCore returns the core of the running shippers, nil without
+// shipper
+func newShipCore(level zapcore.LevelEnabler, component string) zapcore.Core {
+	if len(shippers) == 0 {
+		return nil
+	}
+
+	encConf := zap.NewProductionEncoderConfig()
+	encConf.EncodeTime = zapcore.RFC3339NanoTimeEncoder
+
+	return &shipCore{
+		LevelEnabler: level,
+		enc:          zapcore.NewJSONEncoder(encConf),
+		component:    component,
+		shippers:     shippers,
+	}
+}
+
+// teeShipCore adds the core of the log shippers to the cores of z
+func teeShipCore(z *zap.Logger, level zapcore.LevelEnabler, component string) *zap.Logger {
+	shipCore := newShipCore(level, component)
+	if shipCore == nil {
+		return z
+	}
+
+	return z.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(core, shipCore)
+	}))
+}
+
+func (c *shipCore) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for _, f := range fields {
+		f.AddTo(clone.enc)
+	}
+	return &clone
+}
+
+func (c *shipCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *shipCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	id, _ := identity.Load().(string)
+	if id != "" {
+		fields = append(fields[:len(fields):len(fields)], zap.String("spiffe_id", id))
+	}
+
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+
+	component := ent.LoggerName
+	if component == "" {
+		component = c.component
+	}
+
+	e := shipEntry{
+		time:      ent.Time,
+		level:     ent.Level,
+		component: component,
+		identity:  id,
+		line:      line,
+	}
+	for _, s := range c.shippers {
+		s.enqueue(e)
+	}
+
+	// the process may exit after a panic or fatal entry
+	if ent.Level > zapcore.ErrorLevel {
+		c.flush()
+	}
+	return nil
+}
+
+// Sync doesn't wait for the shippers: the loggers are synced after each
+// request, the entries are sent in the background and flushed by
+// StopShipping
+func (c *shipCore) Sync() error {
+	return nil
+}
+
+// flush sends the entries queued so far, waiting at most 5s per shipper
+func (c *shipCore) flush() {
+	var wg sync.WaitGroup
+	for _, s := range c.shippers {
+		wg.Add(1)
+		go func(s *shipper) {
+			defer wg.Done()
+			s.sync()
+		}(s)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,236 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// fakeSender records the batches, each send waits for release when set
+type fakeSender struct {
+	mutex   sync.Mutex
+	batches [][]shipEntry
+	err     error
+
+	received chan struct{}
+	release  chan struct{}
+}
+
+func (f *fakeSender) send(batch []shipEntry) (int, error) {
+	if f.received != nil {
+		f.received <- struct{}{}
+	}
+	if f.release != nil {
+		<-f.release
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.batches = append(f.batches, append([]shipEntry(nil), batch...))
+	return len(batch), nil
+}
+
+func (f *fakeSender) sizes() []int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var sizes []int
+	for _, b := range f.batches {
+		sizes = append(sizes, len(b))
+	}
+	return sizes
+}
+
+func entry(line string) shipEntry {
+	return shipEntry{time: time.Now(), level: zapcore.InfoLevel, component: "test", line: line}
+}
+
+// stopShipper stops s and waits for its goroutine
+func stopShipper(t *testing.T, s *shipper) {
+	s.stop()
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shipper not stopped")
+	}
+}
+
+func TestShipperBatches(t *testing.T) {
+	sender := &fakeSender{}
+	s := newShipper("test", ShipperOptions{BatchSize: 3, BatchWait: time.Hour, QueueSize: 10}, sender)
+
+	for i := 0; i < 7; i++ {
+		s.enqueue(entry("line"))
+	}
+	// the partial batch is sent by the stop
+	stopShipper(t, s)
+
+	if got := sender.sizes(); len(got) != 3 || got[0] != 3 || got[1] != 3 || got[2] != 1 {
+		t.Errorf("batches of %v, want [3 3 1]", got)
+	}
+	if sent := atomic.LoadUint64(&s.sent); sent != 7 {
+		t.Errorf("%d entries sent, want 7", sent)
+	}
+
+	// the entries logged after the stop are dropped without waiting
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		s.enqueue(entry("late"))
+	}
+	if time.Since(start) > time.Second {
+		t.Error("enqueue blocked after the stop")
+	}
+	if got := sender.sizes(); len(got) != 3 {
+		t.Errorf("entries sent after the stop: %v", got)
+	}
+}
+
+func TestShipperBatchWait(t *testing.T) {
+	sender := &fakeSender{received: make(chan struct{}, 1)}
+	s := newShipper("test", ShipperOptions{BatchSize: 100, BatchWait: 10 * time.Millisecond, QueueSize: 10}, sender)
+	defer stopShipper(t, s)
+
+	s.enqueue(entry("line"))
+	select {
+	case <-sender.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("partial batch not sent after the batch wait")
+	}
+}
+
+func TestShipperQueueFull(t *testing.T) {
+	sender := &fakeSender{received: make(chan struct{}, 10), release: make(chan struct{})}
+	s := newShipper("test", ShipperOptions{BatchSize: 1, BatchWait: time.Hour, QueueSize: 2}, sender)
+
+	// the first entry is being sent, the queue holds the next 2 entries
+	s.enqueue(entry("sending"))
+	<-sender.received
+	for i := 0; i < 5; i++ {
+		s.enqueue(entry("queued"))
+	}
+
+	if n := atomic.LoadUint64(&s.queueFull); n != 3 {
+		t.Errorf("%d entries dropped, want 3", n)
+	}
+
+	close(sender.release)
+	stopShipper(t, s)
+	if n := atomic.LoadUint64(&s.sent); n != 3 {
+		t.Errorf("%d entries sent, want 3", n)
+	}
+}
+
+func TestShipperBlockTimeout(t *testing.T) {
+	sender := &fakeSender{received: make(chan struct{}, 10), release: make(chan struct{})}
+	s := newShipper("test", ShipperOptions{BatchSize: 1, BatchWait: time.Hour, QueueSize: 1, BlockTimeout: 50 * time.Millisecond}, sender)
+
+	s.enqueue(entry("sending"))
+	<-sender.received
+	s.enqueue(entry("queued"))
+
+	start := time.Now()
+	s.enqueue(entry("dropped"))
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("dropped after %s, want the block timeout", waited)
+	}
+	if n := atomic.LoadUint64(&s.queueFull); n != 1 {
+		t.Errorf("%d entries dropped, want 1", n)
+	}
+
+	close(sender.release)
+	stopShipper(t, s)
+}
+
+func TestShipperSendFailed(t *testing.T) {
+	sender := &fakeSender{err: errors.New("receiver down")}
+	s := newShipper("test", ShipperOptions{BatchSize: 2, BatchWait: time.Hour, QueueSize: 10}, sender)
+
+	for i := 0; i < 5; i++ {
+		s.enqueue(entry("line"))
+	}
+	stopShipper(t, s)
+
+	if n := atomic.LoadUint64(&s.sendFailed); n != 5 {
+		t.Errorf("%d entries failed, want 5", n)
+	}
+	if n := atomic.LoadUint64(&s.sent); n != 0 {
+		t.Errorf("%d entries sent, want 0", n)
+	}
+}
+
+func TestShipCoreSync(t *testing.T) {
+	sender := &fakeSender{received: make(chan struct{}, 10), release: make(chan struct{})}
+	s := newShipper("test", ShipperOptions{BatchSize: 100, BatchWait: time.Hour, QueueSize: 10}, sender)
+	prev := shippers
+	shippers = []*shipper{s}
+	defer func() { shippers = prev }()
+
+	core := newShipCore(zapcore.DebugLevel, "test")
+	if err := core.Write(zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "request"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sync doesn't wait for the network
+	done := make(chan struct{})
+	go func() {
+		core.Sync()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Sync blocked on the shipper")
+	}
+	if len(sender.received) != 0 {
+		t.Error("entries sent by Sync")
+	}
+
+	// a panic entry flushes the shippers
+	close(sender.release)
+	if err := core.Write(zapcore.Entry{Level: zapcore.PanicLevel, Time: time.Now(), Message: "panic"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := sender.sizes(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("batches of %v after the panic entry, want [2]", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := StopShipping(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopShipping(t *testing.T) {
+	sender := &fakeSender{release: make(chan struct{})}
+	s := newShipper("test", ShipperOptions{BatchSize: 100, BatchWait: time.Hour, QueueSize: 10}, sender)
+	prev := shippers
+	shippers = []*shipper{s}
+	defer func() { shippers = prev }()
+
+	s.enqueue(entry("line"))
+
+	// the wait is bounded by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := StopShipping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("StopShipping() = %v with a blocked receiver", err)
+	}
+
+	close(sender.release)
+	if err := StopShipping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := sender.sizes(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("batches of %v, want [1]", got)
+	}
+}
@@ -0,0 +1,187 @@
+package log
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// syslog facilities (RFC 5424)
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// ParseSyslogFacility returns the code of the syslog facility name (e.g.
+// "local0")
+func ParseSyslogFacility(name string) (int, error) {
+	facility, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return facility, nil
+}
+
+// SyslogOptions configure the shipping of the logs to a syslog receiver
+type SyslogOptions struct {
+	ShipperOptions
+
+	// udp, tcp or unix (datagram socket like /dev/log, stream otherwise)
+	Network  string
+	Address  string
+	Facility int
+	AppName  string
+}
+
+// syslogSender writes the entries as RFC 5424 messages, one datagram per
+// message or octet counted (RFC 6587) over the stream transports
+type syslogSender struct {
+	opts     SyslogOptions
+	hostname string
+	procID   string
+
+	conn   net.Conn
+	stream bool
+}
+
+func newSyslogSender(opts SyslogOptions) (*syslogSender, error) {
+	switch opts.Network {
+	case "udp", "tcp", "unix":
+	default:
+		return nil, fmt.Errorf("syslog: unknown network %q", opts.Network)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &syslogSender{
+		opts:     opts,
+		hostname: syslogField(hostname, 255),
+		procID:   strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+// send writes batch, the connection is dialed again after an error so a
+// restarted receiver gets the next entries
+func (s *syslogSender) send(batch []shipEntry) (int, error) {
+	sent := 0
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err := s.dial(); err != nil {
+				return sent, err
+			}
+		}
+
+		var err error
+		for ; sent < len(batch); sent++ {
+			if err = s.write(s.format(batch[sent])); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return sent, nil
+		}
+
+		s.conn.Close()
+		s.conn = nil
+		if attempt == 1 {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
+
+func (s *syslogSender) dial() error {
+	const timeout = 5 * time.Second
+
+	if s.opts.Network != "unix" {
+		conn, err := net.DialTimeout(s.opts.Network, s.opts.Address, timeout)
+		if err != nil {
+			return err
+		}
+		s.conn, s.stream = conn, s.opts.Network == "tcp"
+		return nil
+	}
+
+	// the local syslog daemons usually listen on a datagram socket
+	if conn, err := net.DialTimeout("unixgram", s.opts.Address, timeout); err == nil {
+		s.conn, s.stream = conn, false
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", s.opts.Address, timeout)
+	if err != nil {
+		return err
+	}
+	s.conn, s.stream = conn, true
+
+	return nil
+}
+
+func (s *syslogSender) write(msg string) error {
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+
+	if s.stream {
+		msg = strconv.Itoa(len(msg)) + " " + msg
+	}
+	_, err := s.conn.Write([]byte(msg))
+
+	return err
+}
+
+// format returns the RFC 5424 message of e, the component is the MSGID and
+// the message is the JSON line of the entry
+func (s *syslogSender) format(e shipEntry) string {
+	pri := s.opts.Facility*8 + syslogSeverity(e.level)
+
+	return fmt.Sprintf("<%d>1 %s %s %s %s %s - %s",
+		pri,
+		e.time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+		syslogField(s.opts.AppName, 48),
+		s.procID,
+		syslogField(e.component, 32),
+		e.line,
+	)
+}
+
+func syslogSeverity(level zapcore.Level) int {
+	switch {
+	case level <= zapcore.DebugLevel:
+		return 7
+	case level == zapcore.InfoLevel:
+		return 6
+	case level == zapcore.WarnLevel:
+		return 4
+	case level == zapcore.ErrorLevel:
+		return 3
+	default:
+		// dpanic, panic and fatal
+		return 2
+	}
+}
+
+// syslogField returns v as a header field of at most max printable ASCII
+// characters, "-" (nil value) when empty
+func syslogField(v string, max int) string {
+	b := make([]byte, 0, len(v))
+	for i := 0; i < len(v) && len(b) < max; i++ {
+		if v[i] > 32 && v[i] < 127 {
+			b = append(b, v[i])
+		}
+	}
+
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
@@ -0,0 +1,226 @@
+package log
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestSyslogField(t *testing.T) {
+	tests := []struct {
+		name string
+		v    string
+		max  int
+		want string
+	}{
+		{"empty", "", 48, "-"},
+		{"printable", "quicsec-proxy", 48, "quicsec-proxy"},
+		{"spaces removed", "my app", 48, "myapp"},
+		{"control characters removed", "a\tb\nc\x00d", 48, "abcd"},
+		{"non ASCII removed", "café", 48, "caf"},
+		{"only spaces", "   ", 48, "-"},
+		{"truncated", "conn-manager", 4, "conn"},
+		{"truncated after the removal", "a b c d e", 3, "abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := syslogField(tt.v, tt.max); got != tt.want {
+				t.Errorf("syslogField(%q, %d) = %q, want %q", tt.v, tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	tests := []struct {
+		level zapcore.Level
+		want  int
+	}{
+		{zapcore.DebugLevel, 7},
+		{zapcore.InfoLevel, 6},
+		{zapcore.WarnLevel, 4},
+		{zapcore.ErrorLevel, 3},
+		{zapcore.DPanicLevel, 2},
+		{zapcore.PanicLevel, 2},
+		{zapcore.FatalLevel, 2},
+	}
+
+	for _, tt := range tests {
+		if got := syslogSeverity(tt.level); got != tt.want {
+			t.Errorf("syslogSeverity(%s) = %d, want %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+// rfc5424 matches the messages of the syslog sender: PRI, VERSION,
+// TIMESTAMP, HOSTNAME, APP-NAME, PROCID, MSGID, no STRUCTURED-DATA and MSG
+var rfc5424 = regexp.MustCompile(`^<(\d+)>1 (\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z) (\S+) (\S+) (\d+) (\S+) - (.*)$`)
+
+func syslogBatch() []shipEntry {
+	ts := time.Date(2026, 10, 19, 6, 30, 0, 123456789, time.FixedZone("CEST", 2*3600))
+	return []shipEntry{
+		{time: ts, level: zapcore.InfoLevel, component: "http", line: `{"msg":"handled request"}`},
+		{time: ts, level: zapcore.ErrorLevel, component: "", line: `{"msg":"failed"}`},
+	}
+}
+
+// checkSyslogMessage checks the RFC 5424 message of the entry i of
+// syslogBatch sent by the local0 "quicsec app" sender
+func checkSyslogMessage(t *testing.T, i int, msg string) {
+	t.Helper()
+
+	m := rfc5424.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("message %d isn't RFC 5424: %q", i, msg)
+	}
+
+	wantPri := []string{"134", "131"}[i] // local0 (16*8) + info (6) or error (3)
+	wantMsgID := []string{"http", "-"}[i]
+	wantLine := syslogBatch()[i].line
+	if m[1] != wantPri {
+		t.Errorf("message %d PRI %s, want %s", i, m[1], wantPri)
+	}
+	if m[2] != "2026-10-19T04:30:00.123456Z" {
+		t.Errorf("message %d TIMESTAMP %s, want UTC with microseconds", i, m[2])
+	}
+	if m[4] != "quicsecapp" {
+		t.Errorf("message %d APP-NAME %s", i, m[4])
+	}
+	if m[6] != wantMsgID {
+		t.Errorf("message %d MSGID %s, want %s", i, m[6], wantMsgID)
+	}
+	if m[7] != wantLine {
+		t.Errorf("message %d MSG %s, want %s", i, m[7], wantLine)
+	}
+}
+
+func TestSyslogSenderUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sender, err := newSyslogSender(SyslogOptions{Network: "udp", Address: conn.LocalAddr().String(), Facility: 16, AppName: "quicsec app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sender.send(syslogBatch()); n != 2 || err != nil {
+		t.Fatalf("send() = %d, %v", n, err)
+	}
+
+	// one message per datagram
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	for i := 0; i < 2; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSyslogMessage(t, i, string(buf[:n]))
+	}
+}
+
+func TestSyslogSenderTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 4)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		data, _ := io.ReadAll(c)
+		received <- string(data)
+	}()
+
+	sender, err := newSyslogSender(SyslogOptions{Network: "tcp", Address: ln.Addr().String(), Facility: 16, AppName: "quicsec app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sender.send(syslogBatch()); n != 2 || err != nil {
+		t.Fatalf("send() = %d, %v", n, err)
+	}
+	sender.conn.Close()
+
+	var data string
+	select {
+	case data = <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing received")
+	}
+
+	// octet counting (RFC 6587): MSG-LEN SP SYSLOG-MSG
+	r := bufio.NewReader(strings.NewReader(data))
+	for i := 0; i < 2; i++ {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("frame %d: %v in %q", i, err, data)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Fatalf("frame %d: invalid length %q", i, length)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		checkSyslogMessage(t, i, string(msg))
+	}
+	if rest, _ := io.ReadAll(r); len(rest) != 0 {
+		t.Errorf("trailing data %q", rest)
+	}
+}
+
+func TestSyslogSenderRedial(t *testing.T) {
+	ln, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.LocalAddr().String()
+	ln.Close()
+
+	// the receiver is down: the dial of a tcp sender fails
+	sender, err := newSyslogSender(SyslogOptions{Network: "tcp", Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sender.send(syslogBatch()); n != 0 || err == nil {
+		t.Fatalf("send() = %d, %v with the receiver down", n, err)
+	}
+
+	// the next batch is sent once the receiver is up
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("port %s reused: %v", addr, err)
+	}
+	defer tcp.Close()
+	go func() {
+		if c, err := tcp.Accept(); err == nil {
+			io.Copy(io.Discard, c)
+		}
+	}()
+	if n, err := sender.send(syslogBatch()); n != 2 || err != nil {
+		t.Errorf("send() = %d, %v with the receiver up", n, err)
+	}
+}
+
+func TestNewSyslogSender(t *testing.T) {
+	if _, err := newSyslogSender(SyslogOptions{Network: "sctp"}); err == nil {
+		t.Error("unknown network accepted")
+	}
+}
@@ -0,0 +1,40 @@
+package operations
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+// logShipperCollector exports the counters of the log shippers (Loki and
+// syslog), kept by the log package
+type logShipperCollector struct {
+	sent    *prometheus.Desc
+	dropped *prometheus.Desc
+}
+
+var _ prometheus.Collector = &logShipperCollector{}
+
+func newLogShipperCollector() *logShipperCollector {
+	return &logShipperCollector{
+		sent: prometheus.NewDesc("appedge_log_entries_sent_total",
+			"Log entries sent by the log shippers by sink (loki and syslog)",
+			[]string{"sink"}, nil),
+		dropped: prometheus.NewDesc("appedge_log_entries_dropped_total",
+			"Log entries dropped by the log shippers by sink and reason (queue_full and send_failed)",
+			[]string{"sink", "reason"}, nil),
+	}
+}
+
+func (c *logShipperCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- c.sent
+	descs <- c.dropped
+}
+
+func (c *logShipperCollector) Collect(metrics chan<- prometheus.Metric) {
+	for _, s := range log.ShippersStats() {
+		metrics <- prometheus.MustNewConstMetric(c.sent, prometheus.CounterValue, float64(s.Sent), s.Sink)
+		metrics <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(s.QueueFull), s.Sink, "queue_full")
+		metrics <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(s.SendFailed), s.Sink, "send_failed")
+	}
+}
@@ -6,8 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"net/http"
-	"strconv"
 	"sync"
 	"time"
 
@@ -15,11 +13,17 @@ import (
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/logging"
 	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/audit"
 	"github.com/quicsec/quicsec/operations/log"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
+// Registry is the Prometheus registry of the QuicSec metrics, the
+// application can register its collectors on it or gather it with its own
+// registry (prometheus.Gatherers)
+var Registry = prometheus.NewRegistry()
+
 var (
 	bytesTransferred         *prometheus.CounterVec
 	packetsTransferred       *prometheus.CounterVec
@@ -33,22 +37,90 @@ var (
 	connErrors               *prometheus.CounterVec
 	HttpRequestsPathIdClient *prometheus.CounterVec
 	HttpRequestsPathIdServer *prometheus.CounterVec
+	DroppedPathLabels        *prometheus.CounterVec
 	AuthzConnectiontClientId *prometheus.CounterVec
 	AuthzConnectiontServerId *prometheus.CounterVec
+	DnsCacheRequests         *prometheus.CounterVec
+	resumedSessions          *prometheus.CounterVec
+	earlyDataConns           *prometheus.CounterVec
+	PoolConnections          *prometheus.GaugeVec
+	PoolEndpoints            prometheus.Gauge
+	TunnelConns              *prometheus.CounterVec
+	TunnelBytes              *prometheus.CounterVec
+	ConnectTunnels           *prometheus.CounterVec
+	ConnectBytes             *prometheus.CounterVec
+	WebTransportSessions     *prometheus.CounterVec
+	WebTransportStreams      *prometheus.CounterVec
+	Datagrams                *prometheus.CounterVec
+	DroppedDatagrams         *prometheus.CounterVec
+	keyLogWriteErrors        *prometheus.CounterVec
+	congestionStates         *prometheus.CounterVec
+
+	// per-connection histograms, observed when the connection is closed
+	handshakeDurations *prometheus.HistogramVec
+	smoothedRTTs       *prometheus.HistogramVec
+	minRTTs            *prometheus.HistogramVec
+	congestionWindows  *prometheus.HistogramVec
+	inFlightBytes      *prometheus.HistogramVec
+	lossRates          *prometheus.HistogramVec
+	ptoCounts          *prometheus.HistogramVec
+	keyUpdates         *prometheus.HistogramVec
 
 	HTTPHistogramAppProcessId = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "appedge_inbound_rq_latency",
 			Help:    "The application latency to process a HTTP request by tuple of identity",
 			Buckets: prometheus.ExponentialBuckets(0.001, 1.25, 20), // 1ms start
-		}, []string{"myId", "downstreamId"})
+		}, []string{"myId", "downstreamId", "transport"})
 
 	HTTPHistogramNetworkLatencyId = prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "appedge_outbound_rq_latency",
 			Help:    "The network latency between the request and the response by tuple of identity",
 			Buckets: prometheus.ExponentialBuckets(0.001, 1.25, 20), // 1ms start
-		}, []string{"myId", "upstreamId"})
+		}, []string{"myId", "upstreamId", "transport"})
+
+	HTTPHistogramTTFB = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "appedge_rq_ttfb",
+			Help:    "The time to first byte of the response (headers) by direction, transport and response outcome",
+			Buckets: prometheus.ExponentialBuckets(0.001, 1.25, 20), // 1ms start
+		}, []string{"direction", "transport", "outcome"})
+
+	HTTPHistogramStreamDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "appedge_rq_stream_duration",
+			Help:    "The time until the response body is complete by direction, transport and response outcome",
+			Buckets: prometheus.ExponentialBuckets(0.001, 1.5, 24), // 1ms start
+		}, []string{"direction", "transport", "outcome"})
+
+	HTTPHistogramBodySize = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "appedge_rq_body_size",
+			Help:    "The bytes transferred in the request and response bodies by direction, transport, body and outcome",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 12), // 64B start
+		}, []string{"direction", "transport", "body", "outcome"})
+
+	TunnelHistogramDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_tunnel_connection_duration",
+			Help:    "The duration of the TCP connections tunneled over QUIC streams",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 20), // 10ms start
+		}, []string{"direction"})
+
+	WebTransportHistogramSessionDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_webtransport_session_duration",
+			Help:    "The lifetime of the WebTransport sessions by path",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 20), // 10ms start
+		}, []string{"path"})
+
+	DnsHistogramLookupLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "appedge_dns_lookup_latency",
+			Help:    "The latency of the DNS lookups by record type and status",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 1.5, 20), // 0.5ms start
+		}, []string{"type", "status"})
 )
 
 type aggregatingCollector struct {
@@ -113,31 +185,112 @@ type metricsConnTracer struct {
 	perspective       logging.Perspective
 	startTime         time.Time
 	connID            logging.ConnectionID
+	tracingID         uint64
 	handshakeComplete bool
+	handshakeDuration time.Duration
 
 	mutex              sync.Mutex
+	peerID             string
 	numRTTMeasurements int
-	rtt                time.Duration
+	smoothedRTT        time.Duration
+	minRTT             time.Duration
+	cwnd               logging.ByteCount
+	bytesInFlight      logging.ByteCount
+	sentPackets        uint64
+	lostPackets        uint64
+	ptoCount           uint64
+	keyUpdates         uint64
+
+	earlyDataAttempted bool
+	earlyDataAccepted  bool
+	earlyDataRejected  bool
 }
 
 var _ logging.ConnectionTracer = &metricsConnTracer{}
 
-func runPrometheusHTTP(address string) {
-	prometheusBind := address
-	metrics_path := "/metrics"
-	http_url := "http://" + prometheusBind + metrics_path
+// connTracers are the tracers of the open connections by tracing ID
+// (quic.ConnectionTracingKey), to label their metrics with the peer identity
+var connTracers sync.Map
+
+// SetConnPeerID labels the metrics and the qlog captures of the connection
+// of ctx (the context of a quic.Connection) with the SPIFFE ID of its peer,
+// known once the handshake completes
+func SetConnPeerID(ctx context.Context, peerID string) {
+	tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return
+	}
+
+	if t, ok := connTracers.Load(tracingID); ok {
+		m := t.(*metricsConnTracer)
+		m.mutex.Lock()
+		m.peerID = peerID
+		m.mutex.Unlock()
+	}
+
+	if s, ok := qlogConns.Load(tracingID); ok {
+		state := s.(*qlogConnState)
+		state.mutex.Lock()
+		state.peerID = peerID
+		state.mutex.Unlock()
+	}
+}
+
+// SetConnHandshakeState counts the session resumption of the connection of
+// ctx and, on the client side, the outcome of its 0-RTT attempt: both are
+// only known once the handshake completes
+func SetConnHandshakeState(ctx context.Context, state quic.ConnectionState) {
+	tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return
+	}
+
+	if t, ok := connTracers.Load(tracingID); ok {
+		t.(*metricsConnTracer).handshakeCompleted(state)
+	}
+}
 
-	log.LoggerLgr.WithName(log.ConstOperationsManager).V(log.DebugLevel).Info("Prometheus metrics avaiable", "url", http_url)
+// handshakeCompleted counts the resumption and, for a client connection
+// which sent 0-RTT data, whether the server accepted it
+func (m *metricsConnTracer) handshakeCompleted(state quic.ConnectionState) {
+	if state.TLS.DidResume {
+		resumedSessions.WithLabelValues(m.getDirection()).Inc()
+	}
 
-	http.Handle(metrics_path, promhttp.Handler())
-	err := http.ListenAndServe(prometheusBind, nil)
-	if err != nil {
-		log.LoggerLgr.WithName(log.ConstOperationsManager).Error(err, "ListenAndServe failed for prometheus")
+	if m.perspective != logging.PerspectiveClient {
+		return
+	}
+	m.mutex.Lock()
+	attempted := m.earlyDataAttempted
+	m.mutex.Unlock()
+	if !attempted {
+		return
+	}
+
+	if state.TLS.Used0RTT {
+		m.countEarlyData(&m.earlyDataAccepted, "accepted")
+	} else {
+		m.countEarlyData(&m.earlyDataRejected, "rejected")
 	}
 }
 
+// countEarlyData counts the 0-RTT status of the connection once
+func (m *metricsConnTracer) countEarlyData(counted *bool, status string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if *counted {
+		return
+	}
+	*counted = true
+	earlyDataConns.WithLabelValues(m.getDirection(), status).Inc()
+}
+
 // metricsInit start tracing the metrics using prometheus
 func metricsInit() {
+	Registry.MustRegister(collectors.NewGoCollector())
+	Registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
 	const (
 		direction = "direction"
 		encLevel  = "encryption_level"
@@ -150,7 +303,7 @@ func metricsInit() {
 		},
 		[]string{direction},
 	)
-	prometheus.MustRegister(closedConns)
+	Registry.MustRegister(closedConns)
 	newConns = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_connections_new_total",
@@ -158,7 +311,7 @@ func metricsInit() {
 		},
 		[]string{direction, "handshake_successful"},
 	)
-	prometheus.MustRegister(newConns)
+	Registry.MustRegister(newConns)
 	bytesTransferred = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_transferred_bytes",
@@ -166,7 +319,7 @@ func metricsInit() {
 		},
 		[]string{direction},
 	)
-	prometheus.MustRegister(bytesTransferred)
+	Registry.MustRegister(bytesTransferred)
 	packetsTransferred = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_transferred_packets_total",
@@ -174,7 +327,7 @@ func metricsInit() {
 		},
 		[]string{direction},
 	)
-	prometheus.MustRegister(packetsTransferred)
+	Registry.MustRegister(packetsTransferred)
 	sentPackets = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_packets_sent_total",
@@ -182,7 +335,7 @@ func metricsInit() {
 		},
 		[]string{encLevel},
 	)
-	prometheus.MustRegister(sentPackets)
+	Registry.MustRegister(sentPackets)
 	rcvdPackets = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_packets_rcvd_total",
@@ -190,7 +343,7 @@ func metricsInit() {
 		},
 		[]string{encLevel},
 	)
-	prometheus.MustRegister(rcvdPackets)
+	Registry.MustRegister(rcvdPackets)
 	bufferedPackets = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_packets_buffered_total",
@@ -198,7 +351,7 @@ func metricsInit() {
 		},
 		[]string{"packet_type"},
 	)
-	prometheus.MustRegister(bufferedPackets)
+	Registry.MustRegister(bufferedPackets)
 	droppedPackets = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_packets_dropped_total",
@@ -206,7 +359,7 @@ func metricsInit() {
 		},
 		[]string{"packet_type", "reason"},
 	)
-	prometheus.MustRegister(droppedPackets)
+	Registry.MustRegister(droppedPackets)
 	connErrors = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_connection_errors_total",
@@ -214,7 +367,7 @@ func metricsInit() {
 		},
 		[]string{"side", "error_code", "reason"},
 	)
-	prometheus.MustRegister(connErrors)
+	Registry.MustRegister(connErrors)
 	lostPackets = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "quic_packets_lost_total",
@@ -222,25 +375,51 @@ func metricsInit() {
 		},
 		[]string{encLevel, "reason"},
 	)
-	prometheus.MustRegister(lostPackets)
+	Registry.MustRegister(lostPackets)
+
+	resumedSessions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_tls_sessions_resumed_total",
+			Help: "QUIC connections established resuming a TLS session",
+		},
+		[]string{direction},
+	)
+	Registry.MustRegister(resumedSessions)
+	earlyDataConns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_early_data_connections_total",
+			Help: "QUIC connections using 0-RTT by status (attempted, accepted and rejected)",
+		},
+		[]string{direction, "status"},
+	)
+	Registry.MustRegister(earlyDataConns)
 
 	HttpRequestsPathIdClient = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "appedge_outbound_rq_total",
 			Help: "HTTP requests counter by group (method, path and status)",
 		},
-		[]string{"myId", "upstreamId", "instance", "method", "path", "status"},
+		[]string{"myId", "upstreamId", "transport", "instance", "method", "path", "status"},
 	)
-	prometheus.MustRegister(HttpRequestsPathIdClient)
+	Registry.MustRegister(HttpRequestsPathIdClient)
 
 	HttpRequestsPathIdServer = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "appedge_inbound_rq_total",
 			Help: "HTTP requests counter by group (method, path and status)",
 		},
-		[]string{"myId", "downstreamId", "instance", "method", "path", "status"},
+		[]string{"myId", "downstreamId", "transport", "instance", "method", "path", "status"},
 	)
-	prometheus.MustRegister(HttpRequestsPathIdServer)
+	Registry.MustRegister(HttpRequestsPathIdServer)
+
+	DroppedPathLabels = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "appedge_rq_path_labels_dropped_total",
+			Help: "HTTP requests counted with the overflow path label by direction and reason (unmatched and max_path_labels)",
+		},
+		[]string{direction, "reason"},
+	)
+	Registry.MustRegister(DroppedPathLabels)
 
 	AuthzConnectiontServerId = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -249,7 +428,7 @@ func metricsInit() {
 		},
 		[]string{"myId", "downstreamId", "status"},
 	)
-	prometheus.MustRegister(AuthzConnectiontServerId)
+	Registry.MustRegister(AuthzConnectiontServerId)
 
 	AuthzConnectiontClientId = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -258,24 +437,222 @@ func metricsInit() {
 		},
 		[]string{"myId", "upstreamId", "status"},
 	)
-	prometheus.MustRegister(AuthzConnectiontClientId)
+	Registry.MustRegister(AuthzConnectiontClientId)
+
+	DnsCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "appedge_dns_cache_total",
+			Help: "DNS cache lookups by result (hit, negative_hit, stale and miss)",
+		},
+		[]string{"result"},
+	)
+	Registry.MustRegister(DnsCacheRequests)
+
+	PoolConnections = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "quic_pool_connections",
+			Help: "Client QUIC connections in the pool by state (active, idle and handshaking)",
+		},
+		[]string{"state"},
+	)
+	Registry.MustRegister(PoolConnections)
+	PoolEndpoints = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "quic_pool_endpoints",
+			Help: "Endpoints with at least one client QUIC connection in the pool",
+		},
+	)
+	Registry.MustRegister(PoolEndpoints)
+
+	TunnelConns = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_tunnel_connections_total",
+			Help: "TCP connections tunneled over QUIC streams by status (success and failure)",
+		},
+		[]string{direction, "status"},
+	)
+	Registry.MustRegister(TunnelConns)
+	TunnelBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_tunnel_transferred_bytes",
+			Help: "Bytes transferred by the TCP connections tunneled over QUIC streams",
+		},
+		[]string{direction, "flow"},
+	)
+	Registry.MustRegister(TunnelBytes)
+
+	ConnectTunnels = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "appedge_connect_tunnels_total",
+			Help: "CONNECT and CONNECT-UDP tunnels by downstream identity, protocol and status (success, forbidden and failure)",
+		},
+		[]string{"downstreamId", "protocol", "status"},
+	)
+	Registry.MustRegister(ConnectTunnels)
+	ConnectBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "appedge_connect_transferred_bytes",
+			Help: "Bytes transferred by the CONNECT and CONNECT-UDP tunnels by downstream identity, protocol and flow (sent and rcvd from the target)",
+		},
+		[]string{"downstreamId", "protocol", "flow"},
+	)
+	Registry.MustRegister(ConnectBytes)
+
+	WebTransportSessions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_webtransport_sessions_total",
+			Help: "WebTransport sessions by path and status (accepted, unauthorized and failure)",
+		},
+		[]string{"path", "status"},
+	)
+	Registry.MustRegister(WebTransportSessions)
+	WebTransportStreams = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_webtransport_streams_total",
+			Help: "WebTransport streams by path, type (bidi and uni) and direction",
+		},
+		[]string{"path", "type", direction},
+	)
+	Registry.MustRegister(WebTransportStreams)
+
+	Datagrams = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_datagrams_total",
+			Help: "Datagrams sent and received by the datagram API by peer identity and direction",
+		},
+		[]string{"peerId", direction},
+	)
+	Registry.MustRegister(Datagrams)
+	DroppedDatagrams = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_datagrams_dropped_total",
+			Help: "Datagrams dropped by peer identity, direction and reason (rate_limited, queue_full and send_error)",
+		},
+		[]string{"peerId", direction, "reason"},
+	)
+	Registry.MustRegister(DroppedDatagrams)
+	keyLogWriteErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "tls_key_log_write_errors_total",
+			Help: "Failed writes of the TLS secrets by file (key_log and window)",
+		},
+		[]string{"file"},
+	)
+	Registry.MustRegister(keyLogWriteErrors)
+	Registry.MustRegister(prometheus.NewCounterFunc(
+		prometheus.CounterOpts{
+			Name: "audit_log_write_errors_total",
+			Help: "Security events which couldn't be written to the audit log",
+		},
+		func() float64 { return float64(audit.WriteErrors()) },
+	))
+
+	congestionStates = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "quic_congestion_state_changes_total",
+			Help: "Congestion controller state changes by direction and state (slow_start, congestion_avoidance, recovery and application_limited)",
+		},
+		[]string{direction, "state"},
+	)
+	Registry.MustRegister(congestionStates)
+
+	connLabels := []string{direction, "peerId"}
+	handshakeDurations = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_handshake_duration",
+			Help:    "The duration of the QUIC handshakes by direction and peer identity",
+			Buckets: prometheus.ExponentialBuckets(0.001, 1.5, 20), // 1ms start
+		}, connLabels)
+	Registry.MustRegister(handshakeDurations)
+	smoothedRTTs = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_smoothed_rtt",
+			Help:    "The smoothed RTT of the QUIC connections at close by direction and peer identity",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 1.5, 24), // 0.1ms start
+		}, connLabels)
+	Registry.MustRegister(smoothedRTTs)
+	minRTTs = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_min_rtt",
+			Help:    "The minimum RTT of the QUIC connections by direction and peer identity",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 1.5, 24), // 0.1ms start
+		}, connLabels)
+	Registry.MustRegister(minRTTs)
+	congestionWindows = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_congestion_window_bytes",
+			Help:    "The congestion window of the QUIC connections at close by direction and peer identity",
+			Buckets: prometheus.ExponentialBuckets(1200, 2, 16), // 1 packet start
+		}, connLabels)
+	Registry.MustRegister(congestionWindows)
+	inFlightBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_bytes_in_flight",
+			Help:    "The bytes in flight of the QUIC connections at close by direction and peer identity",
+			Buckets: prometheus.ExponentialBuckets(1200, 2, 16), // 1 packet start
+		}, connLabels)
+	Registry.MustRegister(inFlightBytes)
+	lossRates = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_loss_rate",
+			Help:    "The ratio of the sent packets declared lost per QUIC connection by direction and peer identity",
+			Buckets: []float64{0.001, 0.005, 0.01, 0.02, 0.05, 0.1, 0.2, 0.5},
+		}, connLabels)
+	Registry.MustRegister(lossRates)
+	ptoCounts = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_pto_count",
+			Help:    "The probe timeouts (PTO) expired per QUIC connection by direction and peer identity",
+			Buckets: []float64{0, 1, 2, 4, 8, 16, 32, 64},
+		}, connLabels)
+	Registry.MustRegister(ptoCounts)
+	keyUpdates = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "quic_connection_key_updates",
+			Help:    "The 1-RTT key updates per QUIC connection by direction and peer identity",
+			Buckets: []float64{0, 1, 2, 4, 8, 16, 32, 64},
+		}, connLabels)
+	Registry.MustRegister(keyUpdates)
 
 	collector = newAggregatingCollector()
-	prometheus.MustRegister(collector)
+	Registry.MustRegister(collector)
+
+	Registry.MustRegister(newLogShipperCollector())
+
+	Registry.MustRegister(HTTPHistogramAppProcessId)
+
+	Registry.MustRegister(HTTPHistogramNetworkLatencyId)
+
+	Registry.MustRegister(HTTPHistogramTTFB)
 
-	prometheus.MustRegister(HTTPHistogramAppProcessId)
+	Registry.MustRegister(HTTPHistogramStreamDuration)
 
-	prometheus.MustRegister(HTTPHistogramNetworkLatencyId)
+	Registry.MustRegister(HTTPHistogramBodySize)
+
+	Registry.MustRegister(DnsHistogramLookupLatency)
+
+	Registry.MustRegister(TunnelHistogramDuration)
+
+	Registry.MustRegister(WebTransportHistogramSessionDuration)
 
 	pFlag, pAddr := config.GetPrometheusHTTPConfig()
 	if pFlag {
-		go runPrometheusHTTP("0.0.0.0:" + strconv.Itoa(pAddr))
+		go runPrometheusHTTP(pAddr)
 	} else {
 		log.LoggerLgr.WithName(log.ConstOperationsManager).V(log.DebugLevel).Info("configure QUICSEC_METRICS_BIND_PORT to access Prometheus metrics")
 	}
+
+	if config.GetMetricsOtlpConfig().Enable {
+		otlpMetricsInit()
+	}
 }
-func (m *MetricsTracer) TracerForConnection(_ context.Context, p logging.Perspective, connID logging.ConnectionID) logging.ConnectionTracer {
-	return &metricsConnTracer{perspective: p, connID: connID}
+func (m *MetricsTracer) TracerForConnection(ctx context.Context, p logging.Perspective, connID logging.ConnectionID) logging.ConnectionTracer {
+	t := &metricsConnTracer{perspective: p, connID: connID}
+	if tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64); ok {
+		t.tracingID = tracingID
+		connTracers.Store(tracingID, t)
+	}
+	return t
 }
 
 // need to be implemented - quic-go/qlog has these interfaces implemented
@@ -287,15 +664,18 @@ func (m *metricsConnTracer) SentTransportParameters(tp *logging.TransportParamet
 
 func (m *metricsConnTracer) ReceivedTransportParameters(tp *logging.TransportParameters) {}
 
-func (m *metricsConnTracer) RestoredTransportParameters(tp *logging.TransportParameters) {}
-
-func (m *metricsConnTracer) UpdatedCongestionState(state logging.CongestionState) {}
+// RestoredTransportParameters is called when the client restores the
+// transport parameters from a session ticket to send 0-RTT data
+func (m *metricsConnTracer) RestoredTransportParameters(tp *logging.TransportParameters) {
+	m.mutex.Lock()
+	m.earlyDataAttempted = true
+	m.mutex.Unlock()
 
-func (m *metricsConnTracer) UpdatedPTOCount(value uint32) {}
+	earlyDataConns.WithLabelValues(m.getDirection(), "attempted").Inc()
+}
 
 func (m *metricsConnTracer) UpdatedKeyFromTLS(encLevel logging.EncryptionLevel, pers logging.Perspective) {
 }
-func (m *metricsConnTracer) UpdatedKey(generation logging.KeyPhase, remote bool) {}
 
 func (m *metricsConnTracer) DroppedKey(generation logging.KeyPhase) {}
 
@@ -341,14 +721,62 @@ func (m *metricsConnTracer) StartedConnection(local, remote net.Addr, srcConnID,
 
 func (m *metricsConnTracer) UpdatedMetrics(rttStats *logging.RTTStats, cwnd, bytesInFlight logging.ByteCount, packetsInFlight int) {
 	m.mutex.Lock()
-	m.rtt = rttStats.SmoothedRTT()
+	m.smoothedRTT = rttStats.SmoothedRTT()
+	m.minRTT = rttStats.MinRTT()
+	m.cwnd = cwnd
+	m.bytesInFlight = bytesInFlight
 	m.numRTTMeasurements++
 	m.mutex.Unlock()
 }
 
-func (m *metricsConnTracer) SentPacket(hdr *logging.ExtendedHeader, packetSize logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+func (m *metricsConnTracer) UpdatedCongestionState(state logging.CongestionState) {
+	var name string
+	switch state {
+	case logging.CongestionStateSlowStart:
+		name = "slow_start"
+	case logging.CongestionStateCongestionAvoidance:
+		name = "congestion_avoidance"
+	case logging.CongestionStateRecovery:
+		name = "recovery"
+	case logging.CongestionStateApplicationLimited:
+		name = "application_limited"
+	default:
+		name = "unknown"
+	}
+	congestionStates.WithLabelValues(m.getDirection(), name).Inc()
+}
+
+// UpdatedPTOCount is called with the consecutive PTO count, reset to 0 when
+// an ack is received
+func (m *metricsConnTracer) UpdatedPTOCount(value uint32) {
+	if value > 0 {
+		m.mutex.Lock()
+		m.ptoCount++
+		m.mutex.Unlock()
+	}
+}
+
+func (m *metricsConnTracer) UpdatedKey(generation logging.KeyPhase, remote bool) {
+	m.mutex.Lock()
+	m.keyUpdates++
+	m.mutex.Unlock()
+}
+
+func (m *metricsConnTracer) SentLongHeaderPacket(hdr *logging.ExtendedHeader, packetSize logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	m.sentPacket(logging.PacketTypeFromHeader(&hdr.Header), packetSize)
+}
+
+func (m *metricsConnTracer) SentShortHeaderPacket(hdr *logging.ShortHeader, packetSize logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	m.sentPacket(logging.PacketType1RTT, packetSize)
+}
+
+func (m *metricsConnTracer) sentPacket(packetType logging.PacketType, packetSize logging.ByteCount) {
+	m.mutex.Lock()
+	m.sentPackets++
+	m.mutex.Unlock()
+
 	bytesTransferred.WithLabelValues("sent").Add(float64(packetSize))
-	sentPackets.WithLabelValues(m.getEncLevel(logging.PacketTypeFromHeader(&hdr.Header))).Inc()
+	sentPackets.WithLabelValues(m.getEncLevel(packetType)).Inc()
 	packetsTransferred.WithLabelValues("sent").Inc()
 }
 
@@ -363,9 +791,16 @@ func (m *metricsConnTracer) ReceivedRetry(hdr *logging.Header) {
 }
 
 func (m *metricsConnTracer) ReceivedLongHeaderPacket(hdr *logging.ExtendedHeader, packetSize logging.ByteCount, _ []logging.Frame) {
+	packetType := logging.PacketTypeFromHeader(&hdr.Header)
+
 	bytesTransferred.WithLabelValues("rcvd").Add(float64(packetSize))
-	rcvdPackets.WithLabelValues(m.getEncLevel(logging.PacketTypeFromHeader(&hdr.Header))).Inc()
+	rcvdPackets.WithLabelValues(m.getEncLevel(packetType)).Inc()
 	packetsTransferred.WithLabelValues("rcvd").Inc()
+
+	// the client outcome is known from the handshake (handshakeCompleted)
+	if packetType == logging.PacketType0RTT && m.perspective == logging.PerspectiveServer {
+		m.countEarlyData(&m.earlyDataAccepted, "accepted")
+	}
 }
 
 func (m *metricsConnTracer) ReceivedShortHeaderPacket(hdr *logging.ShortHeader, packetSize logging.ByteCount, _ []logging.Frame) {
@@ -409,6 +844,10 @@ func (m *metricsConnTracer) DroppedPacket(pt logging.PacketType, size logging.By
 		reason = "unknown packet drop reason"
 	}
 	droppedPackets.WithLabelValues(m.getEncLevel(pt), reason).Inc()
+
+	if pt == logging.PacketType0RTT && m.perspective == logging.PerspectiveServer {
+		m.countEarlyData(&m.earlyDataRejected, "rejected")
+	}
 }
 
 func (m *metricsConnTracer) LostPacket(encLevel logging.EncryptionLevel, _ logging.PacketNumber, r logging.PacketLossReason) {
@@ -422,6 +861,10 @@ func (m *metricsConnTracer) LostPacket(encLevel logging.EncryptionLevel, _ loggi
 		reason = "unknown loss reason"
 	}
 	lostPackets.WithLabelValues(encLevel.String(), reason).Inc()
+
+	m.mutex.Lock()
+	m.lostPackets++
+	m.mutex.Unlock()
 }
 
 func (m *metricsConnTracer) DroppedEncryptionLevel(level logging.EncryptionLevel) {
@@ -432,17 +875,41 @@ func (m *metricsConnTracer) DroppedEncryptionLevel(level logging.EncryptionLevel
 
 func (m *metricsConnTracer) handleHandshakeComplete() {
 	m.handshakeComplete = true
+	m.handshakeDuration = time.Since(m.startTime)
 	newConns.WithLabelValues(m.getDirection(), "true").Inc()
 }
 
 func (m *metricsConnTracer) Close() {
 	if m.handshakeComplete {
 		closedConns.WithLabelValues(m.getDirection()).Inc()
+		m.observeConnMetrics()
 	} else {
 		newConns.WithLabelValues(m.getDirection(), "false").Inc()
 	}
 
 	collector.RemoveConn(m.connID.String())
+	connTracers.Delete(m.tracingID)
+}
+
+// observeConnMetrics observes the per-connection histograms, labeled with
+// the peer identity when the connection layer set it
+func (m *metricsConnTracer) observeConnMetrics() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	direction := m.getDirection()
+	handshakeDurations.WithLabelValues(direction, m.peerID).Observe(m.handshakeDuration.Seconds())
+	if m.numRTTMeasurements > 0 {
+		smoothedRTTs.WithLabelValues(direction, m.peerID).Observe(m.smoothedRTT.Seconds())
+		minRTTs.WithLabelValues(direction, m.peerID).Observe(m.minRTT.Seconds())
+		congestionWindows.WithLabelValues(direction, m.peerID).Observe(float64(m.cwnd))
+		inFlightBytes.WithLabelValues(direction, m.peerID).Observe(float64(m.bytesInFlight))
+	}
+	if m.sentPackets > 0 {
+		lossRates.WithLabelValues(direction, m.peerID).Observe(float64(m.lostPackets) / float64(m.sentPackets))
+	}
+	ptoCounts.WithLabelValues(direction, m.peerID).Observe(float64(m.ptoCount))
+	keyUpdates.WithLabelValues(direction, m.peerID).Observe(float64(m.keyUpdates))
 }
 
 func (m *metricsConnTracer) ClosedConnection(e error) {
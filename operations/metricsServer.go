@@ -0,0 +1,166 @@
+package operations
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/identity"
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+var readinessChecks = struct {
+	sync.RWMutex
+	m map[string]func() error
+}{m: make(map[string]func() error)}
+
+// SetReadinessCheck registers the check named name run by /readyz, nil
+// removes it. The workload is ready when all the checks succeed.
+func SetReadinessCheck(name string, check func() error) {
+	readinessChecks.Lock()
+	defer readinessChecks.Unlock()
+
+	if check == nil {
+		delete(readinessChecks.m, name)
+		return
+	}
+	readinessChecks.m[name] = check
+}
+
+// notReady returns the failures of the readiness checks, the identity
+// certificate has to be loaded
+func notReady() []string {
+	var failures []string
+	if _, err := identity.GetCert(); err != nil {
+		failures = append(failures, "identity: "+err.Error())
+	}
+
+	readinessChecks.RLock()
+	defer readinessChecks.RUnlock()
+
+	for name, check := range readinessChecks.m {
+		if err := check(); err != nil {
+			failures = append(failures, name+": "+err.Error())
+		}
+	}
+	sort.Strings(failures)
+
+	return failures
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if failures := notReady(); len(failures) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, strings.Join(failures, "\n"))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// runPrometheusHTTP serves the metrics of the registry and the health
+// endpoints (/healthz and /readyz), over TLS with the workload identity when
+// metrics.tls.enable is set
+func runPrometheusHTTP(address string) {
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+	metricsPath := config.GetMetricsPath()
+	tlsConf := config.GetMetricsTlsConfig()
+
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+
+	server := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+
+	scheme := "http"
+	if tlsConf.Enable {
+		scheme = "https"
+		server.TLSConfig = newMetricsTLSConfig(tlsConf.RequireMtls)
+	}
+	opsLogger.V(log.DebugLevel).Info("Prometheus metrics avaiable", "url", scheme+"://"+address+metricsPath, "mtls", tlsConf.RequireMtls)
+
+	var err error
+	if tlsConf.Enable {
+		// the certificate is loaded by the TLS config
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
+		opsLogger.Error(err, "ListenAndServe failed for prometheus")
+	}
+}
+
+// newMetricsTLSConfig returns the TLS config of the metrics endpoint: the
+// identity certificate, loaded on every handshake, and a client certificate
+// verified against the CA bundle when requireMtls is set
+func newMetricsTLSConfig(requireMtls bool) *tls.Config {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return identity.GetCert()
+		},
+	}
+
+	if requireMtls {
+		tlsConfig.ClientAuth = tls.RequireAnyClientCert
+		tlsConfig.VerifyPeerCertificate = verifyMetricsClient
+	}
+
+	return tlsConfig
+}
+
+// verifyMetricsClient requires a client SPIFFE certificate issued by the CA
+// bundle, the authz rules of the workload don't apply to the scrapers
+func verifyMetricsClient(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	var certs []*x509.Certificate
+	for _, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return fmt.Errorf("unable to parse certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return errors.New("empty certificates chain")
+	}
+
+	pool, err := identity.GetCertPool()
+	if err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("could not verify client certificate: %w", err)
+	}
+
+	if _, err := identity.IDFromCert(certs[0]); err != nil {
+		return fmt.Errorf("could not get client SPIFFE ID: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package operations
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+func TestHandshakeCompleted(t *testing.T) {
+	prevResumed, prevEarlyData := resumedSessions, earlyDataConns
+	defer func() { resumedSessions, earlyDataConns = prevResumed, prevEarlyData }()
+
+	tests := []struct {
+		name        string
+		perspective logging.Perspective
+		attempted   bool
+		resumed     bool
+		used0RTT    bool
+		direction   string
+		wantResumed float64
+		wantStatus  string
+	}{
+		{"client full handshake", logging.PerspectiveClient, false, false, false, "outgoing", 0, ""},
+		{"client resumed without 0-RTT", logging.PerspectiveClient, false, true, false, "outgoing", 1, ""},
+		{"client 0-RTT accepted", logging.PerspectiveClient, true, true, true, "outgoing", 1, "accepted"},
+		{"client 0-RTT rejected", logging.PerspectiveClient, true, true, false, "outgoing", 1, "rejected"},
+		{"client 0-RTT rejected with a full handshake", logging.PerspectiveClient, true, false, false, "outgoing", 0, "rejected"},
+		{"server resumed", logging.PerspectiveServer, false, true, true, "incoming", 1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resumedSessions = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_resumed_total"}, []string{"direction"})
+			earlyDataConns = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_early_data_total"}, []string{"direction", "status"})
+
+			m := &metricsConnTracer{perspective: tt.perspective}
+			if tt.attempted {
+				m.RestoredTransportParameters(&logging.TransportParameters{})
+			}
+			var state quic.ConnectionState
+			state.TLS.DidResume = tt.resumed
+			state.TLS.Used0RTT = tt.used0RTT
+			m.handshakeCompleted(state)
+
+			if got := testutil.ToFloat64(resumedSessions.WithLabelValues(tt.direction)); got != tt.wantResumed {
+				t.Errorf("%g resumed sessions, want %g", got, tt.wantResumed)
+			}
+			for _, status := range []string{"accepted", "rejected"} {
+				want := 0.0
+				if status == tt.wantStatus {
+					want = 1
+				}
+				if got := testutil.ToFloat64(earlyDataConns.WithLabelValues(tt.direction, status)); got != want {
+					t.Errorf("%g %s early data connections, want %g", got, status, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package operations
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/operations/log"
+)
+
+// otlpInstrument is the OpenTelemetry instrument of a Prometheus metric
+type otlpInstrument struct {
+	name string
+	unit string
+	// attributes of the labels specific to the instrument
+	attrs map[string]string
+}
+
+var httpAttrs = map[string]string{
+	"instance": string(semconv.ServerAddressKey),
+	"method":   string(semconv.HTTPRequestMethodKey),
+	"path":     string(semconv.URLPathKey),
+	"status":   string(semconv.HTTPResponseStatusCodeKey),
+}
+
+// otlpInstruments are the metrics exported over OTLP, by Prometheus name
+var otlpInstruments = map[string]otlpInstrument{
+	// QUIC
+	"quic_connections_new_total":              {name: "quicsec.quic.connections.new", unit: "{connection}"},
+	"quic_connections_closed_total":           {name: "quicsec.quic.connections.closed", unit: "{connection}"},
+	"quic_connection_duration":                {name: "quicsec.quic.connection.duration", unit: "s"},
+	"quic_connection_errors_total":            {name: "quicsec.quic.connection.errors", unit: "{error}"},
+	"quic_transferred_bytes":                  {name: "quicsec.quic.transferred", unit: "By"},
+	"quic_transferred_packets_total":          {name: "quicsec.quic.packets.transferred", unit: "{packet}"},
+	"quic_packets_sent_total":                 {name: "quicsec.quic.packets.sent", unit: "{packet}"},
+	"quic_packets_rcvd_total":                 {name: "quicsec.quic.packets.received", unit: "{packet}"},
+	"quic_packets_buffered_total":             {name: "quicsec.quic.packets.buffered", unit: "{packet}"},
+	"quic_packets_dropped_total":              {name: "quicsec.quic.packets.dropped", unit: "{packet}"},
+	"quic_packets_lost_total":                 {name: "quicsec.quic.packets.lost", unit: "{packet}"},
+	"quic_congestion_state_changes_total":     {name: "quicsec.quic.congestion.state_changes", unit: "{change}"},
+	"quic_handshake_duration":                 {name: "quicsec.quic.handshake.duration", unit: "s"},
+	"quic_connection_smoothed_rtt":            {name: "quicsec.quic.connection.smoothed_rtt", unit: "s"},
+	"quic_connection_min_rtt":                 {name: "quicsec.quic.connection.min_rtt", unit: "s"},
+	"quic_connection_congestion_window_bytes": {name: "quicsec.quic.connection.congestion_window", unit: "By"},
+	"quic_connection_bytes_in_flight":         {name: "quicsec.quic.connection.bytes_in_flight", unit: "By"},
+	"quic_connection_loss_rate":               {name: "quicsec.quic.connection.loss_rate", unit: "1"},
+	"quic_connection_pto_count":               {name: "quicsec.quic.connection.pto_count", unit: "{timeout}"},
+	"quic_connection_key_updates":             {name: "quicsec.quic.connection.key_updates", unit: "{update}"},
+	"quic_tls_sessions_resumed_total":         {name: "quicsec.tls.sessions.resumed", unit: "{session}"},
+	"quic_early_data_connections_total":       {name: "quicsec.quic.early_data.connections", unit: "{connection}"},
+	"quic_pool_connections":                   {name: "quicsec.pool.connections", unit: "{connection}"},
+	"quic_pool_endpoints":                     {name: "quicsec.pool.endpoints", unit: "{endpoint}"},
+	"quic_datagrams_total":                    {name: "quicsec.quic.datagrams", unit: "{datagram}"},
+	"quic_datagrams_dropped_total":            {name: "quicsec.quic.datagrams.dropped", unit: "{datagram}"},
+	"quic_tunnel_connections_total":           {name: "quicsec.tunnel.connections", unit: "{connection}"},
+	"quic_tunnel_transferred_bytes":           {name: "quicsec.tunnel.transferred", unit: "By"},
+	"quic_tunnel_connection_duration":         {name: "quicsec.tunnel.connection.duration", unit: "s"},
+	"quic_webtransport_sessions_total":        {name: "quicsec.webtransport.sessions", unit: "{session}"},
+	"quic_webtransport_streams_total":         {name: "quicsec.webtransport.streams", unit: "{stream}"},
+	"quic_webtransport_session_duration": {name: "quicsec.webtransport.session.duration", unit: "s",
+		attrs: map[string]string{"path": string(semconv.URLPathKey)}},
+
+	// HTTP
+	"appedge_inbound_rq_total":             {name: "quicsec.http.server.requests", unit: "{request}", attrs: httpAttrs},
+	"appedge_outbound_rq_total":            {name: "quicsec.http.client.requests", unit: "{request}", attrs: httpAttrs},
+	"appedge_rq_path_labels_dropped_total": {name: "quicsec.http.path_labels.dropped", unit: "{request}"},
+	"appedge_inbound_rq_latency":           {name: "http.server.request.duration", unit: "s"},
+	"appedge_outbound_rq_latency":          {name: "http.client.request.duration", unit: "s"},
+	"appedge_rq_ttfb":                      {name: "quicsec.http.ttfb", unit: "s"},
+	"appedge_rq_stream_duration":           {name: "quicsec.http.stream.duration", unit: "s"},
+	"appedge_rq_body_size":                 {name: "quicsec.http.body.size", unit: "By"},
+	"appedge_connect_tunnels_total":        {name: "quicsec.http.connect.tunnels", unit: "{tunnel}"},
+	"appedge_connect_transferred_bytes":    {name: "quicsec.http.connect.transferred", unit: "By"},
+	"appedge_dns_cache_total":              {name: "quicsec.dns.cache.lookups", unit: "{lookup}"},
+	"appedge_dns_lookup_latency":           {name: "quicsec.dns.lookup.duration", unit: "s"},
+
+	// authz
+	"appedge_inbound_cx_total":  {name: "quicsec.authz.server.connections", unit: "{connection}"},
+	"appedge_outbound_cx_total": {name: "quicsec.authz.client.connections", unit: "{connection}"},
+
+	// log shipping
+	"appedge_log_entries_sent_total":    {name: "quicsec.log.entries.sent", unit: "{entry}"},
+	"appedge_log_entries_dropped_total": {name: "quicsec.log.entries.dropped", unit: "{entry}"},
+}
+
+// attributes of the labels shared by the instruments, the other labels are
+// prefixed with "quicsec."
+var otlpAttrs = map[string]string{
+	"myId":         "quicsec.spiffe_id",
+	"upstreamId":   "quicsec.peer.spiffe_id",
+	"downstreamId": "quicsec.peer.spiffe_id",
+	"peerId":       "quicsec.peer.spiffe_id",
+}
+
+// prometheusProducer produces the metrics of the Prometheus registry for the
+// OTLP exporter, so the instruments are only recorded once
+type prometheusProducer struct {
+	gatherer prometheus.Gatherer
+	start    time.Time
+}
+
+var _ sdkmetric.Producer = &prometheusProducer{}
+
+func (p *prometheusProducer) Produce(context.Context) ([]metricdata.ScopeMetrics, error) {
+	families, err := p.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return nil, err
+	}
+
+	now := time.Now()
+	scope := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{Name: "github.com/quicsec/quicsec"},
+	}
+	for _, family := range families {
+		instrument, ok := otlpInstruments[family.GetName()]
+		if !ok {
+			continue
+		}
+
+		m := metricdata.Metrics{
+			Name:        instrument.name,
+			Description: family.GetHelp(),
+			Unit:        instrument.unit,
+		}
+		switch family.GetType() {
+		case dto.MetricType_COUNTER:
+			sum := metricdata.Sum[float64]{Temporality: metricdata.CumulativeTemporality, IsMonotonic: true}
+			for _, metric := range family.GetMetric() {
+				sum.DataPoints = append(sum.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: instrument.attributes(metric.GetLabel()),
+					StartTime:  p.start,
+					Time:       now,
+					Value:      metric.GetCounter().GetValue(),
+				})
+			}
+			m.Data = sum
+		case dto.MetricType_GAUGE:
+			gauge := metricdata.Gauge[float64]{}
+			for _, metric := range family.GetMetric() {
+				gauge.DataPoints = append(gauge.DataPoints, metricdata.DataPoint[float64]{
+					Attributes: instrument.attributes(metric.GetLabel()),
+					Time:       now,
+					Value:      metric.GetGauge().GetValue(),
+				})
+			}
+			m.Data = gauge
+		case dto.MetricType_HISTOGRAM:
+			histogram := metricdata.Histogram[float64]{Temporality: metricdata.CumulativeTemporality}
+			for _, metric := range family.GetMetric() {
+				histogram.DataPoints = append(histogram.DataPoints,
+					histogramDataPoint(metric.GetHistogram(), instrument.attributes(metric.GetLabel()), p.start, now))
+			}
+			m.Data = histogram
+		default:
+			continue
+		}
+		scope.Metrics = append(scope.Metrics, m)
+	}
+
+	return []metricdata.ScopeMetrics{scope}, nil
+}
+
+func (i otlpInstrument) attributes(labels []*dto.LabelPair) attribute.Set {
+	kvs := make([]attribute.KeyValue, 0, len(labels))
+	for _, label := range labels {
+		key, ok := i.attrs[label.GetName()]
+		if !ok {
+			key, ok = otlpAttrs[label.GetName()]
+		}
+		if !ok {
+			key = "quicsec." + strings.ToLower(label.GetName())
+		}
+		if key == string(semconv.HTTPResponseStatusCodeKey) {
+			if code, err := strconv.Atoi(label.GetValue()); err == nil {
+				kvs = append(kvs, semconv.HTTPResponseStatusCode(code))
+				continue
+			}
+		}
+		kvs = append(kvs, attribute.String(key, label.GetValue()))
+	}
+
+	return attribute.NewSet(kvs...)
+}
+
+// histogramDataPoint converts the cumulative buckets of a Prometheus
+// histogram into the bucket counts of an OpenTelemetry histogram
+func histogramDataPoint(h *dto.Histogram, attrs attribute.Set, start, now time.Time) metricdata.HistogramDataPoint[float64] {
+	dp := metricdata.HistogramDataPoint[float64]{
+		Attributes: attrs,
+		StartTime:  start,
+		Time:       now,
+		Count:      h.GetSampleCount(),
+		Sum:        h.GetSampleSum(),
+	}
+
+	var cumulative uint64
+	for _, b := range h.GetBucket() {
+		dp.Bounds = append(dp.Bounds, b.GetUpperBound())
+		dp.BucketCounts = append(dp.BucketCounts, b.GetCumulativeCount()-cumulative)
+		cumulative = b.GetCumulativeCount()
+	}
+	// the +Inf bucket is implied
+	dp.BucketCounts = append(dp.BucketCounts, dp.Count-cumulative)
+
+	return dp
+}
+
+var meterProvider *sdkmetric.MeterProvider
+
+// otlpMetricsInit pushes the metrics of the Prometheus registry to the OTLP
+// collector every interval, the Prometheus exposition is kept
+func otlpMetricsInit() {
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+	conf := config.GetMetricsOtlpConfig()
+
+	opts := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpoint(conf.Endpoint)}
+	if conf.Insecure {
+		opts = append(opts, otlpmetrichttp.WithInsecure())
+	}
+	exporter, err := otlpmetrichttp.New(context.Background(), opts...)
+	if err != nil {
+		opsLogger.Error(err, "failed to create the OTLP metrics exporter")
+		return
+	}
+
+	producer := &prometheusProducer{gatherer: Registry, start: time.Now()}
+	reader := sdkmetric.NewPeriodicReader(exporter,
+		sdkmetric.WithInterval(conf.Interval),
+		sdkmetric.WithProducer(producer),
+	)
+	meterProvider = sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewSchemaless(semconv.ServiceName(conf.ServiceName))),
+	)
+
+	opsLogger.V(log.DebugLevel).Info("OTLP metrics exporter enabled", "endpoint", conf.Endpoint, "interval", conf.Interval)
+}
@@ -0,0 +1,120 @@
+package operations
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/instrumentation"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata/metricdatatest"
+)
+
+func TestPrometheusProducer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "appedge_inbound_rq_total",
+		Help: "HTTP requests",
+	}, []string{"myId", "downstreamId", "transport", "instance", "method", "path", "status"})
+	requests.WithLabelValues("spiffe://test.org/server", "spiffe://test.org/client", "h3", "bookstore", "GET", "/books", "200").Add(3)
+	reg.MustRegister(requests)
+
+	pool := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "quic_pool_connections",
+		Help: "Pooled connections",
+	}, []string{"state"})
+	pool.WithLabelValues("idle").Set(2)
+	reg.MustRegister(pool)
+
+	handshakes := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "quic_handshake_duration",
+		Help:    "Handshake durations",
+		Buckets: []float64{0.1, 0.5, 1},
+	}, []string{"direction", "peerId"})
+	for _, d := range []float64{0.05, 0.2, 0.3, 2, 5} {
+		handshakes.WithLabelValues("outgoing", "spiffe://test.org/server").Observe(d)
+	}
+	reg.MustRegister(handshakes)
+
+	// not exported over OTLP
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "go_test_total", Help: "Unmapped"}))
+
+	start := time.Now()
+	p := &prometheusProducer{gatherer: reg, start: start}
+	got, err := p.Produce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("%d scopes, want 1", len(got))
+	}
+
+	// the registry sorts the families by Prometheus name
+	want := metricdata.ScopeMetrics{
+		Scope: instrumentation.Scope{Name: "github.com/quicsec/quicsec"},
+		Metrics: []metricdata.Metrics{
+			{
+				Name:        "quicsec.http.server.requests",
+				Description: "HTTP requests",
+				Unit:        "{request}",
+				Data: metricdata.Sum[float64]{
+					Temporality: metricdata.CumulativeTemporality,
+					IsMonotonic: true,
+					DataPoints: []metricdata.DataPoint[float64]{{
+						Attributes: attribute.NewSet(
+							attribute.String("quicsec.spiffe_id", "spiffe://test.org/server"),
+							attribute.String("quicsec.peer.spiffe_id", "spiffe://test.org/client"),
+							attribute.String("quicsec.transport", "h3"),
+							attribute.String("server.address", "bookstore"),
+							attribute.String("http.request.method", "GET"),
+							attribute.String("url.path", "/books"),
+							attribute.Int("http.response.status_code", 200),
+						),
+						Value: 3,
+					}},
+				},
+			},
+			{
+				Name:        "quicsec.quic.handshake.duration",
+				Description: "Handshake durations",
+				Unit:        "s",
+				Data: metricdata.Histogram[float64]{
+					Temporality: metricdata.CumulativeTemporality,
+					DataPoints: []metricdata.HistogramDataPoint[float64]{{
+						Attributes: attribute.NewSet(
+							attribute.String("quicsec.direction", "outgoing"),
+							attribute.String("quicsec.peer.spiffe_id", "spiffe://test.org/server"),
+						),
+						Count:  5,
+						Sum:    7.55,
+						Bounds: []float64{0.1, 0.5, 1},
+						// (-Inf, 0.1], (0.1, 0.5], (0.5, 1] and the implied (1, +Inf)
+						BucketCounts: []uint64{1, 2, 0, 2},
+					}},
+				},
+			},
+			{
+				Name:        "quicsec.pool.connections",
+				Description: "Pooled connections",
+				Unit:        "{connection}",
+				Data: metricdata.Gauge[float64]{
+					DataPoints: []metricdata.DataPoint[float64]{{
+						Attributes: attribute.NewSet(attribute.String("quicsec.state", "idle")),
+						Value:      2,
+					}},
+				},
+			},
+		},
+	}
+
+	metricdatatest.AssertEqual(t, want, got[0], metricdatatest.IgnoreTimestamp())
+
+	for _, m := range got[0].Metrics {
+		if sum, ok := m.Data.(metricdata.Sum[float64]); ok && !sum.DataPoints[0].StartTime.Equal(start) {
+			t.Errorf("%s: start time %v, want %v", m.Name, sum.DataPoints[0].StartTime, start)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package operations
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+// OverflowPathLabel is the path label of the requests whose path matches
+// no route, or beyond the cap of distinct path labels
+const OverflowPathLabel = "other"
+
+// OverflowInstanceLabel is the instance label of the requests beyond the cap
+// of distinct instance labels
+const OverflowInstanceLabel = "other"
+
+// maxInstanceLabels caps the distinct instance labels (request hosts) by
+// identity pair and direction, the static upstreams excepted
+const maxInstanceLabels = 10
+
+type labelsKey struct {
+	direction string
+	myID      string
+	peerID    string
+}
+
+// cappedLabels are the distinct labels by identity pair
+type cappedLabels struct {
+	sync.Mutex
+	m map[labelsKey]map[string]struct{}
+}
+
+var (
+	pathLabels     = &cappedLabels{m: make(map[labelsKey]map[string]struct{})}
+	instanceLabels = &cappedLabels{m: make(map[labelsKey]map[string]struct{})}
+)
+
+// add reports whether label is one of the first max distinct labels of key
+func (c *cappedLabels) add(key labelsKey, label string, max int) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	labels, ok := c.m[key]
+	if !ok {
+		labels = make(map[string]struct{})
+		c.m[key] = labels
+	}
+	if _, ok := labels[label]; ok {
+		return true
+	}
+	if len(labels) >= max {
+		return false
+	}
+	labels[label] = struct{}{}
+
+	return true
+}
+
+// PathLabel returns the path label of the HTTP metrics of a request to path
+// (without the query string) between the identities myID and peerID: the
+// route template matching path when routes are configured, the path
+// otherwise. The overflow label replaces the paths matching no route and
+// the labels beyond metrics.max_path_labels for the identity pair.
+func PathLabel(direction, myID, peerID, path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+
+	label := path
+	if routes := config.GetMetricsRoutes(); routes != nil {
+		label = routeLabel(routes, path)
+		if label == OverflowPathLabel {
+			DroppedPathLabels.WithLabelValues(direction, "unmatched").Inc()
+			return label
+		}
+	}
+
+	maxLabels := config.GetMetricsMaxPathLabels()
+	if maxLabels == 0 {
+		return label
+	}
+
+	if !pathLabels.add(labelsKey{direction: direction, myID: myID, peerID: peerID}, label, maxLabels) {
+		DroppedPathLabels.WithLabelValues(direction, "max_path_labels").Inc()
+		return OverflowPathLabel
+	}
+
+	return label
+}
+
+// InstanceLabel returns the instance label of the HTTP metrics of a request
+// to host between the identities myID and peerID: the host name, lowercased
+// and without port. The host is set by the client, so apart from the static
+// upstreams the distinct labels are capped for the identity pair and the
+// overflow label replaces the others.
+func InstanceLabel(direction, myID, peerID, host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+
+	if len(config.GetUpstreams(host)) > 0 {
+		return host
+	}
+	if !instanceLabels.add(labelsKey{direction: direction, myID: myID, peerID: peerID}, host, maxInstanceLabels) {
+		return OverflowInstanceLabel
+	}
+
+	return host
+}
+
+// routeLabel returns the template of the first route matching path
+func routeLabel(routes []config.MetricsRoute, path string) string {
+	segments := strings.Split(path, "/")[1:]
+	for _, route := range routes {
+		if route.Match(segments) {
+			return route.Template
+		}
+	}
+	return OverflowPathLabel
+}
@@ -0,0 +1,103 @@
+package operations
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+func TestRouteLabel(t *testing.T) {
+	routes := []config.MetricsRoute{
+		{Template: "/books/{id}", Segments: []string{"books", "{id}"}},
+		{Template: "/books/{id}/reviews", Segments: []string{"books", "{id}", "reviews"}},
+		{Template: "/", Segments: []string{""}},
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/books/42", "/books/{id}"},
+		{"/books/42/reviews", "/books/{id}/reviews"},
+		{"/", "/"},
+		{"/books/", OverflowPathLabel},
+		{"/books", OverflowPathLabel},
+		{"/authors/1", OverflowPathLabel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := routeLabel(routes, tt.path); got != tt.want {
+				t.Errorf("routeLabel(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCappedLabels(t *testing.T) {
+	labels := &cappedLabels{m: make(map[labelsKey]map[string]struct{})}
+	alice := labelsKey{direction: "incoming", myID: "spiffe://test.org/server", peerID: "spiffe://test.org/alice"}
+	bob := labelsKey{direction: "incoming", myID: "spiffe://test.org/server", peerID: "spiffe://test.org/bob"}
+
+	tests := []struct {
+		key   labelsKey
+		label string
+		want  bool
+	}{
+		{alice, "/a", true},
+		{alice, "/b", true},
+		{alice, "/c", false},
+		// the labels already counted are kept
+		{alice, "/a", true},
+		{alice, "/b", true},
+		// the cap is by identity pair
+		{bob, "/c", true},
+		{bob, "/d", true},
+		{bob, "/e", false},
+	}
+
+	for _, tt := range tests {
+		if got := labels.add(tt.key, tt.label, 2); got != tt.want {
+			t.Errorf("add(%s, %q) = %v, want %v", tt.key.peerID, tt.label, got, tt.want)
+		}
+	}
+}
+
+func TestPathLabelQuery(t *testing.T) {
+	// no route and no cap configured, the path is the label
+	if got := PathLabel("incoming", "me", "peer", "/books/42?token=secret"); got != "/books/42" {
+		t.Errorf("PathLabel() = %q, want /books/42", got)
+	}
+}
+
+func TestInstanceLabel(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"Bookstore:8443", "bookstore"},
+		{"bookstore", "bookstore"},
+		{"[::1]:8443", "::1"},
+	}
+
+	for _, tt := range tests {
+		if got := InstanceLabel("outgoing", "me", "normalized", tt.host); got != tt.want {
+			t.Errorf("InstanceLabel(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	// hosts set by the clients beyond the cap
+	for i := 0; i < maxInstanceLabels; i++ {
+		host := "host" + strings.Repeat("x", i)
+		if got := InstanceLabel("incoming", "me", "capped", host); got != host {
+			t.Fatalf("InstanceLabel(%q) = %q before the cap", host, got)
+		}
+	}
+	if got := InstanceLabel("incoming", "me", "capped", "attacker.example"); got != OverflowInstanceLabel {
+		t.Errorf("InstanceLabel() = %q beyond the cap, want %q", got, OverflowInstanceLabel)
+	}
+	if got := InstanceLabel("incoming", "me", "capped", "HOST:443"); got != "host" {
+		t.Errorf("InstanceLabel() = %q for a counted host, want host", got)
+	}
+}
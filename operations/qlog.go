@@ -2,44 +2,414 @@ package operations
 
 import (
 	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/logging"
 	"github.com/quic-go/quic-go/qlog"
+	"github.com/quicsec/quicsec/config"
 	"github.com/quicsec/quicsec/operations/log"
-	"github.com/quicsec/quicsec/utils"
 )
 
+// H3_NO_ERROR (RFC 9114), the graceful close of the HTTP/3 connections
+const h3NoError = quic.ApplicationErrorCode(0x100)
+
+const (
+	// period of the retention passes, to expire the files by age
+	qlogRetentionInterval = time.Minute
+	// delay of the retention pass after a file is finished, so that the
+	// files finished together are handled by a single pass
+	qlogRetentionDelay = time.Second
+)
+
+// qlogTracer writes the qlog of the sampled connections in the qlog
+// directory and of the captured connections in the capture directory of the
+// admin API, one file per connection. The retention policy of the qlog
+// directory is enforced in the background, periodically and shortly after
+// files are finished; the captures are kept until removed by the admin API.
+type qlogTracer struct {
+	logging.NullTracer
+
+	conf config.QuicDebugConfigs
+	// capture directory, empty without the admin API
+	captureDir string
+
+	// signals the retention loop of a directory that a file is finished,
+	// read-only after qlogInit
+	finished map[string]chan struct{}
+}
+
 // qlog initialization - directory where all qlog files will be locate
 // create the path if it doesn't exist
 // based on the side, add 'server' or 'client' to easy identify the perspective
-func qlogInit(qlogDir string) logging.Tracer {
-	return qlog.NewTracer(func(pers logging.Perspective, connID []byte) io.WriteCloser {
-		// create the directory, if it doesn't exist
-		opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+func qlogInit(conf config.QuicDebugConfigs, admin config.AdminConfigs) logging.Tracer {
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+
+	t := &qlogTracer{conf: conf, finished: make(map[string]chan struct{})}
+	if admin.Enable {
+		t.captureDir = admin.CapturePath
+	}
 
-		if err := os.MkdirAll(qlogDir, 0777); err != nil {
+	for _, dir := range []string{t.qlogDir(), t.captureDir} {
+		if dir == "" {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
 			opsLogger.Error(err, "creating the qlog directory failed")
 			return nil
 		}
+	}
+	if dir := t.qlogDir(); dir != "" {
+		t.finished[dir] = make(chan struct{}, 1)
+		go t.retentionLoop(dir, t.finished[dir])
+	}
+
+	return t
+}
+
+// qlogDir is the directory of the sampled connections, empty when qlog is
+// only enabled for the captures
+func (t *qlogTracer) qlogDir() string {
+	if !t.conf.QlogEnableFlag {
+		return ""
+	}
+	return t.conf.QlogDirPath
+}
+
+func (t *qlogTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
+	sampled := t.conf.QlogEnableFlag && (t.conf.QlogSampleRate >= 1 || rand.Float64() < t.conf.QlogSampleRate)
+	capturing := t.captureDir != "" && capturingQlog()
+	if !sampled && !capturing {
+		return nil
+	}
+
+	dir := t.conf.QlogDirPath
+	if capturing {
+		dir = t.captureDir
+	}
+
+	state := &qlogConnState{sampled: sampled}
+	w, err := t.newQlogFile(dir, p, odcid, state)
+	if err != nil {
+		log.LoggerLgr.WithName(log.ConstOperationsManager).Error(err, "creating the qlog file failed")
+		return nil
+	}
+
+	// the peer identity of the captures
+	if tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64); ok {
+		state.tracingID = tracingID
+		qlogConns.Store(tracingID, state)
+	}
+
+	// the state is updated before the qlog tracer closes the file
+	return logging.NewMultiplexedConnectionTracer(state, qlog.NewConnectionTracer(w, p, odcid))
+}
+
+// newQlogFile creates the temporary file of the qlog of a connection in dir,
+// renamed when the connection is closed
+func (t *qlogTracer) newQlogFile(dir string, p logging.Perspective, odcid logging.ConnectionID, state *qlogConnState) (*qlogFile, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
 
-		t := time.Now().UTC().Format("2006-01-02T15-04-05.999999999UTC")
-		side := "server"
-		if pers == logging.PerspectiveClient {
-			side = "client"
+	ts := time.Now().UTC().Format("2006-01-02T15-04-05.999999999UTC")
+	side := "server"
+	if p == logging.PerspectiveClient {
+		side = "client"
+	}
+
+	name := fmt.Sprintf("%s_%s_%s.qlog", side, ts, odcid)
+	if t.conf.QlogCompress {
+		name += ".gz"
+	}
+
+	f, err := os.Create(filepath.Join(dir, name+".tmp"))
+	if err != nil {
+		return nil, err
+	}
+	log.LoggerLgr.WithName(log.ConstOperationsManager).V(log.DebugLevel).Info("qlog file created", "ODCID", odcid.String(), "path", f.Name())
+
+	q := &qlogFile{tracer: t, state: state, dir: dir, name: name, f: f}
+	var w io.Writer = f
+	if t.conf.QlogCompress {
+		q.gz = gzip.NewWriter(f)
+		w = q.gz
+	}
+	q.w = bufio.NewWriter(w)
+
+	return q, nil
+}
+
+// finalDir returns the directory of the qlog of the closed connection: the
+// capture directory when a capture claims it, the qlog directory when kept
+// by the sampling mode, empty when it is dropped
+func (t *qlogTracer) finalDir(state *qlogConnState) string {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if t.captureDir != "" && claimQlogCapture(state.peerID, state.remote) {
+		return t.captureDir
+	}
+	if state.sampled && t.keep(state) {
+		return t.conf.QlogDirPath
+	}
+	return ""
+}
+
+// keep reports whether the qlog of the closed connection is kept by the
+// sampling mode. Must be called with the state locked.
+func (t *qlogTracer) keep(state *qlogConnState) bool {
+	if t.conf.QlogSampleMode != config.QlogSampleAnomalies {
+		return true
+	}
+
+	if state.failed() {
+		return true
+	}
+	return t.conf.QlogRttThreshold > 0 && state.maxRTT > t.conf.QlogRttThreshold
+}
+
+// retentionLoop enforces the retention policy of dir at startup, every
+// qlogRetentionInterval and qlogRetentionDelay after files are finished
+func (t *qlogTracer) retentionLoop(dir string, finished chan struct{}) {
+	ticker := time.NewTicker(qlogRetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		t.enforceRetention(dir)
+
+		select {
+		case <-ticker.C:
+		case <-finished:
+			time.Sleep(qlogRetentionDelay)
+			// the files finished meanwhile are handled by this pass
+			select {
+			case <-finished:
+			default:
+			}
 		}
+	}
+}
+
+// retain signals the retention loop of dir that a file is finished, without
+// blocking the close of the connection. The capture directory has no loop.
+func (t *qlogTracer) retain(dir string) {
+	select {
+	case t.finished[dir] <- struct{}{}:
+	default:
+	}
+}
+
+// enforceRetention removes the qlog files of dir older than qlog_max_age,
+// then the oldest files beyond qlog_max_files or qlog_max_bytes. The
+// temporary files of the open connections are not counted.
+func (t *qlogTracer) enforceRetention(dir string) {
+	opsLogger := log.LoggerLgr.WithName(log.ConstOperationsManager)
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		opsLogger.Error(err, "reading the qlog directory failed")
+		return
+	}
 
-		filename := fmt.Sprintf("%s/%s_%s_%x.qlog", qlogDir, side, t, connID)
+	type qlogEntry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
 
-		f, err := os.Create(filename)
+	now := time.Now()
+	removed := 0
+
+	var files []qlogEntry
+	var total int64
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
 		if err != nil {
-			opsLogger.Error(err, "creating the qlog file failed")
+			continue
+		}
+
+		// the temporary files left by a stopped process only expire
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			if t.conf.QlogMaxAge > 0 && now.Sub(info.ModTime()) > t.conf.QlogMaxAge && os.Remove(filepath.Join(dir, e.Name())) == nil {
+				removed++
+			}
+			continue
+		}
+		if !strings.HasSuffix(e.Name(), ".qlog") && !strings.HasSuffix(e.Name(), ".qlog.gz") {
+			continue
 		}
-		opsLogger.V(log.DebugLevel).Info("qlog file created", "ODCID", fmt.Sprintf("%x", connID), "path", filename)
+		files = append(files, qlogEntry{
+			path:    filepath.Join(dir, e.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	for i, f := range files {
+		expired := t.conf.QlogMaxAge > 0 && now.Sub(f.modTime) > t.conf.QlogMaxAge
+		tooMany := t.conf.QlogMaxFiles > 0 && len(files)-i > t.conf.QlogMaxFiles
+		tooLarge := t.conf.QlogMaxBytes > 0 && total > t.conf.QlogMaxBytes
+		if !expired && !tooMany && !tooLarge {
+			break
+		}
+
+		if err := os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			opsLogger.Error(err, "removing the qlog file failed", "path", f.path)
+			continue
+		}
+		total -= f.size
+		removed++
+	}
+
+	if removed > 0 {
+		opsLogger.V(log.DebugLevel).Info("qlog files removed by the retention policy", "count", removed)
+	}
+}
+
+// qlogConns are the states of the traced connections by tracing ID
+// (quic.ConnectionTracingKey), to match the captures by peer identity
+var qlogConns sync.Map
+
+// qlogConnState is the outcome of a traced connection, used by the anomalies
+// sampling mode and the captures
+type qlogConnState struct {
+	logging.NullConnectionTracer
+
+	// traced by the sample rate
+	sampled   bool
+	tracingID uint64
+
+	mutex             sync.Mutex
+	peerID            string
+	remote            string
+	handshakeComplete bool
+	maxRTT            time.Duration
+	closeErr          error
+}
+
+func (s *qlogConnState) StartedConnection(_, remote net.Addr, _, _ logging.ConnectionID) {
+	s.mutex.Lock()
+	s.remote = remote.String()
+	s.mutex.Unlock()
+}
+
+func (s *qlogConnState) UpdatedMetrics(rttStats *logging.RTTStats, _, _ logging.ByteCount, _ int) {
+	s.mutex.Lock()
+	if rtt := rttStats.SmoothedRTT(); rtt > s.maxRTT {
+		s.maxRTT = rtt
+	}
+	s.mutex.Unlock()
+}
+
+func (s *qlogConnState) DroppedEncryptionLevel(level logging.EncryptionLevel) {
+	if level == logging.EncryptionHandshake {
+		s.mutex.Lock()
+		s.handshakeComplete = true
+		s.mutex.Unlock()
+	}
+}
+
+func (s *qlogConnState) ClosedConnection(e error) {
+	s.mutex.Lock()
+	s.closeErr = e
+	s.mutex.Unlock()
+}
+
+func (s *qlogConnState) Close() {
+	qlogConns.Delete(s.tracingID)
+}
+
+// failed reports whether the handshake didn't complete or the connection was
+// closed with an error code, the idle timeouts are not failures. Must be
+// called with the state locked.
+func (s *qlogConnState) failed() bool {
+	if !s.handshakeComplete {
+		return true
+	}
+
+	var (
+		applicationErr *quic.ApplicationError
+		transportErr   *quic.TransportError
+		idleTimeoutErr *quic.IdleTimeoutError
+	)
+	switch {
+	case s.closeErr == nil:
+		return false
+	case errors.As(s.closeErr, &applicationErr):
+		return applicationErr.ErrorCode != 0 && applicationErr.ErrorCode != h3NoError
+	case errors.As(s.closeErr, &transportErr):
+		return transportErr.ErrorCode != quic.NoError
+	case errors.As(s.closeErr, &idleTimeoutErr):
+		return false
+	default:
+		return true
+	}
+}
+
+// qlogFile is the qlog of a connection, written to a temporary file until
+// the connection is closed
+type qlogFile struct {
+	tracer *qlogTracer
+	state  *qlogConnState
+	dir    string
+	name   string
+
+	f  *os.File
+	gz *gzip.Writer
+	w  *bufio.Writer
+}
+
+func (q *qlogFile) Write(p []byte) (int, error) {
+	return q.w.Write(p)
+}
+
+// Close finishes the file, then moves it (without the temporary suffix) to
+// the capture or the qlog directory, or removes it
+func (q *qlogFile) Close() error {
+	err := q.w.Flush()
+	if q.gz != nil {
+		if gzErr := q.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := q.f.Close(); err == nil {
+		err = closeErr
+	}
+
+	tmpPath := filepath.Join(q.dir, q.name+".tmp")
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	dir := q.tracer.finalDir(q.state)
+	if dir == "" {
+		return os.Remove(tmpPath)
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(dir, q.name)); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	q.tracer.retain(dir)
 
-		return utils.NewBufferedWriteCloser(bufio.NewWriter(f), f)
-	})
+	return nil
 }
@@ -0,0 +1,118 @@
+package operations
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QlogCapture captures the qlog of the next connections, of a peer identity
+// or a remote address when set, in the capture directory of the admin API
+type QlogCapture struct {
+	ID         string    `json:"id"`
+	PeerID     string    `json:"peer_id,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Remaining  int       `json:"remaining"`
+	Captured   int       `json:"captured"`
+	Created    time.Time `json:"created"`
+}
+
+// match reports whether the connection with the peer identity peerID and
+// the remote address remote (host:port) is captured. RemoteAddr matches
+// the host or the host and the port.
+func (c *QlogCapture) match(peerID, remote string) bool {
+	if c.PeerID != "" && c.PeerID != peerID {
+		return false
+	}
+	if c.RemoteAddr != "" && c.RemoteAddr != remote {
+		if host, _, err := net.SplitHostPort(remote); err != nil || host != c.RemoteAddr {
+			return false
+		}
+	}
+	return true
+}
+
+var qlogCaptures = struct {
+	sync.Mutex
+	m    map[string]*QlogCapture
+	next int
+}{m: make(map[string]*QlogCapture)}
+
+// StartQlogCapture starts capturing the qlog of the next connections
+// matching the peer identity and the remote address, if set
+func StartQlogCapture(connections int, peerID, remoteAddr string) QlogCapture {
+	qlogCaptures.Lock()
+	defer qlogCaptures.Unlock()
+
+	qlogCaptures.next++
+	c := &QlogCapture{
+		ID:         strconv.Itoa(qlogCaptures.next),
+		PeerID:     peerID,
+		RemoteAddr: remoteAddr,
+		Remaining:  connections,
+		Created:    time.Now(),
+	}
+	qlogCaptures.m[c.ID] = c
+
+	return *c
+}
+
+// StopQlogCapture stops the capture id, false when it doesn't exist or is
+// complete
+func StopQlogCapture(id string) bool {
+	qlogCaptures.Lock()
+	defer qlogCaptures.Unlock()
+
+	_, ok := qlogCaptures.m[id]
+	delete(qlogCaptures.m, id)
+	return ok
+}
+
+// QlogCaptures returns the running captures, oldest first
+func QlogCaptures() []QlogCapture {
+	qlogCaptures.Lock()
+	defer qlogCaptures.Unlock()
+
+	captures := make([]QlogCapture, 0, len(qlogCaptures.m))
+	for _, c := range qlogCaptures.m {
+		captures = append(captures, *c)
+	}
+	sort.Slice(captures, func(i, j int) bool { return captures[i].Created.Before(captures[j].Created) })
+
+	return captures
+}
+
+// capturingQlog reports whether a capture is running, the new connections
+// are traced until they are claimed or closed
+func capturingQlog() bool {
+	qlogCaptures.Lock()
+	defer qlogCaptures.Unlock()
+
+	return len(qlogCaptures.m) > 0
+}
+
+// claimQlogCapture claims a connection for the oldest running capture
+// matching it, the capture completes with its last connection
+func claimQlogCapture(peerID, remote string) bool {
+	qlogCaptures.Lock()
+	defer qlogCaptures.Unlock()
+
+	var claimed *QlogCapture
+	for _, c := range qlogCaptures.m {
+		if c.match(peerID, remote) && (claimed == nil || c.Created.Before(claimed.Created)) {
+			claimed = c
+		}
+	}
+	if claimed == nil {
+		return false
+	}
+
+	claimed.Remaining--
+	claimed.Captured++
+	if claimed.Remaining <= 0 {
+		delete(qlogCaptures.m, claimed.ID)
+	}
+	return true
+}
@@ -0,0 +1,151 @@
+package operations
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quicsec/quicsec/config"
+)
+
+func TestQlogConnStateFailed(t *testing.T) {
+	tests := []struct {
+		name              string
+		handshakeComplete bool
+		closeErr          error
+		want              bool
+	}{
+		{"handshake not completed", false, nil, true},
+		{"handshake timeout", false, &quic.HandshakeTimeoutError{}, true},
+		{"still open", true, nil, false},
+		{"application no error", true, &quic.ApplicationError{ErrorCode: 0}, false},
+		{"H3_NO_ERROR", true, &quic.ApplicationError{ErrorCode: h3NoError}, false},
+		{"application error", true, &quic.ApplicationError{ErrorCode: 0x101}, true},
+		{"transport no error", true, &quic.TransportError{ErrorCode: quic.NoError}, false},
+		{"transport error", true, &quic.TransportError{ErrorCode: quic.ProtocolViolation}, true},
+		{"idle timeout", true, &quic.IdleTimeoutError{}, false},
+		{"stateless reset", true, &quic.StatelessResetError{}, true},
+		{"other error", true, errors.New("boom"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &qlogConnState{handshakeComplete: tt.handshakeComplete, closeErr: tt.closeErr}
+			if got := s.failed(); got != tt.want {
+				t.Errorf("failed() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceRetention(t *testing.T) {
+	now := time.Now()
+
+	// name, size and age of the files of the directory
+	type file struct {
+		name string
+		size int
+		age  time.Duration
+	}
+	files := []file{
+		{"client_1.qlog", 100, 10 * time.Hour},
+		{"client_2.qlog.gz", 100, 3 * time.Hour},
+		{"client_3.qlog", 100, 2 * time.Hour},
+		{"client_4.qlog", 100, time.Hour},
+		{"client_5.qlog.tmp", 1000, time.Minute},
+		{"client_6.qlog.tmp", 1000, 10 * time.Hour},
+		{"notes.txt", 1000, 10 * time.Hour},
+	}
+
+	tests := []struct {
+		name string
+		conf config.QuicDebugConfigs
+		want []string
+	}{
+		{"unlimited", config.QuicDebugConfigs{},
+			[]string{"client_1.qlog", "client_2.qlog.gz", "client_3.qlog", "client_4.qlog", "client_5.qlog.tmp", "client_6.qlog.tmp", "notes.txt"}},
+		{"max age", config.QuicDebugConfigs{QlogMaxAge: 5 * time.Hour},
+			[]string{"client_2.qlog.gz", "client_3.qlog", "client_4.qlog", "client_5.qlog.tmp", "notes.txt"}},
+		{"max files", config.QuicDebugConfigs{QlogMaxFiles: 2},
+			[]string{"client_3.qlog", "client_4.qlog", "client_5.qlog.tmp", "client_6.qlog.tmp", "notes.txt"}},
+		{"max bytes", config.QuicDebugConfigs{QlogMaxBytes: 250},
+			[]string{"client_3.qlog", "client_4.qlog", "client_5.qlog.tmp", "client_6.qlog.tmp", "notes.txt"}},
+		{"all limits", config.QuicDebugConfigs{QlogMaxAge: 5 * time.Hour, QlogMaxFiles: 3, QlogMaxBytes: 100},
+			[]string{"client_4.qlog", "client_5.qlog.tmp", "notes.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range files {
+				path := filepath.Join(dir, f.name)
+				if err := os.WriteFile(path, make([]byte, f.size), 0600); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(path, now.Add(-f.age), now.Add(-f.age)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			(&qlogTracer{conf: tt.conf}).enforceRetention(dir)
+
+			if got := dirNames(t, dir); !equalNames(got, tt.want) {
+				t.Errorf("files kept %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQlogRetentionSignal(t *testing.T) {
+	dir := t.TempDir()
+	tracer := &qlogTracer{finished: map[string]chan struct{}{dir: make(chan struct{}, 1)}}
+
+	// the close of the connections never blocks on the retention loop
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			tracer.retain(dir)
+		}
+		tracer.retain("unknown")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("retain blocked")
+	}
+
+	if n := len(tracer.finished[dir]); n != 1 {
+		t.Errorf("%d pending signals, want 1", n)
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
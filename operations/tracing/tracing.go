@@ -0,0 +1,144 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/quicsec/quicsec/config"
+)
+
+const tracerName = "github.com/quicsec/quicsec"
+
+// SPIFFE identity attributes of the spans
+const (
+	AttrIdentity     = attribute.Key("quicsec.spiffe_id")
+	AttrPeerIdentity = attribute.Key("quicsec.peer.spiffe_id")
+	AttrTransport    = attribute.Key("quicsec.transport")
+)
+
+// W3C trace context (traceparent and tracestate headers)
+var propagator = propagation.TraceContext{}
+
+var provider *sdktrace.TracerProvider
+
+// Init sets up the tracer provider with the exporter of the configuration.
+// The spans are dropped until Init is called.
+func Init() error {
+	conf := config.GetTracingConfig()
+
+	exporter, err := newExporter(conf)
+	if err != nil {
+		return err
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(conf.SampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(conf.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagator)
+
+	return nil
+}
+
+// newExporter returns the span exporter of conf: an OTLP/HTTP exporter or
+// a JSON exporter appending to the file of conf.Path
+func newExporter(conf config.TracingConfigs) (sdktrace.SpanExporter, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch conf.Exporter {
+	case config.TracingExporterOtlp:
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case config.TracingExporterFile:
+		var f *os.File
+		f, err = os.OpenFile(conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: failed to open %s: %w", conf.Path, err)
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		err = fmt.Errorf("unknown exporter %q", conf.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: failed to create the exporter: %w", err)
+	}
+
+	return exporter, nil
+}
+
+// Shutdown flushes the spans not exported yet
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Start starts a span of kind with the SPIFFE ID of this workload
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs, AttrIdentity.String(config.GetIdentity().String()))
+
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// End records err, if any, and ends span
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Inject writes the trace context of ctx in the traceparent and tracestate
+// headers
+func Inject(ctx context.Context, header http.Header) {
+	if provider == nil {
+		return
+	}
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Extract returns ctx with the remote trace context of the traceparent and
+// tracestate headers
+func Extract(ctx context.Context, header http.Header) context.Context {
+	if provider == nil {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Detach returns a background context carrying the span of ctx, without its
+// deadline and cancellation
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(context.Background(), trace.SpanContextFromContext(ctx))
+}
+
+// RequestContext returns the context of an outgoing request. Without a span
+// in it (e.g. a request forwarded by a proxy), the context carries the trace
+// context of the request headers.
+func RequestContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+	return Extract(ctx, r.Header)
+}
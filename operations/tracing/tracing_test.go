@@ -0,0 +1,171 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/quicsec/quicsec/config"
+	"github.com/quicsec/quicsec/spiffeid"
+)
+
+func TestNewExporter(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		conf    config.TracingConfigs
+		wantErr bool
+	}{
+		{"otlp", config.TracingConfigs{Exporter: config.TracingExporterOtlp, Endpoint: "localhost:4318", Insecure: true}, false},
+		{"file", config.TracingConfigs{Exporter: config.TracingExporterFile, Path: filepath.Join(dir, "traces.json")}, false},
+		{"file in a missing directory", config.TracingConfigs{Exporter: config.TracingExporterFile, Path: filepath.Join(dir, "missing", "traces.json")}, true},
+		{"unknown exporter", config.TracingConfigs{Exporter: "jaeger"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter, err := newExporter(tt.conf)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newExporter() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if exporter != nil {
+				exporter.Shutdown(context.Background())
+			}
+		})
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	exporter, err := newExporter(config.TracingConfigs{Exporter: config.TracingExporterFile, Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setProvider(t, sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	id, err := spiffeid.FromString("spiffe://example.org/client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.SetIdentity(id)
+
+	ctx, parent := Start(context.Background(), "HTTP GET", trace.SpanKindClient, AttrTransport.String("h3"))
+	_, child := Start(ctx, "DNS lookup", trace.SpanKindInternal)
+	End(child, nil)
+	End(parent, os.ErrDeadlineExceeded)
+	if err := provider.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type span struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Attributes  []struct {
+			Key   string
+			Value struct{ Value interface{} }
+		}
+		Status struct{ Code string }
+	}
+	var spans []span
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	for dec.More() {
+		var s span
+		if err := dec.Decode(&s); err != nil {
+			t.Fatalf("invalid span in %s: %v", data, err)
+		}
+		spans = append(spans, s)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	child0, parent0 := spans[0], spans[1]
+	if child0.Name != "DNS lookup" || parent0.Name != "HTTP GET" {
+		t.Errorf("span names = %q, %q", child0.Name, parent0.Name)
+	}
+	if child0.SpanContext.TraceID != parent0.SpanContext.TraceID {
+		t.Errorf("child trace %s, want %s", child0.SpanContext.TraceID, parent0.SpanContext.TraceID)
+	}
+	if parent0.Status.Code != "Error" {
+		t.Errorf("parent status = %q, want Error", parent0.Status.Code)
+	}
+
+	attrs := map[string]interface{}{}
+	for _, a := range parent0.Attributes {
+		attrs[a.Key] = a.Value.Value
+	}
+	if attrs[string(AttrIdentity)] != "spiffe://example.org/client" {
+		t.Errorf("%s = %v", AttrIdentity, attrs[string(AttrIdentity)])
+	}
+	if attrs[string(AttrTransport)] != "h3" {
+		t.Errorf("%s = %v", AttrTransport, attrs[string(AttrTransport)])
+	}
+}
+
+func TestPropagation(t *testing.T) {
+	header := http.Header{}
+	ctx, span := otel.Tracer(tracerName).Start(context.Background(), "test")
+	defer span.End()
+
+	setProvider(t, nil)
+	Inject(ctx, header)
+	if len(header) != 0 {
+		t.Errorf("headers injected without a provider: %v", header)
+	}
+
+	setProvider(t, sdktrace.NewTracerProvider())
+	ctx, span = otel.GetTracerProvider().Tracer(tracerName).Start(context.Background(), "test")
+	defer span.End()
+	Inject(ctx, header)
+	if header.Get("traceparent") == "" {
+		t.Fatal("no traceparent header injected")
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "https://example.org/", nil)
+	r.Header = header
+	got := trace.SpanContextFromContext(RequestContext(r))
+	if got.TraceID() != span.SpanContext().TraceID() || got.SpanID() != span.SpanContext().SpanID() || !got.IsRemote() {
+		t.Errorf("extracted span context %+v, want the remote %+v", got, span.SpanContext())
+	}
+
+	// the span of the request context wins over its headers
+	local, localSpan := provider.Tracer(tracerName).Start(context.Background(), "local")
+	defer localSpan.End()
+	if got := trace.SpanContextFromContext(RequestContext(r.WithContext(local))); got.SpanID() != localSpan.SpanContext().SpanID() {
+		t.Errorf("request context span %s, want %s", got.SpanID(), localSpan.SpanContext().SpanID())
+	}
+
+	// a detached context keeps the span, not the cancellation
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	detached := Detach(canceled)
+	if detached.Err() != nil || trace.SpanContextFromContext(detached).SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("detached context err %v, span %s", detached.Err(), trace.SpanContextFromContext(detached).SpanID())
+	}
+}
+
+// setProvider installs p as the tracer provider of the test
+func setProvider(t *testing.T, p *sdktrace.TracerProvider) {
+	prev, prevGlobal := provider, otel.GetTracerProvider()
+	provider = p
+	if p != nil {
+		otel.SetTracerProvider(p)
+	}
+	t.Cleanup(func() {
+		provider = prev
+		otel.SetTracerProvider(prevGlobal)
+	})
+}
@@ -1,12 +1,18 @@
 package quicsec
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go"
+
+	"github.com/quicsec/quicsec/auth"
 	"github.com/quicsec/quicsec/conn"
+	"github.com/quicsec/quicsec/operations"
 	"github.com/quicsec/quicsec/operations/log"
 )
 
@@ -49,3 +55,53 @@ func Do(req *http.Request) (*http.Response, error) {
 
 	return resp, err
 }
+
+// HandleWebTransport registers the handler of the WebTransport sessions
+// established on path (see webtransport.enable)
+func HandleWebTransport(path string, handler conn.WebTransportHandler) {
+	conn.HandleWebTransport(path, handler)
+}
+
+// SetRequestAuthorizer sets the authorizer of the requests establishing
+// sessions, called after the SPIFFE authorization
+func SetRequestAuthorizer(a auth.RequestAuthorizer) {
+	auth.SetRequestAuthorizer(a)
+}
+
+// Listen returns a QUIC listener on addr with the QuicSec identity and
+// authorization, for the protocols that don't run over HTTP. nextProtos
+// defaults to conn.NextProtoRaw.
+func Listen(addr string, nextProtos ...string) (quic.Listener, error) {
+	return conn.Listen(addr, nextProtos...)
+}
+
+// Dial dials a QUIC connection to addr with the QuicSec identity and
+// authorization, for the protocols that don't run over HTTP. nextProtos
+// defaults to conn.NextProtoRaw.
+func Dial(ctx context.Context, addr string, nextProtos ...string) (quic.Connection, error) {
+	return conn.Dial(ctx, addr, nextProtos...)
+}
+
+// PeerID returns the SPIFFE ID of the peer of a QUIC connection
+func PeerID(qconn quic.Connection) string {
+	return conn.PeerID(qconn)
+}
+
+// RequestDatagrams answers the request with 200 and returns the HTTP
+// Datagrams of its stream
+func RequestDatagrams(w http.ResponseWriter, r *http.Request) (*conn.Datagrams, error) {
+	return conn.RequestDatagrams(w, r)
+}
+
+// ConnDatagrams returns the QUIC datagrams of a connection returned by Dial
+// or Listen
+func ConnDatagrams(qconn quic.Connection) (*conn.Datagrams, error) {
+	return conn.ConnDatagrams(qconn)
+}
+
+// MetricsRegistry returns the Prometheus registry of the QuicSec metrics, to
+// register the application collectors on it or to gather it with the
+// application registry
+func MetricsRegistry() *prometheus.Registry {
+	return operations.Registry
+}
@@ -1,9 +1,12 @@
 package utils
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"sync"
+	"time"
 )
 
 const (
@@ -11,25 +14,176 @@ const (
 )
 
 // createFileRotate - create(append) in the file point by 'filePath'
-// If the size of the file reach the 'maxSize', delete and create a
+// If the size of the file reach the 'maxSize' (MiB), delete and create a
 // new one
 func CreateFileRotate(filePath string, maxSize int64) io.Writer {
-	var size int64
+	f, err := NewRotatingFile(filePath, maxSize*MiB, 0, 0)
+	if err != nil {
+		log.Fatal(err)
+		return nil
+	}
 
-	fs, err := os.Stat(filePath)
-	if err == nil {
-		size = fs.Size()
+	return f
+}
+
+// RotatingFile is a file readable by its owner only (0600), rotated while
+// running once it reaches maxSize bytes or is older than maxAge. The rotated
+// files are kept as path.1 (the most recent) to path.maxBackups, the others
+// are removed. Each Write goes entirely to a single file. A file that
+// couldn't be reopened after a rotation is reopened by the next Write.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mutex  sync.Mutex
+	f      *os.File
+	closed bool
+	size   int64
+	opened time.Time
+}
+
+// NewRotatingFile opens (appends to) the file at path, 0 disables the size
+// or the age rotation. An existing file that already exceeds the limits is
+// rotated first.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+
+	if fi, err := os.Stat(path); err == nil {
+		r.size = fi.Size()
+		r.opened = fi.ModTime()
+		if r.exceeded(0) {
+			if err := r.shift(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return 0, os.ErrClosed
+	}
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	// a failed rotation is retried by the next Write
+	if r.size > 0 && r.exceeded(int64(len(p))) {
+		if err := r.rotate(); err != nil && r.f == nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+
+	return n, err
+}
+
+func (r *RotatingFile) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	if r.f == nil {
+		return nil
 	}
+	err := r.f.Close()
+	r.f = nil
+
+	return err
+}
 
-	if size > (maxSize * MiB) {
-		os.Remove(filePath)
+// exceeded reports whether writing n more bytes exceeds the limits
+func (r *RotatingFile) exceeded(n int64) bool {
+	if r.maxSize > 0 && r.size+n > r.maxSize {
+		return true
 	}
+	return r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+}
 
-	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+// open creates the file or appends to it. An existing file created with
+// wider permissions is restricted as well.
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = fi.Size()
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+
+	return nil
+}
+
+// rotate must be called with the file locked. The file is reopened when
+// the shift fails, so that the writes go on.
+func (r *RotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+	if err == nil {
+		err = r.shift()
+	}
+
+	if openErr := r.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+// shift moves the file to path.1, the backups to the next index and removes
+// the ones beyond maxBackups
+func (r *RotatingFile) shift() error {
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 		return nil
 	}
 
-	return f
+	if err := os.Remove(r.backup(r.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(r.backup(i), r.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(r.path, r.backup(1))
+}
+
+func (r *RotatingFile) backup(i int) string {
+	return fmt.Sprintf("%s.%d", r.path, i)
 }
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileShift(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxBackups int
+		// contents of path, path.1, path.2... before the shift
+		before []string
+		// contents of path.1, path.2... after the shift, path is removed
+		want []string
+	}{
+		{"no backups", 0, []string{"cur", "b1"}, []string{"b1"}},
+		{"first backup", 1, []string{"cur"}, []string{"cur"}},
+		{"replace the backup", 1, []string{"cur", "b1"}, []string{"cur"}},
+		{"shift the backups", 3, []string{"cur", "b1", "b2"}, []string{"cur", "b1", "b2"}},
+		{"drop the oldest backup", 3, []string{"cur", "b1", "b2", "b3"}, []string{"cur", "b1", "b2"}},
+		{"missing backup", 3, []string{"cur", "b1", "", "b3"}, []string{"cur", "b1", "", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RotatingFile{path: filepath.Join(t.TempDir(), "keys.txt"), maxBackups: tt.maxBackups}
+			for i, content := range tt.before {
+				if content == "" {
+					continue
+				}
+				path := r.path
+				if i > 0 {
+					path = r.backup(i)
+				}
+				if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := r.shift(); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := os.Stat(r.path); !os.IsNotExist(err) {
+				t.Errorf("%s not moved: %v", r.path, err)
+			}
+			for i := 1; i <= len(tt.want) || i <= len(tt.before); i++ {
+				want := ""
+				if i <= len(tt.want) {
+					want = tt.want[i-1]
+				}
+				if got := readFile(t, r.backup(i)); got != want {
+					t.Errorf("backup %d = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRotatingFileRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	r, err := NewRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, line := range []string{"line1\n", "line2\n", "line3\n"} {
+		if n, err := r.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("Write(%q) = %d, %v", line, n, err)
+		}
+	}
+
+	if got := readFile(t, path); got != "line3\n" {
+		t.Errorf("file = %q", got)
+	}
+	if got := readFile(t, r.backup(1)); got != "line2\n" {
+		t.Errorf("backup 1 = %q", got)
+	}
+	if got := readFile(t, r.backup(2)); got != "line1\n" {
+		t.Errorf("backup 2 = %q", got)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("file mode %v, %v", fi.Mode(), err)
+	}
+}
+
+func TestRotatingFileFailedRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	r, err := NewRotatingFile(path, 10, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// a directory in place of the backup fails the shift
+	if err := os.MkdirAll(filepath.Join(r.backup(1), "busy"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"line1\n", "line2\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) failed with a failed rotation: %v", line, err)
+		}
+	}
+	if got := readFile(t, path); got != "line1\nline2\n" {
+		t.Errorf("file = %q", got)
+	}
+
+	// the rotation is retried by the next write
+	if err := os.RemoveAll(r.backup(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("line3\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "line3\n" {
+		t.Errorf("file = %q after the rotation", got)
+	}
+
+	// a file not reopened is reopened by the next write
+	r.f.Close()
+	r.f = nil
+	if _, err := r.Write([]byte("line4\n")); err != nil {
+		t.Fatalf("Write() didn't reopen the file: %v", err)
+	}
+	if got, backup := readFile(t, path), readFile(t, r.backup(1)); got != "line4\n" || backup != "line3\n" {
+		t.Errorf("file = %q, backup = %q after the reopen", got, backup)
+	}
+
+	// unlike a closed file
+	r.Close()
+	if _, err := r.Write([]byte("line5\n")); err != os.ErrClosed {
+		t.Errorf("Write() after Close = %v, want %v", err, os.ErrClosed)
+	}
+}
+
+func TestCreateFileRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	if err := os.WriteFile(path, make([]byte, MiB+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file larger than maxSize is replaced
+	w := CreateFileRotate(path, 1)
+	defer w.(*RotatingFile).Close()
+	if _, err := w.Write([]byte("entry\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, path); got != "entry\n" {
+		t.Errorf("file = %q", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("backup kept: %v", err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return ""
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}